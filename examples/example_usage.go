@@ -33,13 +33,8 @@ func main() {
 	// Set system prompt
 	geminiModel.SetSystemPrompt("You are a helpful AI assistant.")
 
-	// Get model info
-	fmt.Println("Model Info:")
-	info := geminiModel.GetModelInfo()
-	for key, value := range info {
-		fmt.Printf("%s: %v\n", key, value)
-	}
-	fmt.Println()
+	// Show the model in use
+	fmt.Printf("Model: %s\n\n", geminiModel.Name())
 
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -2,45 +2,190 @@ package agent
 
 import (
 	"context"
+	"discord-gemini-bot/src/logging"
+	"discord-gemini-bot/src/metrics"
 	"discord-gemini-bot/src/models"
 	"discord-gemini-bot/src/prompts"
 	"discord-gemini-bot/src/tools"
+	"discord-gemini-bot/src/tracing"
 	"discord-gemini-bot/src/types"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"regexp"
+	"io"
+	"log/slog"
 	"strings"
 )
 
+// DefaultMaxSteps is the default number of Thought/Action/Observation steps per response
+const DefaultMaxSteps = 5
+
+// BlockedReply is the answer given when the model's response was blocked by a content filter
+const BlockedReply = "Sorry, I can't help with that: my reply was blocked by the content filter."
+
+// TruncatedNotice is appended to answers that were cut off at the output token limit
+const TruncatedNotice = "\n\n*(My reply was cut off because it reached the length limit.)*"
+
+// Options configures an agent
+type Options struct {
+	// MaxSteps is the maximum number of model calls made for a single response
+	MaxSteps int
+	// Persona replaces the default persona at the start of the agent's system prompt
+	Persona string
+	// Generation overrides the model's generation settings for this agent's requests
+	Generation models.GenerateOptions
+	// Debug makes GetResponse return the full transcript of the run, including
+	// thoughts, actions and observations, instead of only the final answer
+	Debug bool
+	// NativeFunctionCalling declares tools to the model as functions instead of
+	// parsing ReAct actions from text, when the model supports it
+	NativeFunctionCalling bool
+}
+
+// DefaultOptions returns the default agent options
+func DefaultOptions() Options {
+	return Options{
+		MaxSteps: DefaultMaxSteps,
+	}
+}
+
+// Step records a single Thought/Action/Observation iteration of the agent loop
+type Step struct {
+	Thought     string `json:"thought,omitempty"`
+	Action      string `json:"action,omitempty"`
+	ActionInput string `json:"action_input,omitempty"`
+	Observation string `json:"observation,omitempty"`
+	Output      string `json:"output"`
+}
+
+// Result is the outcome of an agent run, including the trace of every step
+type Result struct {
+	Response string `json:"response"`
+	Steps    []Step `json:"steps"`
+	// Completed is false when the step limit was reached before a final answer
+	Completed bool `json:"completed"`
+	// FinishReason is why the model stopped generating its last response
+	FinishReason models.FinishReason `json:"finish_reason,omitempty"`
+	// Usage is the number of tokens used by every model request of the run
+	Usage models.Usage `json:"usage"`
+}
+
+// record adds the usage of a model response to the result and keeps its finish reason
+func (r *Result) record(response *models.Response) {
+	r.FinishReason = response.FinishReason
+	r.Usage = r.Usage.Add(response.Usage)
+}
+
+// blocked finalizes a result whose last model response was blocked by a content filter
+func (r *Result) blocked(ctx context.Context, response *models.Response) *Result {
+	slog.WarnContext(ctx, "Model response was blocked", "response", response.String())
+	r.Steps = append(r.Steps, Step{Output: response.Text})
+	r.Response = BlockedReply
+	r.Completed = true
+	return r
+}
+
+// Transcript returns the raw model output and tool observations of every step
+func (r *Result) Transcript() string {
+	var sb strings.Builder
+	for i, step := range r.Steps {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString(step.Output)
+		if step.Observation != "" {
+			sb.WriteString("\nObservation: ")
+			sb.WriteString(step.Observation)
+		}
+	}
+	return sb.String()
+}
+
 // Agent represents the main agent class that handles user interactions
 type Agent struct {
-	model  models.LLMModel
-	memory *types.ConversationMemory
-	tools  map[string]tools.Tool
+	model    models.LLMModel
+	memory   *types.ConversationMemory
+	tools    map[string]tools.Tool
+	maxSteps int
+	debug    bool
+	// generateOptions carries the agent's own system prompt, so agents sharing a model never overwrite each other
+	generateOptions models.GenerateOptions
+
+	// functionModel is set when the agent uses native function calling
+	functionModel models.FunctionCallingModel
 }
 
-// NewAgent creates a new agent instance
+// NewAgent creates a new agent instance with default options
 func NewAgent(model models.LLMModel, memory *types.ConversationMemory, toolList []tools.Tool) *Agent {
-	// Create tools map
-	toolsMap := make(map[string]tools.Tool)
-	for _, tool := range toolList {
-		toolsMap[tool.Name()] = tool
-	}
+	return NewAgentWithOptions(model, memory, toolList, DefaultOptions())
+}
 
+// NewAgentWithOptions creates a new agent instance with the given options
+func NewAgentWithOptions(model models.LLMModel, memory *types.ConversationMemory, toolList []tools.Tool, opts Options) *Agent {
 	agent := &Agent{
 		model:  model,
 		memory: memory,
-		tools:  toolsMap,
 	}
+	agent.configure(toolList, opts)
+	return agent
+}
 
-	// Set up system prompt
-	toolsString := agent.getToolsString()
-	toolNames := agent.getToolNames()
-	systemPrompt := fmt.Sprintf(prompts.GetAgentSystemPromptTemplate(), toolsString, toolNames)
-	model.SetSystemPrompt(systemPrompt)
+// Reconfigure replaces the agent's tools and options, keeping its memory, and closes
+// the previous tools that are not kept. It must not be called while the agent is
+// generating a response.
+func (a *Agent) Reconfigure(toolList []tools.Tool, opts Options) {
+	previous := a.tools
+	a.configure(toolList, opts)
 
-	log.Printf("System prompt set for agent")
-	return agent
+	for name, tool := range previous {
+		if a.tools[name] == tool {
+			continue
+		}
+		if err := closeTool(tool); err != nil {
+			slog.Error("Error closing replaced tool", "tool", name, "error", err)
+		}
+	}
+}
+
+// configure sets the agent's tools and options and builds its system prompt
+func (a *Agent) configure(toolList []tools.Tool, opts Options) {
+	// Create tools map
+	a.tools = make(map[string]tools.Tool)
+	for _, tool := range toolList {
+		a.tools[tool.Name()] = tool
+	}
+
+	if opts.MaxSteps <= 0 {
+		opts.MaxSteps = DefaultMaxSteps
+	}
+	a.maxSteps = opts.MaxSteps
+	a.debug = opts.Debug
+	a.generateOptions = opts.Generation
+
+	a.functionModel = nil
+	if opts.NativeFunctionCalling {
+		if models.SupportsFunctionCalling(a.model) {
+			a.functionModel = a.model.(models.FunctionCallingModel)
+		} else {
+			slog.Warn("Model does not support native function calling, falling back to ReAct prompting", "model", a.model.Name())
+		}
+	}
+
+	// Set up system prompt, with a blank line between the persona and the tool instructions
+	persona := opts.Persona
+	if persona == "" {
+		persona = prompts.GetDefaultPersona()
+	}
+	persona = strings.TrimRight(persona, "\n") + "\n\n"
+	if a.functionModel != nil {
+		a.generateOptions.SystemPrompt = persona + prompts.GetNativeToolsPrompt()
+	} else {
+		toolsString := a.getToolsString()
+		toolNames := a.getToolNames()
+		a.generateOptions.SystemPrompt = persona + fmt.Sprintf(prompts.GetReActToolsPromptTemplate(), toolsString, toolNames)
+	}
+
+	slog.Debug("System prompt set for agent")
 }
 
 // getToolNames returns a comma-separated string of tool names
@@ -61,67 +206,330 @@ func (a *Agent) getToolsString() string {
 	return strings.Join(toolDescriptions, "\n")
 }
 
+// SystemPrompt returns the agent's system prompt
+func (a *Agent) SystemPrompt() string {
+	return a.generateOptions.SystemPrompt
+}
+
+// options returns a copy of the agent's generation options for a request
+func (a *Agent) options() *models.GenerateOptions {
+	opts := a.generateOptions
+	return &opts
+}
+
 // AddMessage adds a message to the agent's memory
-func (a *Agent) AddMessage(message *types.Message) {
-	a.memory.AddMessage(message)
+func (a *Agent) AddMessage(ctx context.Context, message *types.Message) {
+	a.memory.AddMessage(ctx, message)
+}
+
+// ClearMemory removes all messages from the agent's memory
+func (a *Agent) ClearMemory() {
+	a.memory.Clear()
+}
+
+// FlushMemory saves the agent's memory to its store if it changed since it was last saved
+func (a *Agent) FlushMemory() error {
+	return a.memory.Flush()
+}
+
+// Close saves the agent's memory to its store and closes the tools that hold
+// resources. It must not be called while the agent is generating a response.
+func (a *Agent) Close() error {
+	errs := []error{a.memory.Flush()}
+	for name, tool := range a.tools {
+		if err := closeTool(tool); err != nil {
+			errs = append(errs, fmt.Errorf("error closing tool %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeTool closes tool if it holds resources
+func closeTool(tool tools.Tool) error {
+	if closer, ok := tool.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
 
 // GetResponse gets a response from the agent
 func (a *Agent) GetResponse(ctx context.Context) (string, error) {
-	// Get conversation history
-	messages := a.memory.GetHistory()
+	return a.GetResponseStream(ctx, nil)
+}
 
-	// Generate response using the model
-	response, err := a.model.GenerateWithHistoryAsync(ctx, messages)
+// GetResponseStream gets a response from the agent, calling onUpdate with the
+// answer so far while it is being generated. onUpdate may be nil.
+func (a *Agent) GetResponseStream(ctx context.Context, onUpdate func(answer string)) (string, error) {
+	result, err := a.RunStream(ctx, onUpdate)
 	if err != nil {
-		return "", fmt.Errorf("error generating response: %w", err)
-	}
-
-	log.Printf("Model's raw response: %s", response)
-
-	// Check for tool use
-	toolRegex := regexp.MustCompile(`Action: (\w+)\nAction Input: (.*)`)
-	matches := toolRegex.FindStringSubmatch(response)
-
-	if len(matches) >= 3 {
-		toolName := strings.TrimSpace(matches[1])
-		toolInput := strings.TrimSpace(matches[2])
-
-		log.Printf("Tool use detected: %s with input %s", toolName, toolInput)
-
-		tool, exists := a.tools[toolName]
-		if exists {
-			// Execute the tool
-			toolResult, err := tool.ARun(ctx, toolInput)
-			if err != nil {
-				log.Printf("Error executing tool %s: %v", toolName, err)
-				observation := fmt.Sprintf("Tool %s failed: %v", toolName, err)
-				aiMsg := types.NewMessage("AI", []types.MessageContent{{Type: "text", Content: observation}})
-				a.AddMessage(aiMsg)
-			} else {
-				observation := fmt.Sprintf("Tool %s used. Observation: %s", toolName, toolResult.ReturnDisplay)
-				log.Printf("Tool observation: %s", observation)
-				aiMsg := types.NewMessage("AI", []types.MessageContent{{Type: "text", Content: observation}})
-				a.AddMessage(aiMsg)
-
-				// Get a new response with the tool's output using conversation history
-				history := a.memory.GetHistory()
-				response, err = a.model.GenerateWithHistoryAsync(ctx, history)
-				if err != nil {
-					return "", fmt.Errorf("error generating follow-up response: %w", err)
-				}
-
-				log.Printf("Model's raw response after tool use: %s", response)
+		return "", err
+	}
+	return a.Reply(result), nil
+}
+
+// Reply returns the reply to post for result: its response, or the full transcript
+// when the agent is in debug mode
+func (a *Agent) Reply(result *Result) string {
+	if a.debug {
+		return result.Transcript()
+	}
+	return result.Response
+}
+
+// Run executes the Thought/Action/Observation loop until the model produces a
+// final answer or the step limit is reached, and returns the trace of every step.
+// If the run fails, the incomplete result is returned with the error, so the usage
+// of the steps already taken is still known.
+func (a *Agent) Run(ctx context.Context) (*Result, error) {
+	return a.RunStream(ctx, nil)
+}
+
+// RunStream is like Run, but streams the model output and calls onUpdate with the
+// answer so far whenever it changes. Thoughts and actions are never passed to
+// onUpdate, and the answer may shrink back to empty when a step that looked like an
+// answer turns out to call a tool. Result.Response is authoritative once the run ends.
+func (a *Agent) RunStream(ctx context.Context, onUpdate func(answer string)) (*Result, error) {
+	ctx, span := tracing.Start(ctx, "Agent.GetResponse")
+
+	var result *Result
+	var err error
+	if a.functionModel != nil {
+		// Function calls are only known once the response is complete, so nothing is streamed
+		result, err = a.runNative(ctx)
+	} else {
+		result, err = a.runReAct(ctx, onUpdate)
+	}
+
+	if result != nil {
+		span.SetAttributes(
+			tracing.AgentSteps.Int(len(result.Steps)),
+			tracing.Completed.Bool(result.Completed),
+			tracing.FinishReason.String(string(result.FinishReason)),
+			tracing.InputTokens.Int(result.Usage.PromptTokens),
+			tracing.OutputTokens.Int(result.Usage.OutputTokens),
+		)
+	}
+	tracing.End(span, err)
+	return result, err
+}
+
+// generate requests the next ReAct step, streaming the visible answer to onUpdate when it is set
+func (a *Agent) generate(ctx context.Context, onUpdate func(answer string)) (*models.Response, error) {
+	if onUpdate == nil {
+		return a.model.GenerateWithHistoryAsync(ctx, a.memory.GetHistory(), a.options())
+	}
+
+	var partial strings.Builder
+	var shown string
+	return a.model.StreamWithHistoryAsync(ctx, a.memory.GetHistory(), a.options(), func(chunk string) error {
+		partial.WriteString(chunk)
+		if answer := StreamingAnswer(partial.String()); answer != shown {
+			shown = answer
+			onUpdate(answer)
+		}
+		return nil
+	})
+}
+
+// runReAct runs the agent loop by parsing ReAct actions from the model's text output
+func (a *Agent) runReAct(ctx context.Context, onUpdate func(answer string)) (*Result, error) {
+	result := &Result{}
+
+	for i := 0; i < a.maxSteps; i++ {
+		// Generate response using the conversation history
+		modelResponse, err := a.generate(ctx, onUpdate)
+		if err != nil {
+			return result, fmt.Errorf("error generating response at step %d: %w", i+1, err)
+		}
+		result.record(modelResponse)
+		if modelResponse.Blocked() {
+			return result.blocked(ctx, modelResponse), nil
+		}
+
+		response := modelResponse.Text
+		slog.DebugContext(ctx, "Model's raw response", "step", i+1, logging.Content("response", response))
+
+		parsed := ParseResponse(response)
+		step := Step{Thought: strings.Join(parsed.Thoughts, "\n"), Output: response}
+		for _, thought := range parsed.Thoughts {
+			slog.DebugContext(ctx, "Agent thought", "step", i+1, logging.Content("thought", thought))
+		}
+
+		// Add the raw AI response to memory so the model keeps seeing its own format
+		aiMsg := types.NewMessage("AI", []types.MessageContent{{Type: "text", Content: response}})
+		a.AddMessage(ctx, aiMsg)
+
+		// Stop as soon as the model commits to an answer
+		if !parsed.HasAction() {
+			result.Steps = append(result.Steps, step)
+			result.Response = parsed.FinalAnswer
+			result.Completed = true
+			if modelResponse.Truncated() {
+				result.Response += TruncatedNotice
 			}
-		} else {
-			log.Printf("Tool %s not found", toolName)
+			slog.InfoContext(ctx, "Agent's final answer", "steps", i+1, logging.Content("answer", parsed.FinalAnswer))
+			return result, nil
 		}
+
+		step.Action = parsed.Action
+		step.ActionInput = parsed.ActionInput
+		slog.InfoContext(ctx, "Tool use detected", "tool", step.Action, logging.Content("input", step.ActionInput))
+
+		step.Observation = a.runTool(ctx, step.Action, step.ActionInput)
+		result.Steps = append(result.Steps, step)
+
+		observationMsg := types.NewMessage("AI", []types.MessageContent{{Type: "text", Content: step.Observation}})
+		a.AddMessage(ctx, observationMsg)
+
+		if err := ctx.Err(); err != nil {
+			return result, fmt.Errorf("agent run cancelled: %w", err)
+		}
+	}
+
+	return a.stepLimitReached(ctx, result), nil
+}
+
+// runNative runs the agent loop using the model's native function calling
+func (a *Agent) runNative(ctx context.Context) (*Result, error) {
+	result := &Result{}
+
+	declarations := make([]*types.FunctionDeclaration, 0, len(a.tools))
+	for _, tool := range a.tools {
+		declarations = append(declarations, tools.Declaration(tool))
 	}
 
-	// Add AI response to memory
-	aiMsg := types.NewMessage("AI", []types.MessageContent{{Type: "text", Content: response}})
-	a.AddMessage(aiMsg)
+	for i := 0; i < a.maxSteps; i++ {
+		response, err := a.functionModel.GenerateWithFunctionsAsync(ctx, a.memory.GetHistory(), declarations, a.options())
+		if err != nil {
+			return result, fmt.Errorf("error generating response at step %d: %w", i+1, err)
+		}
+		result.record(response)
+		if response.Blocked() {
+			return result.blocked(ctx, response), nil
+		}
+
+		aiMsg := response.Message
+		a.AddMessage(ctx, aiMsg)
+
+		text := aiMsg.Text()
+		calls := aiMsg.FunctionCalls()
+		slog.DebugContext(ctx, "Model's response", "step", i+1, logging.Content("response", text), "function_calls", len(calls))
+
+		if len(calls) == 0 {
+			result.Steps = append(result.Steps, Step{Output: text})
+			result.Response = text
+			result.Completed = true
+			if response.Truncated() {
+				result.Response += TruncatedNotice
+			}
+			slog.InfoContext(ctx, "Agent's final answer", "steps", i+1, logging.Content("answer", text))
+			return result, nil
+		}
+
+		// Execute every requested call and return the results in one message
+		responses := make([]types.MessageContent, 0, len(calls))
+		for _, call := range calls {
+			input, _ := json.Marshal(call.Args)
+			step := Step{Thought: text, Action: call.Name, ActionInput: string(input), Output: text}
+			slog.InfoContext(ctx, "Function call detected", "tool", call.Name, logging.Content("input", step.ActionInput))
+
+			step.Observation = a.callFunction(ctx, call)
+			result.Steps = append(result.Steps, step)
+
+			responses = append(responses, types.MessageContent{
+				Type: "function_response",
+				FunctionResponse: &types.FunctionResponse{
+					ID:       call.ID,
+					Name:     call.Name,
+					Response: map[string]interface{}{"output": step.Observation},
+				},
+			})
+		}
+		a.AddMessage(ctx, types.NewMessage("tool", responses))
+
+		if err := ctx.Err(); err != nil {
+			return result, fmt.Errorf("agent run cancelled: %w", err)
+		}
+	}
+
+	return a.stepLimitReached(ctx, result), nil
+}
+
+// stepLimitReached finalizes a result whose step budget ran out while the model was still calling tools
+func (a *Agent) stepLimitReached(ctx context.Context, result *Result) *Result {
+	slog.WarnContext(ctx, "Agent reached the step limit without a final answer", "max_steps", a.maxSteps)
+	result.Response = fmt.Sprintf("Sorry, I couldn't finish working on that within %d steps. Could you try a more specific question?", a.maxSteps)
+	return result
+}
+
+// callFunction executes a native function call and returns the tool output
+func (a *Agent) callFunction(ctx context.Context, call *types.FunctionCall) string {
+	tool, exists := a.tools[call.Name]
+	if !exists {
+		slog.WarnContext(ctx, "Tool not found", "tool", call.Name)
+		metrics.ObserveToolInvocation(call.Name, metrics.OutcomeNotFound)
+		return fmt.Sprintf("Error: tool %s does not exist", call.Name)
+	}
+
+	args := call.Args
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+
+	toolResult, err := invokeTool(ctx, tool, args)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error executing tool", "tool", call.Name, "error", err)
+		return fmt.Sprintf("Error: %v", err)
+	}
+
+	slog.DebugContext(ctx, "Tool output", "tool", call.Name, logging.Content("output", toolResult.ReturnDisplay))
+	return toolResult.ReturnDisplay
+}
+
+// invokeTool runs tool with args in a span, recording the invocation in the metrics.
+// A tool returning neither a result nor an error is reported as failed.
+func invokeTool(ctx context.Context, tool tools.Tool, args ...interface{}) (*tools.ToolResult, error) {
+	ctx, span := tracing.Start(ctx, "Tool.ARun", tracing.ToolName.String(tool.Name()))
+	toolResult, err := tool.ARun(ctx, args...)
+	if err == nil && toolResult == nil {
+		err = fmt.Errorf("tool %s returned no result", tool.Name())
+	}
+
+	outcome := toolOutcome(toolResult, err)
+	metrics.ObserveToolInvocation(tool.Name(), outcome)
+	span.SetAttributes(tracing.ToolOutcome.String(outcome))
+	if err == nil && outcome == metrics.OutcomeError {
+		tracing.Fail(span, errors.New("tool reported an error"))
+	}
+	tracing.End(span, err)
+	return toolResult, err
+}
+
+// toolOutcome returns the metrics outcome of a tool call. Tools report most failures
+// as a result starting with "Error" rather than as an error.
+func toolOutcome(result *tools.ToolResult, err error) string {
+	if err != nil || result == nil || strings.HasPrefix(result.ReturnDisplay, "Error") {
+		return metrics.OutcomeError
+	}
+	return metrics.OutcomeOK
+}
+
+// runTool executes the named tool and returns the observation to feed back to the model
+func (a *Agent) runTool(ctx context.Context, toolName, toolInput string) string {
+	tool, exists := a.tools[toolName]
+	if !exists {
+		slog.WarnContext(ctx, "Tool not found", "tool", toolName)
+		metrics.ObserveToolInvocation(toolName, metrics.OutcomeNotFound)
+		return fmt.Sprintf("Tool %s does not exist. Available tools: %s", toolName, a.getToolNames())
+	}
+
+	toolResult, err := invokeTool(ctx, tool, toolInput)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error executing tool", "tool", toolName, "error", err)
+		return fmt.Sprintf("Tool %s failed: %v", toolName, err)
+	}
 
-	log.Printf("Agent's final response: %s", response)
-	return response, nil
+	observation := fmt.Sprintf("Tool %s used. Observation: %s", toolName, toolResult.ReturnDisplay)
+	slog.DebugContext(ctx, "Tool output", "tool", toolName, logging.Content("output", toolResult.ReturnDisplay))
+	return observation
 }
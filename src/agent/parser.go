@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"regexp"
+	"strings"
+)
+
+// markerRegex matches a ReAct marker at the start of a line, tolerating markdown emphasis
+var markerRegex = regexp.MustCompile(`(?im)^[ \t>*_]*(Thought|Action Input|Action|Observation|Final Answer)[*_]*\s*:[*_]*[ \t]*`)
+
+// ParsedResponse is a model response split into its ReAct sections
+type ParsedResponse struct {
+	// Raw is the unmodified model output
+	Raw string
+	// Thoughts are the model's reasoning lines, kept for logs and debugging only
+	Thoughts []string
+	// Action and ActionInput are set when the model requested a tool
+	Action      string
+	ActionInput string
+	// FinalAnswer is the text meant for the user
+	FinalAnswer string
+	// HasFinalAnswer reports whether an explicit "Final Answer:" marker was found
+	HasFinalAnswer bool
+}
+
+// section is a marker and the text that follows it up to the next marker
+type section struct {
+	marker string
+	text   string
+}
+
+// HasAction reports whether the response requests a tool call
+func (p *ParsedResponse) HasAction() bool {
+	return p.Action != "" && !p.HasFinalAnswer
+}
+
+// ParseResponse splits a ReAct formatted model response into thoughts, an action and
+// the final answer. Responses with missing or malformed markers are handled gracefully:
+// any text outside of the scaffolding is treated as the final answer.
+func ParseResponse(text string) *ParsedResponse {
+	parsed := &ParsedResponse{Raw: text}
+	body := stripWrappingFence(strings.TrimSpace(text))
+
+	sections, preamble := splitSections(body)
+
+	var loose []string
+	if preamble != "" {
+		loose = append(loose, preamble)
+	}
+
+	for _, sec := range sections {
+		switch sec.marker {
+		case "thought":
+			thought, rest := splitFirstLine(sec.text)
+			if thought != "" {
+				parsed.Thoughts = append(parsed.Thoughts, thought)
+			}
+			// Text on the lines after a thought without a marker is meant for the user
+			if rest != "" {
+				loose = append(loose, rest)
+			}
+		case "action":
+			if parsed.Action == "" {
+				parsed.Action, _ = splitFirstLine(sec.text)
+			}
+		case "action input":
+			if parsed.ActionInput == "" {
+				parsed.ActionInput = sec.text
+			}
+		case "observation":
+			// The model must not invent observations; drop them
+		case "final answer":
+			if !parsed.HasFinalAnswer {
+				parsed.FinalAnswer = sec.text
+				parsed.HasFinalAnswer = true
+			}
+		}
+	}
+
+	if !parsed.HasFinalAnswer && parsed.Action == "" {
+		parsed.FinalAnswer = strings.TrimSpace(strings.Join(loose, "\n\n"))
+		// Fall back to the thoughts if the model only produced scaffolding
+		if parsed.FinalAnswer == "" && len(sections) > 0 {
+			parsed.FinalAnswer = strings.Join(parsed.Thoughts, "\n")
+		}
+	}
+
+	return parsed
+}
+
+// splitSections splits text at every ReAct marker, returning the sections and any text before the first marker
+func splitSections(text string) ([]section, string) {
+	locs := markerRegex.FindAllStringSubmatchIndex(text, -1)
+	if len(locs) == 0 {
+		return nil, text
+	}
+
+	preamble := strings.TrimSpace(text[:locs[0][0]])
+	sections := make([]section, 0, len(locs))
+	for i, loc := range locs {
+		marker := strings.ToLower(text[loc[2]:loc[3]])
+		// The final answer runs to the end of the response, whatever it contains
+		end := len(text)
+		if i+1 < len(locs) && marker != "final answer" {
+			end = locs[i+1][0]
+		}
+		sections = append(sections, section{
+			marker: marker,
+			text:   strings.TrimSpace(text[loc[1]:end]),
+		})
+		if marker == "final answer" {
+			break
+		}
+	}
+	return sections, preamble
+}
+
+// splitFirstLine returns the first line of text and the trimmed remainder
+func splitFirstLine(text string) (string, string) {
+	first, rest, _ := strings.Cut(text, "\n")
+	return strings.TrimSpace(first), strings.TrimSpace(rest)
+}
+
+// stripWrappingFence removes a code fence that wraps the whole response, as the
+// prompt's format examples are fenced and models sometimes copy that
+func stripWrappingFence(text string) string {
+	if len(text) < 6 || !strings.HasPrefix(text, "```") || !strings.HasSuffix(text, "```") {
+		return text
+	}
+
+	inner := strings.TrimSpace(text[3 : len(text)-3])
+	// Drop the language tag of the opening fence
+	if tag, rest, found := strings.Cut(inner, "\n"); found && !markerRegex.MatchString(tag) {
+		inner = rest
+	}
+
+	// Only unwrap ReAct scaffolding, never a code block meant for the user
+	if !markerRegex.MatchString(inner) {
+		return text
+	}
+	return strings.TrimSpace(inner)
+}
+
+// markerNames are the ReAct markers a partially streamed response may start with
+var markerNames = []string{"thought:", "action input:", "action:", "observation:", "final answer:"}
+
+// StreamingAnswer returns the part of a partially streamed response that can already
+// be shown to the user. Text is only shown once it is known to be a final answer:
+// either it follows a "Final Answer:" marker, or the response has no ReAct markers at all.
+func StreamingAnswer(partial string) string {
+	parsed := ParseResponse(partial)
+	if parsed.HasFinalAnswer {
+		return parsed.FinalAnswer
+	}
+	if markerRegex.MatchString(partial) {
+		return ""
+	}
+
+	// Hold back a response whose first line may still become a marker
+	first, _, _ := strings.Cut(strings.TrimLeft(partial, " \t\r\n>*_"), "\n")
+	first = strings.ToLower(strings.TrimSpace(strings.NewReplacer("*", "", "_", "").Replace(first)))
+	for _, name := range markerNames {
+		if strings.HasPrefix(name, first) {
+			return ""
+		}
+	}
+	return parsed.FinalAnswer
+}
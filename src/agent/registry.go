@@ -0,0 +1,271 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrRegistryClosed is returned by Do after the registry is closed
+var ErrRegistryClosed = errors.New("agent registry is closed")
+
+// DefaultFlushInterval is how often RunFlusher is usually run to save agent memories
+const DefaultFlushInterval = 10 * time.Second
+
+// Factory creates the agent for a channel of a guild. The guild ID is empty for direct messages.
+type Factory func(guildID, channelID string) (*Agent, error)
+
+// Configurer updates an existing agent of a channel after Reconfigure, keeping its memory
+type Configurer func(guildID, channelID string, agent *Agent)
+
+// Registry holds one agent per channel. It is safe for concurrent use: calls for
+// the same channel are serialized so turns never interleave, while different
+// channels run in parallel. Agents idle for longer than the idle TTL are evicted.
+type Registry struct {
+	factory    Factory
+	configurer Configurer
+	idleTTL    time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*registryEntry
+	closed  bool
+	// version is incremented by Reconfigure; agents configured at an older version are
+	// updated before their next turn
+	version uint64
+}
+
+// registryEntry is a channel's agent and the lock serializing its turns
+type registryEntry struct {
+	// sem is a one-slot semaphore so waiting for the channel can be cancelled
+	sem      chan struct{}
+	agent    *Agent
+	guildID  string
+	lastUsed time.Time
+	// version is the registry version the agent was configured at; it is guarded by sem
+	version uint64
+	// users counts callers running or waiting on the entry; it is guarded by Registry.mu
+	users int
+}
+
+// NewRegistry creates a new agent registry. An idle TTL of zero disables eviction.
+func NewRegistry(factory Factory, idleTTL time.Duration) *Registry {
+	return &Registry{
+		factory: factory,
+		idleTTL: idleTTL,
+		entries: make(map[string]*registryEntry),
+	}
+}
+
+// SetConfigurer sets the function that updates existing agents after Reconfigure
+func (r *Registry) SetConfigurer(configurer Configurer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.configurer = configurer
+}
+
+// Reconfigure marks every agent to be updated by the configurer before its next turn.
+// Turns already running finish with the agent's previous configuration.
+func (r *Registry) Reconfigure() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.version++
+}
+
+// Do runs fn with the agent for channelID, creating the agent for the channel of
+// guildID if needed. Calls for the same channel run one at a time; waiting for a turn
+// is abandoned if ctx is done.
+func (r *Registry) Do(ctx context.Context, guildID, channelID string, fn func(*Agent) error) error {
+	entry, err := r.acquire(channelID)
+	if err != nil {
+		return err
+	}
+	defer r.release(entry)
+
+	select {
+	case entry.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-entry.sem }()
+
+	version, configurer := r.configuration()
+	if entry.agent == nil {
+		slog.InfoContext(ctx, "Creating new channel agent")
+		created, err := r.factory(guildID, channelID)
+		if err != nil {
+			return err
+		}
+		entry.agent = created
+		entry.guildID = guildID
+		entry.version = version
+	} else if entry.version != version && configurer != nil {
+		configurer(entry.guildID, channelID, entry.agent)
+		entry.version = version
+	}
+
+	err = fn(entry.agent)
+	entry.lastUsed = time.Now()
+	return err
+}
+
+// configuration returns the current version and configurer
+func (r *Registry) configuration() (uint64, Configurer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.version, r.configurer
+}
+
+// acquire returns the entry for channelID, creating it if needed, and registers the caller
+func (r *Registry) acquire(channelID string) (*registryEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrRegistryClosed
+	}
+
+	entry, exists := r.entries[channelID]
+	if !exists {
+		entry = &registryEntry{sem: make(chan struct{}, 1), lastUsed: time.Now()}
+		r.entries[channelID] = entry
+	}
+	entry.users++
+	return entry, nil
+}
+
+// release unregisters a caller from an entry
+func (r *Registry) release(entry *registryEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry.users--
+}
+
+// Len returns the number of channels with a registered agent
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.entries)
+}
+
+// EvictIdle removes agents that are not in use and have been idle for longer than
+// the idle TTL and closes them, returning the number evicted
+func (r *Registry) EvictIdle() int {
+	if r.idleTTL <= 0 {
+		return 0
+	}
+
+	r.mu.Lock()
+	// users is zero only when nobody holds the semaphore, so lastUsed is not being written
+	cutoff := time.Now().Add(-r.idleTTL)
+	evicted := make(map[string]*registryEntry)
+	for channelID, entry := range r.entries {
+		if entry.users == 0 && entry.lastUsed.Before(cutoff) {
+			delete(r.entries, channelID)
+			evicted[channelID] = entry
+		}
+	}
+	r.mu.Unlock()
+
+	// Nobody can acquire a removed entry, so its semaphore is free
+	for channelID, entry := range evicted {
+		entry.sem <- struct{}{}
+		if entry.agent != nil {
+			if err := entry.agent.Close(); err != nil {
+				slog.Error("Error closing evicted channel agent", "channel", channelID, "error", err)
+			}
+		}
+		<-entry.sem
+	}
+	return len(evicted)
+}
+
+// Flush saves the memory of every agent that is not running a turn, returning the
+// errors of those that failed. Agents in use are saved by a later flush.
+func (r *Registry) Flush() error {
+	r.mu.Lock()
+	entries := make(map[string]*registryEntry, len(r.entries))
+	for channelID, entry := range r.entries {
+		entries[channelID] = entry
+	}
+	r.mu.Unlock()
+
+	var errs []error
+	for _, entry := range entries {
+		select {
+		case entry.sem <- struct{}{}:
+		default:
+			continue
+		}
+		if entry.agent != nil {
+			if err := entry.agent.FlushMemory(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		<-entry.sem
+	}
+	return errors.Join(errs...)
+}
+
+// Close removes every agent and closes it, saving its memory and releasing its
+// tools. Agents still running a turn are waited for until ctx is done, then left
+// open. Later calls to Do return ErrRegistryClosed.
+func (r *Registry) Close(ctx context.Context) error {
+	r.mu.Lock()
+	r.closed = true
+	entries := r.entries
+	r.entries = make(map[string]*registryEntry)
+	r.mu.Unlock()
+
+	var errs []error
+	for channelID, entry := range entries {
+		select {
+		case entry.sem <- struct{}{}:
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("agent of channel %s is still running: %w", channelID, ctx.Err()))
+			continue
+		}
+		if entry.agent != nil {
+			if err := entry.agent.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("error closing agent of channel %s: %w", channelID, err))
+			}
+		}
+		<-entry.sem
+	}
+	return errors.Join(errs...)
+}
+
+// RunEviction evicts idle agents every interval until ctx is done
+func (r *Registry) RunEviction(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if evicted := r.EvictIdle(); evicted > 0 {
+				slog.Info("Evicted idle channel agents", "count", evicted)
+			}
+		}
+	}
+}
+
+// RunFlusher saves changed agent memories every interval until ctx is done
+func (r *Registry) RunFlusher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				slog.Error("Error saving channel memories", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"discord-gemini-bot/src/types"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// DefaultMaxBytes is the largest attachment that will be downloaded
+	DefaultMaxBytes = 20 * 1024 * 1024
+	// DefaultInlineLimit is the largest attachment sent inline; larger ones are uploaded
+	DefaultInlineLimit = 4 * 1024 * 1024
+)
+
+var (
+	// ErrTooLarge is returned when an attachment exceeds the size cap
+	ErrTooLarge = errors.New("attachment exceeds size limit")
+	// ErrUnsupportedType is returned when an attachment's content is not an allowed type
+	ErrUnsupportedType = errors.New("unsupported attachment type")
+)
+
+// Fetcher performs HTTP requests; *http.Client satisfies it
+type Fetcher interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Uploader uploads attachment bytes to a model's file API and returns the file URI
+type Uploader interface {
+	UploadFile(ctx context.Context, data []byte, mimeType string) (string, error)
+}
+
+// Ingestor downloads Discord attachments and converts them to message contents
+type Ingestor struct {
+	fetcher      Fetcher
+	uploader     Uploader
+	allowedTypes []string
+	maxBytes     int64
+	inlineLimit  int64
+}
+
+// NewIngestor creates a new attachment ingestor that accepts the given MIME types
+func NewIngestor(fetcher Fetcher, allowedTypes []string) *Ingestor {
+	if fetcher == nil {
+		fetcher = http.DefaultClient
+	}
+	return &Ingestor{
+		fetcher:      fetcher,
+		allowedTypes: allowedTypes,
+		maxBytes:     DefaultMaxBytes,
+		inlineLimit:  DefaultInlineLimit,
+	}
+}
+
+// SetMaxBytes sets the largest attachment that will be downloaded
+func (i *Ingestor) SetMaxBytes(maxBytes int64) {
+	i.maxBytes = maxBytes
+}
+
+// SetInlineLimit sets the largest attachment sent inline to the model
+func (i *Ingestor) SetInlineLimit(inlineLimit int64) {
+	i.inlineLimit = inlineLimit
+}
+
+// SetUploader sets the uploader used for attachments above the inline limit
+func (i *Ingestor) SetUploader(uploader Uploader) {
+	i.uploader = uploader
+}
+
+// LoadAttachment downloads a Discord attachment and converts it to message content
+func (i *Ingestor) LoadAttachment(ctx context.Context, attachment *discordgo.MessageAttachment) (types.MessageContent, error) {
+	// Reject early using the metadata Discord reports, before downloading anything
+	if attachment.Size > 0 && int64(attachment.Size) > i.maxBytes {
+		return types.MessageContent{}, fmt.Errorf("%w: %d bytes", ErrTooLarge, attachment.Size)
+	}
+	if attachment.ContentType != "" && !i.isAllowed(baseMediaType(attachment.ContentType)) {
+		return types.MessageContent{}, fmt.Errorf("%w: %s", ErrUnsupportedType, attachment.ContentType)
+	}
+
+	return i.Load(ctx, attachment.URL)
+}
+
+// Load downloads the attachment at url, sniffs its MIME type and converts it to
+// inline image content, or uploads it when it is above the inline limit
+func (i *Ingestor) Load(ctx context.Context, url string) (types.MessageContent, error) {
+	data, err := i.download(ctx, url)
+	if err != nil {
+		return types.MessageContent{}, err
+	}
+
+	// Trust the bytes rather than the declared content type
+	mimeType := baseMediaType(http.DetectContentType(data))
+	if !i.isAllowed(mimeType) {
+		return types.MessageContent{}, fmt.Errorf("%w: %s", ErrUnsupportedType, mimeType)
+	}
+
+	if int64(len(data)) > i.inlineLimit {
+		if i.uploader == nil {
+			return types.MessageContent{}, fmt.Errorf("%w: %d bytes and no uploader configured", ErrTooLarge, len(data))
+		}
+		uri, err := i.uploader.UploadFile(ctx, data, mimeType)
+		if err != nil {
+			return types.MessageContent{}, fmt.Errorf("failed to upload attachment: %w", err)
+		}
+		return types.MessageContent{Type: "file", Content: mimeType + "," + uri, UploadedAt: time.Now()}, nil
+	}
+
+	return types.MessageContent{Type: "image", Content: mimeType + "," + base64.StdEncoding.EncodeToString(data)}, nil
+}
+
+// download fetches url, reading at most maxBytes
+func (i *Ingestor) download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attachment request: %w", err)
+	}
+
+	resp, err := i.fetcher.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download attachment: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download attachment: HTTP %d", resp.StatusCode)
+	}
+	if resp.ContentLength > i.maxBytes {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(resp.Body, i.maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment: %w", err)
+	}
+	if n > i.maxBytes {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, i.maxBytes)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// isAllowed reports whether mimeType is in the allowed list
+func (i *Ingestor) isAllowed(mimeType string) bool {
+	for _, allowed := range i.allowedTypes {
+		if mimeType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// baseMediaType strips parameters such as charset from a MIME type
+func baseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return mediaType
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/discordbot"
+	"discord-gemini-bot/src/handler"
+	"discord-gemini-bot/src/ratelimit"
+	"discord-gemini-bot/src/tracing"
+	"discord-gemini-bot/src/types"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// newCommandRouter creates the router for the bot's slash commands
+func newCommandRouter() *discordbot.CommandRouter {
+	router := discordbot.NewCommandRouter()
+
+	router.Add(&discordbot.Command{
+		Definition: &discordgo.ApplicationCommand{
+			Name:        "ask",
+			Description: "Ask the assistant a question",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "question",
+					Description: "What do you want to ask?",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionAttachment,
+					Name:        "image",
+					Description: "An image to include with the question",
+				},
+			},
+		},
+		Handler: askCommand,
+	})
+
+	router.Add(&discordbot.Command{
+		Definition: &discordgo.ApplicationCommand{
+			Name:        "reset",
+			Description: "Clear the assistant's memory of this channel",
+		},
+		Handler: resetCommand,
+	})
+
+	router.Add(&discordbot.Command{
+		Definition: &discordgo.ApplicationCommand{
+			Name:        "tools",
+			Description: "List the tools the assistant can use",
+		},
+		Handler: toolsCommand,
+	})
+
+	router.Add(&discordbot.Command{
+		Definition: &discordgo.ApplicationCommand{
+			Name:        "model",
+			Description: "Show the model the assistant is running on",
+		},
+		Handler: modelCommand,
+	})
+
+	return router
+}
+
+// askCommand runs an agent turn for /ask, deferring the response since agent runs
+// take longer than Discord's acknowledgement window
+func askCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	user := discordbot.InteractionUser(i)
+	ctx, span := handler.StartRequest("Discord.Command", i.GuildID, i.ChannelID, user.ID)
+	span.SetAttributes(tracing.Command.String("ask"))
+	defer span.End()
+
+	ctx, done, err := messages.Begin(ctx, "/ask in channel "+i.ChannelID)
+	if err != nil {
+		slog.InfoContext(ctx, "Declining /ask during shutdown")
+		if err := discordbot.Respond(s, i, handler.ShutdownReply, true); err != nil {
+			slog.ErrorContext(ctx, "Error responding to /ask", "error", err)
+		}
+		return
+	}
+	defer done()
+
+	ctx, err = messages.Allow(ctx, ratelimit.Caller{GuildID: i.GuildID, ChannelID: i.ChannelID, UserID: user.ID})
+	if err != nil {
+		if err := discordbot.Respond(s, i, ratelimit.Reply(err), true); err != nil {
+			slog.ErrorContext(ctx, "Error responding to /ask", "error", err)
+		}
+		return
+	}
+
+	if err := discordbot.Defer(s, i, false); err != nil {
+		slog.ErrorContext(ctx, "Error deferring /ask", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, configWatcher.Config().Discord.TurnTimeout)
+	defer cancel()
+
+	data := i.ApplicationCommandData()
+	var contents []types.MessageContent
+	for _, option := range data.Options {
+		switch option.Name {
+		case "question":
+			contents = append(contents, types.MessageContent{Type: "text", Content: option.StringValue()})
+		case "image":
+			attachment, exists := data.Resolved.Attachments[option.Value.(string)]
+			if !exists {
+				continue
+			}
+			content, err := attachmentIngestor.LoadAttachment(ctx, attachment)
+			if err != nil {
+				slog.WarnContext(ctx, "Skipping attachment", "attachment", attachment.Filename, "error", err)
+				content = types.MessageContent{Type: "text", Content: fmt.Sprintf("[Attachment %s could not be processed: %v]", attachment.Filename, err)}
+			}
+			contents = append(contents, content)
+		}
+	}
+
+	slog.InfoContext(ctx, "Received /ask", "username", user.Username)
+	msg := types.NewMessage(user.Username, contents)
+
+	responseText, err := messages.RunTurn(ctx, i.GuildID, i.ChannelID, msg, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting response from agent", "error", err)
+		responseText = handler.FailureReply(ctx)
+	}
+
+	_, replySpan := tracing.Start(ctx, "Discord.SendReply")
+	err = discordbot.FollowUp(s, i, responseText)
+	tracing.End(replySpan, err)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error sending /ask response", "error", err)
+	}
+}
+
+// resetCommand clears the channel's conversation memory
+func resetCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	ctx, span := handler.StartRequest("Discord.Command", i.GuildID, i.ChannelID, discordbot.InteractionUser(i).ID)
+	span.SetAttributes(tracing.Command.String("reset"))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	reply := "🧹 I've forgotten our conversation in this channel."
+	err := channelAgents.Do(ctx, i.GuildID, i.ChannelID, func(currentAgent *agent.Agent) error {
+		currentAgent.ClearMemory()
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error resetting memory", "error", err)
+		tracing.Fail(span, err)
+		reply = "I'm busy answering in this channel right now. Please try again in a moment."
+	}
+
+	if err := discordbot.Respond(s, i, reply, false); err != nil {
+		slog.ErrorContext(ctx, "Error responding to /reset", "error", err)
+	}
+}
+
+// toolsCommand lists the tools available in the channel
+func toolsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	channelTools, _ := channelSettings(configWatcher.Config(), i.GuildID, i.ChannelID)
+	lines := make([]string, 0, len(channelTools))
+	for _, tool := range channelTools {
+		lines = append(lines, fmt.Sprintf("• **%s**: %s", tool.Name(), tool.Description()))
+	}
+
+	reply := "I don't have any tools available."
+	if len(lines) > 0 {
+		reply = "Here are the tools I can use:\n" + strings.Join(lines, "\n")
+	}
+
+	if err := discordbot.Respond(s, i, reply, true); err != nil {
+		slog.Error("Error responding to /tools", "error", err)
+	}
+}
+
+// modelCommand shows the active model
+func modelCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	reply := fmt.Sprintf("I'm running on `%s`.", model.Name())
+	if err := discordbot.Respond(s, i, reply, true); err != nil {
+		slog.Error("Error responding to /model", "error", err)
+	}
+}
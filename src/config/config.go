@@ -0,0 +1,375 @@
+package config
+
+import (
+	"bytes"
+	"discord-gemini-bot/src/logging"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/ratelimit"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is the config file loaded when CONFIG_FILE isn't set, if it exists
+const DefaultPath = "config.yaml"
+
+// Names of the tools that can be enabled
+const (
+	ToolGoogleSearch = "google_search"
+	ToolURLFetch     = "url_fetch"
+)
+
+// Config is the bot's configuration. It is read from a YAML file, then environment
+// variables override the file's values.
+type Config struct {
+	Discord     DiscordConfig     `yaml:"discord"`
+	Model       ModelConfig       `yaml:"model"`
+	Agent       AgentConfig       `yaml:"agent"`
+	Tools       ToolsConfig       `yaml:"tools"`
+	Memory      MemoryConfig      `yaml:"memory"`
+	Attachments AttachmentsConfig `yaml:"attachments"`
+	Reload      ReloadConfig      `yaml:"reload"`
+	Monitoring  MonitoringConfig  `yaml:"monitoring"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Limits      LimitsConfig      `yaml:"limits"`
+	// Guilds maps guild IDs to settings for every channel in the guild
+	Guilds map[string]Override `yaml:"guilds"`
+	// Channels maps channel IDs to settings applied over their guild's
+	Channels map[string]Override `yaml:"channels"`
+
+	// files are the config and prompt files the configuration was read from
+	files []string
+}
+
+// DiscordConfig configures the Discord connection and replies
+type DiscordConfig struct {
+	// Token is the bot token. Prefer setting DISCORD_BOT_TOKEN to keeping it in the file.
+	Token string `yaml:"token"`
+	// CommandGuildID registers slash commands in this guild only, for instant updates
+	CommandGuildID string `yaml:"command_guild_id"`
+	// StreamResponses edits a placeholder reply as the answer is generated
+	StreamResponses bool `yaml:"stream_responses"`
+	// TurnTimeout is how long a single turn may take
+	TurnTimeout time.Duration `yaml:"turn_timeout"`
+	// ShutdownTimeout is how long running turns may take to finish on shutdown before
+	// they are cancelled
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// ModelConfig selects the model backend and its default generation settings
+type ModelConfig struct {
+	// Provider is gemini, openai or ollama
+	Provider string `yaml:"provider"`
+	// Name is the model name, which Gemini defaults
+	Name string `yaml:"name"`
+	// BaseURL is the address of an OpenAI-compatible or Ollama server
+	BaseURL string `yaml:"base_url"`
+	// APIKey authenticates with Gemini or an OpenAI-compatible server
+	APIKey string `yaml:"api_key"`
+	// Fallbacks are models of the same provider tried in order when the model fails
+	Fallbacks []string `yaml:"fallbacks"`
+	// MaxAttempts is the number of attempts per model on temporary failures
+	MaxAttempts int `yaml:"max_attempts"`
+	// Generation sets the models' default generation parameters and safety settings
+	Generation models.GenerateOptions `yaml:"generation"`
+}
+
+// AgentConfig configures the channel agents
+type AgentConfig struct {
+	// MaxSteps is the maximum number of model calls made for a single response
+	MaxSteps int `yaml:"max_steps"`
+	// Persona replaces the default persona at the start of the system prompt
+	Persona string `yaml:"persona"`
+	// PersonaFile is a text file read into Persona
+	PersonaFile string `yaml:"persona_file"`
+	// NativeFunctionCalling declares tools as Gemini functions instead of ReAct prompting
+	NativeFunctionCalling bool `yaml:"native_function_calling"`
+	// DebugTranscript posts the full Thought/Action/Observation transcript
+	DebugTranscript bool `yaml:"debug_transcript"`
+	// IdleTTL is how long a channel's agent is kept after its last turn
+	IdleTTL time.Duration `yaml:"idle_ttl"`
+	// EvictionInterval is how often idle agents are evicted
+	EvictionInterval time.Duration `yaml:"eviction_interval"`
+}
+
+// ToolsConfig enables and configures the agents' tools
+type ToolsConfig struct {
+	GoogleSearch GoogleSearchConfig `yaml:"google_search"`
+	URLFetch     ToolConfig         `yaml:"url_fetch"`
+}
+
+// ToolConfig enables a tool
+type ToolConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// GoogleSearchConfig enables and configures the Google search tool
+type GoogleSearchConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	APIKey  string `yaml:"api_key"`
+	CSEID   string `yaml:"cse_id"`
+}
+
+// Enabled returns the names of the enabled tools
+func (t ToolsConfig) Enabled() []string {
+	var names []string
+	if t.GoogleSearch.Enabled {
+		names = append(names, ToolGoogleSearch)
+	}
+	if t.URLFetch.Enabled {
+		names = append(names, ToolURLFetch)
+	}
+	return names
+}
+
+// MemoryConfig configures the channels' conversation memory
+type MemoryConfig struct {
+	// WindowSize is the number of messages remembered per channel
+	WindowSize int `yaml:"window_size"`
+	// Store persists memory across restarts: "" (in process only), json or sqlite
+	Store string `yaml:"store"`
+	// StorePath is the store's directory (json) or database file (sqlite)
+	StorePath string `yaml:"store_path"`
+	// TokenBudget limits each channel's memory to this many tokens when above zero
+	TokenBudget int `yaml:"token_budget"`
+	// TokenCounter counts tokens for the budget: estimate or gemini
+	TokenCounter string `yaml:"token_counter"`
+	// Summarize folds evicted history into a running summary
+	Summarize bool `yaml:"summarize"`
+}
+
+// AttachmentsConfig configures how message attachments are passed to the model
+type AttachmentsConfig struct {
+	// ImageTypes are the MIME types of the images passed to the model
+	ImageTypes []string `yaml:"image_types"`
+	// DownloadTimeout is how long downloading an attachment may take
+	DownloadTimeout time.Duration `yaml:"download_timeout"`
+}
+
+// ReloadConfig configures reloading the configuration while the bot runs
+type ReloadConfig struct {
+	// Watch reloads the configuration when the config or prompt files change
+	Watch bool `yaml:"watch"`
+	// Interval is how often the files are checked for changes
+	Interval time.Duration `yaml:"interval"`
+}
+
+// MonitoringConfig configures the HTTP server of the metrics and health endpoints
+type MonitoringConfig struct {
+	// Address is the host:port the server listens on, or empty to disable it
+	Address string `yaml:"address"`
+}
+
+// LoggingConfig configures the bot's logs
+type LoggingConfig struct {
+	// Level is the minimum level logged: debug, info, warn or error
+	Level slog.Level `yaml:"level"`
+	// Format is json or text
+	Format string `yaml:"format"`
+	// RedactMessages masks message contents, model output and tool results in the logs
+	RedactMessages bool `yaml:"redact_messages"`
+}
+
+// TracingConfig configures exporting OpenTelemetry spans
+type TracingConfig struct {
+	// Exporter is stdout, otlp, or empty to disable tracing
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the host:port of the OTLP/HTTP collector
+	Endpoint string `yaml:"endpoint"`
+	// Insecure sends spans to the collector over plain HTTP
+	Insecure bool `yaml:"insecure"`
+	// SampleRatio is the fraction of messages traced, from 0 to 1
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// LimitsConfig configures rate limits per user, channel and guild, daily model
+// budgets and quotas of expensive tools
+type LimitsConfig struct {
+	ratelimit.Limits `yaml:",inline"`
+	// StatePath is the file the daily counters are saved to so they survive restarts,
+	// or empty to keep them in memory
+	StatePath string `yaml:"state_path"`
+}
+
+// Override holds the settings of a guild or channel that replace the global ones
+type Override struct {
+	// Persona replaces the agent persona when set
+	Persona string `yaml:"persona"`
+	// PersonaFile is a text file read into Persona
+	PersonaFile string `yaml:"persona_file"`
+	// Tools restricts the agent to these of the enabled tools when set
+	Tools []string `yaml:"tools"`
+	// Generation is applied over the model's generation settings
+	Generation models.GenerateOptions `yaml:"generation"`
+}
+
+// Default returns the configuration used for settings that aren't configured
+func Default() *Config {
+	return &Config{
+		Discord: DiscordConfig{
+			StreamResponses: true,
+			TurnTimeout:     60 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Model: ModelConfig{
+			Provider:    models.ProviderGemini,
+			MaxAttempts: models.DefaultRetryPolicy().MaxAttempts,
+		},
+		Agent: AgentConfig{
+			MaxSteps:         5,
+			IdleTTL:          6 * time.Hour,
+			EvictionInterval: 10 * time.Minute,
+		},
+		Tools: ToolsConfig{
+			GoogleSearch: GoogleSearchConfig{Enabled: true},
+			URLFetch:     ToolConfig{Enabled: true},
+		},
+		Memory: MemoryConfig{
+			WindowSize:   20,
+			TokenCounter: "estimate",
+		},
+		Attachments: AttachmentsConfig{
+			ImageTypes:      []string{"image/png", "image/jpeg", "image/webp", "image/gif"},
+			DownloadTimeout: 30 * time.Second,
+		},
+		Reload: ReloadConfig{
+			Watch:    true,
+			Interval: 5 * time.Second,
+		},
+		Logging: LoggingConfig{
+			Level:  slog.LevelInfo,
+			Format: logging.FormatJSON,
+		},
+		Tracing: TracingConfig{
+			Endpoint:    "localhost:4318",
+			Insecure:    true,
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Path returns the config file to load: CONFIG_FILE if set, otherwise DefaultPath if
+// it exists, otherwise "" to use the defaults and environment variables only
+func Path() string {
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return path
+	}
+	if _, err := os.Stat(DefaultPath); err == nil {
+		return DefaultPath
+	}
+	return ""
+}
+
+// Load reads the config file at path, which may be empty, over the defaults, applies
+// environment variable overrides and validates the result. Every invalid value is
+// reported in a single *ValidationError.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+		cfg.files = append(cfg.files, path)
+	}
+
+	problems := cfg.applyEnv()
+	problems = append(problems, cfg.readPersonas()...)
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Path: path, Errors: problems}
+	}
+	return cfg, nil
+}
+
+// readFile decodes the YAML file at path over c, rejecting unknown keys
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return nil
+}
+
+// Files returns the config and prompt files the configuration was read from
+func (c *Config) Files() []string {
+	return c.files
+}
+
+// readPersonas reads the persona files of the agent, guilds and channels
+func (c *Config) readPersonas() []*FieldError {
+	var problems []*FieldError
+	read := func(key, path string, persona *string) {
+		if path == "" {
+			return
+		}
+		if *persona != "" {
+			problems = append(problems, &FieldError{Key: key, Message: "persona and persona_file can't both be set"})
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			problems = append(problems, &FieldError{Key: key, Message: err.Error()})
+			return
+		}
+		*persona = string(data)
+		c.files = append(c.files, path)
+	}
+
+	read("agent.persona_file", c.Agent.PersonaFile, &c.Agent.Persona)
+	for _, scope := range []struct {
+		name      string
+		overrides map[string]Override
+	}{{"guilds", c.Guilds}, {"channels", c.Channels}} {
+		for _, id := range sortedKeys(scope.overrides) {
+			override := scope.overrides[id]
+			read(fmt.Sprintf("%s.%s.persona_file", scope.name, id), override.PersonaFile, &override.Persona)
+			scope.overrides[id] = override
+		}
+	}
+	return problems
+}
+
+// Resolve returns the settings of a channel: the channel's override applied over its
+// guild's, over the global persona, enabled tools and model generation settings.
+// Tools is always the full list of tools the channel's agent may use.
+func (c *Config) Resolve(guildID, channelID string) Override {
+	resolved := Override{
+		Persona:    c.Agent.Persona,
+		Tools:      c.Tools.Enabled(),
+		Generation: c.Model.Generation,
+	}
+
+	var overrides []Override
+	if override, ok := c.Guilds[guildID]; ok && guildID != "" {
+		overrides = append(overrides, override)
+	}
+	if override, ok := c.Channels[channelID]; ok {
+		overrides = append(overrides, override)
+	}
+
+	for _, override := range overrides {
+		if override.Persona != "" {
+			resolved.Persona = override.Persona
+		}
+		if len(override.Tools) > 0 {
+			resolved.Tools = slices.DeleteFunc(slices.Clone(c.Tools.Enabled()), func(name string) bool {
+				return !slices.Contains(override.Tools, name)
+			})
+		}
+		resolved.Generation = resolved.Generation.Merge(&override.Generation)
+	}
+	return resolved
+}
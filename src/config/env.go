@@ -0,0 +1,194 @@
+package config
+
+import (
+	"discord-gemini-bot/src/models"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envOverride sets a config key from an environment variable
+type envOverride struct {
+	name string
+	key  string
+	set  func(c *Config, value string) error
+}
+
+// envVar creates an override that parses the variable's value into the field
+func envVar[T any](name, key string, parse func(string) (T, error), field func(c *Config) *T) envOverride {
+	return envOverride{
+		name: name,
+		key:  key,
+		set: func(c *Config, value string) error {
+			parsed, err := parse(value)
+			if err != nil {
+				return err
+			}
+			*field(c) = parsed
+			return nil
+		},
+	}
+}
+
+// envOverrides are the environment variables that override the config file, applied in order
+var envOverrides = []envOverride{
+	envVar("DISCORD_BOT_TOKEN", "discord.token", parseString, func(c *Config) *string { return &c.Discord.Token }),
+	envVar("DISCORD_COMMAND_GUILD_ID", "discord.command_guild_id", parseString, func(c *Config) *string { return &c.Discord.CommandGuildID }),
+	envVar("STREAM_RESPONSES", "discord.stream_responses", strconv.ParseBool, func(c *Config) *bool { return &c.Discord.StreamResponses }),
+	envVar("TURN_TIMEOUT", "discord.turn_timeout", time.ParseDuration, func(c *Config) *time.Duration { return &c.Discord.TurnTimeout }),
+	envVar("SHUTDOWN_TIMEOUT", "discord.shutdown_timeout", time.ParseDuration, func(c *Config) *time.Duration { return &c.Discord.ShutdownTimeout }),
+
+	envVar("MODEL_PROVIDER", "model.provider", parseLower, func(c *Config) *string { return &c.Model.Provider }),
+	envVar("MODEL_NAME", "model.name", parseString, func(c *Config) *string { return &c.Model.Name }),
+	envVar("MODEL_BASE_URL", "model.base_url", parseString, func(c *Config) *string { return &c.Model.BaseURL }),
+	envVar("MODEL_API_KEY", "model.api_key", parseString, func(c *Config) *string { return &c.Model.APIKey }),
+	envVar("MODEL_FALLBACKS", "model.fallbacks", parseList, func(c *Config) *[]string { return &c.Model.Fallbacks }),
+	envVar("MODEL_MAX_ATTEMPTS", "model.max_attempts", strconv.Atoi, func(c *Config) *int { return &c.Model.MaxAttempts }),
+	envVar("MODEL_TEMPERATURE", "model.generation.temperature", parseFloat32Ptr, func(c *Config) **float32 { return &c.Model.Generation.Temperature }),
+	envVar("MODEL_TOP_P", "model.generation.top_p", parseFloat32Ptr, func(c *Config) **float32 { return &c.Model.Generation.TopP }),
+	envVar("MODEL_TOP_K", "model.generation.top_k", parseInt32Ptr, func(c *Config) **int32 { return &c.Model.Generation.TopK }),
+	envVar("MODEL_MAX_OUTPUT_TOKENS", "model.generation.max_output_tokens", parseInt32, func(c *Config) *int32 { return &c.Model.Generation.MaxOutputTokens }),
+	envVar("MODEL_CANDIDATE_COUNT", "model.generation.candidate_count", parseInt32, func(c *Config) *int32 { return &c.Model.Generation.CandidateCount }),
+	envVar("MODEL_STOP_SEQUENCES", "model.generation.stop_sequences", parseStopSequences, func(c *Config) *[]string { return &c.Model.Generation.StopSequences }),
+	envVar("MODEL_SAFETY_SETTINGS", "model.generation.safety_settings", models.ParseSafetySettings, func(c *Config) *[]models.SafetySetting { return &c.Model.Generation.SafetySettings }),
+
+	envVar("NATIVE_FUNCTION_CALLING", "agent.native_function_calling", strconv.ParseBool, func(c *Config) *bool { return &c.Agent.NativeFunctionCalling }),
+	envVar("DEBUG_TRANSCRIPT", "agent.debug_transcript", strconv.ParseBool, func(c *Config) *bool { return &c.Agent.DebugTranscript }),
+
+	envVar("GOOGLE_API_KEY", "tools.google_search.api_key", parseString, func(c *Config) *string { return &c.Tools.GoogleSearch.APIKey }),
+	envVar("GOOGLE_CSE_ID", "tools.google_search.cse_id", parseString, func(c *Config) *string { return &c.Tools.GoogleSearch.CSEID }),
+
+	envVar("MEMORY_WINDOW_SIZE", "memory.window_size", strconv.Atoi, func(c *Config) *int { return &c.Memory.WindowSize }),
+	envVar("MEMORY_STORE", "memory.store", parseLower, func(c *Config) *string { return &c.Memory.Store }),
+	envVar("MEMORY_STORE_PATH", "memory.store_path", parseString, func(c *Config) *string { return &c.Memory.StorePath }),
+	envVar("MEMORY_TOKEN_BUDGET", "memory.token_budget", strconv.Atoi, func(c *Config) *int { return &c.Memory.TokenBudget }),
+	envVar("MEMORY_TOKEN_COUNTER", "memory.token_counter", parseLower, func(c *Config) *string { return &c.Memory.TokenCounter }),
+	envVar("MEMORY_SUMMARIZE", "memory.summarize", strconv.ParseBool, func(c *Config) *bool { return &c.Memory.Summarize }),
+
+	envVar("CONFIG_WATCH", "reload.watch", strconv.ParseBool, func(c *Config) *bool { return &c.Reload.Watch }),
+	envVar("MONITORING_ADDR", "monitoring.address", parseString, func(c *Config) *string { return &c.Monitoring.Address }),
+
+	envVar("LOG_LEVEL", "logging.level", parseLevel, func(c *Config) *slog.Level { return &c.Logging.Level }),
+	envVar("LOG_FORMAT", "logging.format", parseLower, func(c *Config) *string { return &c.Logging.Format }),
+	envVar("LOG_REDACT_MESSAGES", "logging.redact_messages", strconv.ParseBool, func(c *Config) *bool { return &c.Logging.RedactMessages }),
+
+	envVar("TRACING_EXPORTER", "tracing.exporter", parseLower, func(c *Config) *string { return &c.Tracing.Exporter }),
+	envVar("TRACING_ENDPOINT", "tracing.endpoint", parseString, func(c *Config) *string { return &c.Tracing.Endpoint }),
+	envVar("TRACING_INSECURE", "tracing.insecure", strconv.ParseBool, func(c *Config) *bool { return &c.Tracing.Insecure }),
+	envVar("TRACING_SAMPLE_RATIO", "tracing.sample_ratio", parseFloat64, func(c *Config) *float64 { return &c.Tracing.SampleRatio }),
+
+	envVar("LIMITS_USER_PER_MINUTE", "limits.user.per_minute", parseFloat64, func(c *Config) *float64 { return &c.Limits.User.PerMinute }),
+	envVar("LIMITS_CHANNEL_PER_MINUTE", "limits.channel.per_minute", parseFloat64, func(c *Config) *float64 { return &c.Limits.Channel.PerMinute }),
+	envVar("LIMITS_GUILD_PER_MINUTE", "limits.guild.per_minute", parseFloat64, func(c *Config) *float64 { return &c.Limits.Guild.PerMinute }),
+	envVar("LIMITS_DAILY_TOKENS", "limits.global.daily_tokens", strconv.Atoi, func(c *Config) *int { return &c.Limits.Global.DailyTokens }),
+	envVar("LIMITS_DAILY_COST", "limits.global.daily_cost", parseFloat64, func(c *Config) *float64 { return &c.Limits.Global.DailyCost }),
+	envVar("LIMITS_STATE_PATH", "limits.state_path", parseString, func(c *Config) *string { return &c.Limits.StatePath }),
+}
+
+// applyEnv applies the environment variables that are set and not empty over c,
+// returning an error for each value that can't be parsed
+func (c *Config) applyEnv() []*FieldError {
+	var problems []*FieldError
+	for _, override := range envOverrides {
+		value := os.Getenv(override.name)
+		if value == "" {
+			continue
+		}
+		if err := override.set(c, value); err != nil {
+			problems = append(problems, &FieldError{
+				Key:     fmt.Sprintf("%s (%s)", override.name, override.key),
+				Message: fmt.Sprintf("invalid value %q: %v", value, unwrapNumError(err)),
+			})
+		}
+	}
+
+	// GEMINI_API_KEY is the Gemini provider's key, kept for existing .env files; an
+	// explicit MODEL_API_KEY takes precedence
+	if key := os.Getenv("GEMINI_API_KEY"); key != "" && os.Getenv("MODEL_API_KEY") == "" && c.Model.Provider == models.ProviderGemini {
+		c.Model.APIKey = key
+	}
+
+	return problems
+}
+
+// unwrapNumError drops strconv's function name and input from number parsing errors,
+// which the caller already reports
+func unwrapNumError(err error) error {
+	if numErr, ok := err.(*strconv.NumError); ok {
+		return numErr.Err
+	}
+	return err
+}
+
+// parseString returns the value as is
+func parseString(value string) (string, error) {
+	return value, nil
+}
+
+// parseLower returns the value trimmed and in lower case
+func parseLower(value string) (string, error) {
+	return strings.ToLower(strings.TrimSpace(value)), nil
+}
+
+// parseList splits a comma-separated value, trimming items and dropping empty ones
+func parseList(value string) ([]string, error) {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// parseStopSequences splits a '|'-separated value, keeping whitespace since it may
+// be part of a stop sequence
+func parseStopSequences(value string) ([]string, error) {
+	var items []string
+	for _, item := range strings.Split(value, "|") {
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// parseFloat32Ptr parses a float32 for an optional setting
+func parseFloat32Ptr(value string) (*float32, error) {
+	parsed, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return nil, err
+	}
+	result := float32(parsed)
+	return &result, nil
+}
+
+// parseInt32Ptr parses an int32 for an optional setting
+func parseInt32Ptr(value string) (*int32, error) {
+	parsed, err := parseInt32(value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// parseInt32 parses an int32
+func parseInt32(value string) (int32, error) {
+	parsed, err := strconv.ParseInt(value, 10, 32)
+	return int32(parsed), err
+}
+
+// parseFloat64 parses a float64
+func parseFloat64(value string) (float64, error) {
+	return strconv.ParseFloat(value, 64)
+}
+
+// parseLevel parses a log level such as debug or WARN
+func parseLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(value)))
+	return level, err
+}
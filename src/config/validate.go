@@ -0,0 +1,183 @@
+package config
+
+import (
+	"discord-gemini-bot/src/logging"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/ratelimit"
+	"discord-gemini-bot/src/tracing"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// FieldError is an invalid configuration value
+type FieldError struct {
+	// Key is the config key, such as model.provider, or the environment variable and
+	// the key it sets
+	Key     string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Key + ": " + e.Message
+}
+
+// ValidationError reports every invalid value of a configuration
+type ValidationError struct {
+	// Path is the config file, or empty when none was loaded
+	Path   string
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("invalid configuration")
+	if e.Path != "" {
+		fmt.Fprintf(&sb, " in %s", e.Path)
+	}
+	for _, err := range e.Errors {
+		fmt.Fprintf(&sb, "\n  %s", err)
+	}
+	return sb.String()
+}
+
+// validate checks every value of c and normalizes its safety settings, returning
+// an error for each invalid value
+func (c *Config) validate() []*FieldError {
+	var problems []*FieldError
+	check := func(ok bool, key, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, &FieldError{Key: key, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	check(c.Discord.Token != "", "discord.token", "is required, set it or DISCORD_BOT_TOKEN")
+	check(c.Discord.TurnTimeout > 0, "discord.turn_timeout", "must be positive, got %s", c.Discord.TurnTimeout)
+	check(c.Discord.ShutdownTimeout > 0, "discord.shutdown_timeout", "must be positive, got %s", c.Discord.ShutdownTimeout)
+
+	providers := []string{models.ProviderGemini, models.ProviderOpenAI, models.ProviderOllama}
+	check(slices.Contains(providers, c.Model.Provider), "model.provider", "unknown provider %q, expected one of %s", c.Model.Provider, strings.Join(providers, ", "))
+	switch c.Model.Provider {
+	case models.ProviderGemini:
+		check(c.Model.APIKey != "", "model.api_key", "is required for Gemini, set it or GEMINI_API_KEY")
+	case models.ProviderOpenAI, models.ProviderOllama:
+		check(c.Model.Name != "", "model.name", "is required for the %s provider", c.Model.Provider)
+	}
+	check(c.Model.MaxAttempts >= 1, "model.max_attempts", "must be at least 1, got %d", c.Model.MaxAttempts)
+	problems = append(problems, validateGeneration("model.generation", &c.Model.Generation)...)
+
+	check(c.Agent.MaxSteps >= 1, "agent.max_steps", "must be at least 1, got %d", c.Agent.MaxSteps)
+	check(c.Agent.IdleTTL > 0, "agent.idle_ttl", "must be positive, got %s", c.Agent.IdleTTL)
+	check(c.Agent.EvictionInterval > 0, "agent.eviction_interval", "must be positive, got %s", c.Agent.EvictionInterval)
+
+	check(c.Memory.WindowSize >= 1, "memory.window_size", "must be at least 1, got %d", c.Memory.WindowSize)
+	check(slices.Contains([]string{"", "json", "sqlite"}, c.Memory.Store), "memory.store", "unknown store %q, expected json, sqlite or empty for none", c.Memory.Store)
+	check(c.Memory.Store == "" || c.Memory.StorePath != "", "memory.store_path", "is required for the %s store", c.Memory.Store)
+	check(c.Memory.TokenBudget >= 0, "memory.token_budget", "must not be negative, got %d", c.Memory.TokenBudget)
+	check(slices.Contains([]string{"estimate", "gemini"}, c.Memory.TokenCounter), "memory.token_counter", "unknown token counter %q, expected estimate or gemini", c.Memory.TokenCounter)
+	check(c.Memory.TokenCounter != "gemini" || c.Model.Provider == models.ProviderGemini, "memory.token_counter", "gemini requires the gemini model provider")
+
+	check(len(c.Attachments.ImageTypes) > 0, "attachments.image_types", "must list at least one MIME type")
+	for i, imageType := range c.Attachments.ImageTypes {
+		check(strings.HasPrefix(imageType, "image/"), fmt.Sprintf("attachments.image_types[%d]", i), "%q is not an image MIME type", imageType)
+	}
+	check(c.Attachments.DownloadTimeout > 0, "attachments.download_timeout", "must be positive, got %s", c.Attachments.DownloadTimeout)
+	check(c.Reload.Interval > 0, "reload.interval", "must be positive, got %s", c.Reload.Interval)
+	if c.Monitoring.Address != "" {
+		_, _, err := net.SplitHostPort(c.Monitoring.Address)
+		check(err == nil, "monitoring.address", "must be host:port or :port, got %q", c.Monitoring.Address)
+	}
+	check(slices.Contains([]string{logging.FormatJSON, logging.FormatText}, c.Logging.Format), "logging.format", "unknown format %q, expected json or text", c.Logging.Format)
+	check(slices.Contains([]string{tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP}, c.Tracing.Exporter), "tracing.exporter", "unknown exporter %q, expected stdout, otlp or empty for none", c.Tracing.Exporter)
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio", "must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+
+	problems = append(problems, validateLimits(&c.Limits)...)
+
+	problems = append(problems, validateOverrides("guilds", c.Guilds)...)
+	problems = append(problems, validateOverrides("channels", c.Channels)...)
+	return problems
+}
+
+// validateLimits checks the limits of every scope, the pricing and the tool quotas
+func validateLimits(c *LimitsConfig) []*FieldError {
+	var problems []*FieldError
+	check := func(ok bool, key, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, &FieldError{Key: key, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	priced := c.Pricing.InputPerMillion > 0 || c.Pricing.OutputPerMillion > 0
+	for _, scope := range []struct {
+		name  string
+		limit ratelimit.Limit
+	}{
+		{ratelimit.ScopeUser, c.User},
+		{ratelimit.ScopeChannel, c.Channel},
+		{ratelimit.ScopeGuild, c.Guild},
+		{ratelimit.ScopeGlobal, c.Global},
+	} {
+		key := "limits." + scope.name
+		check(scope.limit.PerMinute >= 0, key+".per_minute", "must not be negative, got %g", scope.limit.PerMinute)
+		check(scope.limit.Burst >= 0, key+".burst", "must not be negative, got %d", scope.limit.Burst)
+		check(scope.limit.DailyTokens >= 0, key+".daily_tokens", "must not be negative, got %d", scope.limit.DailyTokens)
+		check(scope.limit.DailyCost >= 0, key+".daily_cost", "must not be negative, got %g", scope.limit.DailyCost)
+		check(scope.limit.DailyCost == 0 || priced, key+".daily_cost", "requires limits.pricing to estimate costs")
+	}
+	check(c.Pricing.InputPerMillion >= 0, "limits.pricing.input_per_million", "must not be negative, got %g", c.Pricing.InputPerMillion)
+	check(c.Pricing.OutputPerMillion >= 0, "limits.pricing.output_per_million", "must not be negative, got %g", c.Pricing.OutputPerMillion)
+
+	names := make([]string, 0, len(c.Tools))
+	for name := range c.Tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		quota := c.Tools[name]
+		key := "limits.tools." + name
+		check(name == ToolGoogleSearch || name == ToolURLFetch, key, "unknown tool %q, expected %s or %s", name, ToolGoogleSearch, ToolURLFetch)
+		check(quota.Daily >= 0, key+".daily", "must not be negative, got %d", quota.Daily)
+		check(quota.PerUserDaily >= 0, key+".per_user_daily", "must not be negative, got %d", quota.PerUserDaily)
+	}
+	return problems
+}
+
+// validateOverrides checks the overrides of every guild or channel
+func validateOverrides(scope string, overrides map[string]Override) []*FieldError {
+	var problems []*FieldError
+	for _, id := range sortedKeys(overrides) {
+		override := overrides[id]
+		key := fmt.Sprintf("%s.%s", scope, id)
+		for i, name := range override.Tools {
+			if name != ToolGoogleSearch && name != ToolURLFetch {
+				problems = append(problems, &FieldError{
+					Key:     fmt.Sprintf("%s.tools[%d]", key, i),
+					Message: fmt.Sprintf("unknown tool %q, expected %s or %s", name, ToolGoogleSearch, ToolURLFetch),
+				})
+			}
+		}
+		problems = append(problems, validateGeneration(key+".generation", &override.Generation)...)
+		overrides[id] = override
+	}
+	return problems
+}
+
+// sortedKeys returns the IDs of overrides in order, so reports are stable
+func sortedKeys(overrides map[string]Override) []string {
+	ids := make([]string, 0, len(overrides))
+	for id := range overrides {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// validateGeneration checks generation settings, normalizing their safety settings
+func validateGeneration(key string, options *models.GenerateOptions) []*FieldError {
+	if err := options.Validate(); err != nil {
+		return []*FieldError{{Key: key, Message: err.Error()}}
+	}
+	return nil
+}
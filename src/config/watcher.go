@@ -0,0 +1,162 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Watcher holds the current configuration and reloads it from its file on request
+// or when the config or prompt files change. A reload that fails to load or validate
+// is rejected and the last good configuration is kept.
+type Watcher struct {
+	path    string
+	current atomic.Pointer[Config]
+
+	// mu serializes reloads and guards the fields below
+	mu        sync.Mutex
+	onReload  []func(*Config)
+	fileStamp map[string]fileStamp
+}
+
+// fileStamp identifies a version of a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewWatcher creates a watcher of the configuration loaded from path
+func NewWatcher(path string, cfg *Config) *Watcher {
+	w := &Watcher{path: path}
+	w.current.Store(cfg)
+	w.fileStamp = stampFiles(cfg.Files())
+	return w
+}
+
+// Config returns the current configuration, which must not be modified. Callers
+// should keep the returned value for the duration of a request so it sees a
+// consistent configuration.
+func (w *Watcher) Config() *Config {
+	return w.current.Load()
+}
+
+// OnReload registers fn to be called with the new configuration after each successful reload
+func (w *Watcher) OnReload(fn func(cfg *Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onReload = append(w.onReload, fn)
+}
+
+// Reload loads the configuration again and makes it current. If it is invalid, the
+// error is returned and the current configuration is kept.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cfg, err := Load(w.path)
+	if err != nil {
+		slog.Error("Rejected config reload, keeping the last good configuration", "error", err)
+		return err
+	}
+
+	previous := w.current.Load()
+	for _, key := range RestartRequired(previous, cfg) {
+		slog.Warn("Config changed, restart the bot to apply it", "key", key)
+	}
+
+	w.current.Store(cfg)
+	w.fileStamp = stampFiles(cfg.Files())
+	slog.Info("Reloaded configuration")
+
+	for _, fn := range w.onReload {
+		fn(cfg)
+	}
+	return nil
+}
+
+// Watch checks the config and prompt files every interval until ctx is done,
+// reloading when any of them changes
+func (w *Watcher) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if w.changed() {
+				w.Reload()
+			}
+		}
+	}
+}
+
+// changed reports whether any watched file changed since the last reload. Changes
+// are only reported once, so a rejected file isn't reloaded until it changes again.
+func (w *Watcher) changed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	files := make([]string, 0, len(w.fileStamp))
+	for path := range w.fileStamp {
+		files = append(files, path)
+	}
+	stamps := stampFiles(files)
+	if reflect.DeepEqual(stamps, w.fileStamp) {
+		return false
+	}
+	w.fileStamp = stamps
+	return true
+}
+
+// stampFiles returns the current version of each file, with a zero stamp for missing files
+func stampFiles(files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, path := range files {
+		if info, err := os.Stat(path); err == nil {
+			stamps[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		} else {
+			stamps[path] = fileStamp{}
+		}
+	}
+	return stamps
+}
+
+// RestartRequired returns the keys that changed between two configurations but are
+// only applied when the bot starts
+func RestartRequired(previous, next *Config) []string {
+	var keys []string
+	for _, setting := range []struct {
+		key            string
+		previous, next interface{}
+	}{
+		{"discord.token", previous.Discord.Token, next.Discord.Token},
+		{"discord.command_guild_id", previous.Discord.CommandGuildID, next.Discord.CommandGuildID},
+		{"model.provider", previous.Model.Provider, next.Model.Provider},
+		{"model.name", previous.Model.Name, next.Model.Name},
+		{"model.base_url", previous.Model.BaseURL, next.Model.BaseURL},
+		{"model.api_key", previous.Model.APIKey, next.Model.APIKey},
+		{"model.fallbacks", previous.Model.Fallbacks, next.Model.Fallbacks},
+		{"model.max_attempts", previous.Model.MaxAttempts, next.Model.MaxAttempts},
+		{"agent.idle_ttl", previous.Agent.IdleTTL, next.Agent.IdleTTL},
+		{"agent.eviction_interval", previous.Agent.EvictionInterval, next.Agent.EvictionInterval},
+		{"memory", previous.Memory, next.Memory},
+		{"attachments", previous.Attachments, next.Attachments},
+		{"reload", previous.Reload, next.Reload},
+		{"monitoring", previous.Monitoring, next.Monitoring},
+		{"logging.format", previous.Logging.Format, next.Logging.Format},
+		{"logging.redact_messages", previous.Logging.RedactMessages, next.Logging.RedactMessages},
+		{"tracing", previous.Tracing, next.Tracing},
+		{"limits.state_path", previous.Limits.StatePath, next.Limits.StatePath},
+	} {
+		if !reflect.DeepEqual(setting.previous, setting.next) {
+			keys = append(keys, setting.key)
+		}
+	}
+	return keys
+}
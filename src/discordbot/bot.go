@@ -1,10 +1,11 @@
 package discordbot
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
-	"os/signal"
-	"syscall"
+	"log/slog"
+	"sync/atomic"
 
 	"github.com/bwmarrin/discordgo"
 )
@@ -17,6 +18,11 @@ type MessageHandler func(s *discordgo.Session, m *discordgo.MessageCreate)
 type Bot struct {
 	Session        *discordgo.Session
 	MessageHandler MessageHandler
+	Commands       *CommandRouter
+
+	// connected tracks the gateway connection from the session's events, as the
+	// session's own DataReady flag isn't safe to read concurrently
+	connected atomic.Bool
 }
 
 // NewBot creates and configures a new Discord bot
@@ -26,24 +32,56 @@ func NewBot(token string, handler MessageHandler) (*Bot, error) {
 		return nil, fmt.Errorf("error creating Discord session: %v", err)
 	}
 
+	bot := &Bot{
+		Session:        dg,
+		MessageHandler: handler,
+	}
+
 	dg.AddHandler(handler)
+	dg.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) { bot.connected.Store(true) })
+	dg.AddHandler(func(s *discordgo.Session, r *discordgo.Resumed) { bot.connected.Store(true) })
+	dg.AddHandler(func(s *discordgo.Session, d *discordgo.Disconnect) { bot.connected.Store(false) })
 	dg.Identify.Intents = discordgo.IntentsGuildMessages | discordgo.IntentsDirectMessages | discordgo.IntentsMessageContent
 
-	return &Bot{
-		Session:        dg,
-		MessageHandler: handler,
-	}, nil
+	return bot, nil
 }
 
-// Run starts the bot and blocks until termination
-func (b *Bot) Run() error {
+// Ready returns an error unless the bot is connected to the Discord gateway
+func (b *Bot) Ready() error {
+	if !b.connected.Load() {
+		return errors.New("not connected to the Discord gateway")
+	}
+	return nil
+}
+
+// SetCommands routes application command interactions to router and registers its
+// commands when the bot connects. An empty guildID registers global commands.
+func (b *Bot) SetCommands(router *CommandRouter, guildID string) {
+	b.Commands = router
+	b.Session.AddHandler(router.HandleInteraction)
+	b.Session.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) {
+		if err := router.Register(s, guildID); err != nil {
+			slog.Error("Error registering application commands", "error", err)
+			return
+		}
+		slog.Info("Registered application commands", "count", len(router.Definitions()))
+	})
+}
+
+// Run connects the bot to Discord and blocks until ctx is done. The session stays
+// open so that replies to messages already received can still be sent; call Close
+// to disconnect.
+func (b *Bot) Run(ctx context.Context) error {
 	if err := b.Session.Open(); err != nil {
 		return fmt.Errorf("error opening Discord connection: %v", err)
 	}
-	fmt.Println("Bot is now running. Press CTRL-C to exit.")
-	sc := make(chan os.Signal, 1)
-	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-	<-sc
-	b.Session.Close()
+	slog.Info("Bot is now running. Press CTRL-C to exit.")
+	<-ctx.Done()
 	return nil
 }
+
+// Close disconnects the bot from Discord
+func (b *Bot) Close() error {
+	b.connected.Store(false)
+	return b.Session.Close()
+}
@@ -0,0 +1,127 @@
+package discordbot
+
+import (
+	"discord-gemini-bot/src/utils"
+	"fmt"
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// MaxMessageLength is Discord's message length limit
+const MaxMessageLength = 2000
+
+// CommandHandler handles an application command interaction
+type CommandHandler func(s *discordgo.Session, i *discordgo.InteractionCreate)
+
+// Command is an application command definition and its handler
+type Command struct {
+	Definition *discordgo.ApplicationCommand
+	Handler    CommandHandler
+}
+
+// CommandRouter registers application commands and dispatches interactions to their handlers
+type CommandRouter struct {
+	commands map[string]*Command
+	order    []string
+}
+
+// NewCommandRouter creates a new, empty command router
+func NewCommandRouter() *CommandRouter {
+	return &CommandRouter{
+		commands: make(map[string]*Command),
+	}
+}
+
+// Add adds a command to the router, replacing any command with the same name
+func (r *CommandRouter) Add(command *Command) {
+	name := command.Definition.Name
+	if _, exists := r.commands[name]; !exists {
+		r.order = append(r.order, name)
+	}
+	r.commands[name] = command
+}
+
+// Definitions returns the definitions of all commands in the order they were added
+func (r *CommandRouter) Definitions() []*discordgo.ApplicationCommand {
+	definitions := make([]*discordgo.ApplicationCommand, 0, len(r.order))
+	for _, name := range r.order {
+		definitions = append(definitions, r.commands[name].Definition)
+	}
+	return definitions
+}
+
+// Register overwrites the application's commands with the router's commands. An
+// empty guildID registers global commands; a guild ID registers them instantly in
+// that guild, which is useful during development.
+func (r *CommandRouter) Register(s *discordgo.Session, guildID string) error {
+	_, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, guildID, r.Definitions())
+	if err != nil {
+		return fmt.Errorf("error registering application commands: %v", err)
+	}
+	return nil
+}
+
+// HandleInteraction dispatches application command interactions to their handler
+func (r *CommandRouter) HandleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	name := i.ApplicationCommandData().Name
+	command, exists := r.commands[name]
+	if !exists {
+		slog.Warn("Received unknown command", "command", name)
+		return
+	}
+	command.Handler(s, i)
+}
+
+// Respond sends an immediate response to an interaction
+func Respond(s *discordgo.Session, i *discordgo.InteractionCreate, content string, ephemeral bool) error {
+	data := &discordgo.InteractionResponseData{Content: content}
+	if ephemeral {
+		data.Flags = discordgo.MessageFlagsEphemeral
+	}
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: data,
+	})
+}
+
+// Defer acknowledges an interaction with a "thinking" state, so the response can
+// follow after Discord's 3 second acknowledgement window
+func Defer(s *discordgo.Session, i *discordgo.InteractionCreate, ephemeral bool) error {
+	resp := &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}
+	if ephemeral {
+		resp.Data = &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral}
+	}
+	return s.InteractionRespond(i.Interaction, resp)
+}
+
+// FollowUp completes a deferred interaction with content, replacing the "thinking"
+// state with the first chunk and sending the rest as follow-up messages
+func FollowUp(s *discordgo.Session, i *discordgo.InteractionCreate, content string) error {
+	chunks := utils.SplitLongText(content, MaxMessageLength)
+	if len(chunks) == 0 {
+		chunks = []string{"(empty response)"}
+	}
+
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &chunks[0]}); err != nil {
+		return fmt.Errorf("error editing interaction response: %v", err)
+	}
+	for n, chunk := range chunks[1:] {
+		if _, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{Content: chunk}); err != nil {
+			return fmt.Errorf("error sending follow-up chunk %d: %v", n+2, err)
+		}
+	}
+	return nil
+}
+
+// InteractionUser returns the user who triggered an interaction, in a guild or a DM
+func InteractionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
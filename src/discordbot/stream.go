@@ -0,0 +1,156 @@
+package discordbot
+
+import (
+	"discord-gemini-bot/src/utils"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// DefaultEditInterval is the minimum time between edits of a streaming message,
+// which keeps well within Discord's limit of 5 message edits per 5 seconds per channel
+const DefaultEditInterval = 1200 * time.Millisecond
+
+// MessageSender is the part of a Discord session used to post and edit a streaming message
+type MessageSender interface {
+	ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageEdit(channelID, messageID, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error
+}
+
+// StreamingMessage is a reply that is progressively edited as its text arrives. Text
+// longer than Discord's message length limit rolls over into follow-up messages.
+type StreamingMessage struct {
+	sender      MessageSender
+	channelID   string
+	placeholder string
+	interval    time.Duration
+
+	mu         sync.Mutex
+	messageIDs []string
+	contents   []string
+	pending    string
+	dirty      bool
+	lastRender time.Time
+	timer      *time.Timer
+	finished   bool
+}
+
+// NewStreamingMessage posts the placeholder to the channel and returns a streaming
+// message that edits it at most once per interval
+func NewStreamingMessage(sender MessageSender, channelID, placeholder string, interval time.Duration) (*StreamingMessage, error) {
+	msg, err := sender.ChannelMessageSend(channelID, placeholder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send placeholder message: %w", err)
+	}
+
+	return &StreamingMessage{
+		sender:      sender,
+		channelID:   channelID,
+		placeholder: placeholder,
+		interval:    interval,
+		messageIDs:  []string{msg.ID},
+		contents:    []string{placeholder},
+		lastRender:  time.Now(),
+	}, nil
+}
+
+// Update replaces the text of the message. Edits are throttled: text that arrives
+// within the interval of the last edit is sent once the interval has passed.
+func (sm *StreamingMessage) Update(text string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.finished {
+		return
+	}
+	sm.pending = text
+	sm.dirty = true
+
+	wait := sm.interval - time.Since(sm.lastRender)
+	if wait <= 0 {
+		sm.renderPending()
+		return
+	}
+	if sm.timer == nil {
+		sm.timer = time.AfterFunc(wait, sm.flush)
+	}
+}
+
+// Finish stops throttled updates and sets the final text of the message immediately
+func (sm *StreamingMessage) Finish(text string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.finished = true
+	if sm.timer != nil {
+		sm.timer.Stop()
+		sm.timer = nil
+	}
+	return sm.render(text)
+}
+
+// flush sends the latest pending text when the throttle interval has passed
+func (sm *StreamingMessage) flush() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.timer = nil
+	if sm.finished || !sm.dirty {
+		return
+	}
+	sm.renderPending()
+}
+
+// renderPending sends the pending text, logging failures as streaming is best effort
+func (sm *StreamingMessage) renderPending() {
+	if err := sm.render(sm.pending); err != nil {
+		slog.Warn("Error updating streaming message", "channel", sm.channelID, "error", err)
+	}
+}
+
+// render edits, sends and deletes messages so the channel shows text split at the message length limit
+func (sm *StreamingMessage) render(text string) error {
+	sm.dirty = false
+	sm.lastRender = time.Now()
+
+	chunks := utils.SplitLongText(text, MaxMessageLength)
+	if len(chunks) == 0 {
+		// Discord rejects empty messages, so keep the placeholder until there is text
+		chunks = []string{sm.placeholder}
+	}
+
+	for i, chunk := range chunks {
+		if i < len(sm.messageIDs) {
+			if sm.contents[i] == chunk {
+				continue
+			}
+			if _, err := sm.sender.ChannelMessageEdit(sm.channelID, sm.messageIDs[i], chunk); err != nil {
+				return fmt.Errorf("failed to edit message %d: %w", i+1, err)
+			}
+			sm.contents[i] = chunk
+			continue
+		}
+
+		msg, err := sm.sender.ChannelMessageSend(sm.channelID, chunk)
+		if err != nil {
+			return fmt.Errorf("failed to send message %d: %w", i+1, err)
+		}
+		sm.messageIDs = append(sm.messageIDs, msg.ID)
+		sm.contents = append(sm.contents, chunk)
+	}
+
+	// Remove follow-up messages that are no longer needed because the text got shorter
+	for len(sm.messageIDs) > len(chunks) {
+		last := len(sm.messageIDs) - 1
+		if err := sm.sender.ChannelMessageDelete(sm.channelID, sm.messageIDs[last]); err != nil {
+			return fmt.Errorf("failed to delete message %d: %w", last+1, err)
+		}
+		sm.messageIDs = sm.messageIDs[:last]
+		sm.contents = sm.contents[:last]
+	}
+	return nil
+}
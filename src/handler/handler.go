@@ -0,0 +1,280 @@
+package handler
+
+import (
+	"context"
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/discordbot"
+	"discord-gemini-bot/src/logging"
+	"discord-gemini-bot/src/metrics"
+	"discord-gemini-bot/src/ratelimit"
+	"discord-gemini-bot/src/shutdown"
+	"discord-gemini-bot/src/tracing"
+	"discord-gemini-bot/src/types"
+	"discord-gemini-bot/src/utils"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Default handler settings
+const (
+	DefaultTimeout     = 60 * time.Second
+	DefaultChunkDelay  = 500 * time.Millisecond
+	DefaultPlaceholder = "Thinking..."
+	ErrorReply         = "Sorry! Something went wrong while processing your request. Please try again later."
+	ShutdownReply      = "I'm restarting right now, so I can't answer this one. Please try again in a minute."
+)
+
+// Session is the part of a Discord session used to reply to messages
+type Session interface {
+	discordbot.MessageSender
+	ChannelTyping(channelID string, options ...discordgo.RequestOption) error
+}
+
+// Handler replies to Discord messages that mention the bot using the channel's agent
+type Handler struct {
+	agents       *agent.Registry
+	loader       types.AttachmentLoader
+	chunkDelay   time.Duration
+	editInterval time.Duration
+	placeholder  string
+	coordinator  *shutdown.Coordinator
+	limiter      *ratelimit.Limiter
+
+	// stream and timeout may be changed while messages are handled, on config reloads
+	stream  atomic.Bool
+	timeout atomic.Int64
+}
+
+// NewHandler creates a new message handler that runs turns on the registry's agents
+// and loads attachments with loader, which may be nil
+func NewHandler(agents *agent.Registry, loader types.AttachmentLoader) *Handler {
+	h := &Handler{
+		agents:       agents,
+		loader:       loader,
+		chunkDelay:   DefaultChunkDelay,
+		editInterval: discordbot.DefaultEditInterval,
+		placeholder:  DefaultPlaceholder,
+	}
+	h.timeout.Store(int64(DefaultTimeout))
+	return h
+}
+
+// SetStreaming sets whether replies are streamed by editing a placeholder message.
+// It is safe to call while messages are handled.
+func (h *Handler) SetStreaming(enabled bool) {
+	h.stream.Store(enabled)
+}
+
+// SetTimeout sets how long a single turn may take. It is safe to call while messages are handled.
+func (h *Handler) SetTimeout(timeout time.Duration) {
+	h.timeout.Store(int64(timeout))
+}
+
+// SetChunkDelay sets the delay between the messages of a reply split at the length limit
+func (h *Handler) SetChunkDelay(delay time.Duration) {
+	h.chunkDelay = delay
+}
+
+// SetEditInterval sets the minimum time between edits of a streamed reply
+func (h *Handler) SetEditInterval(interval time.Duration) {
+	h.editInterval = interval
+}
+
+// SetCoordinator registers turns with coordinator, so shutdown waits for them and
+// new messages are declined once it has started
+func (h *Handler) SetCoordinator(coordinator *shutdown.Coordinator) {
+	h.coordinator = coordinator
+}
+
+// Begin registers a turn with the shutdown coordinator, returning a context that is
+// cancelled if the turn is still running at the shutdown deadline and a function to
+// call when the turn is done. It returns shutdown.ErrShuttingDown once shutdown has
+// started.
+func (h *Handler) Begin(ctx context.Context, name string) (context.Context, func(), error) {
+	if h.coordinator == nil {
+		return ctx, func() {}, nil
+	}
+	return h.coordinator.Begin(ctx, name)
+}
+
+// SetLimiter checks new messages against the limiter's rate limits and budgets and
+// counts the model usage of turns against them
+func (h *Handler) SetLimiter(limiter *ratelimit.Limiter) {
+	h.limiter = limiter
+}
+
+// Allow checks a request from caller against the limiter, returning a context that
+// carries the caller so that the turn's usage and tool calls are counted against it.
+// It returns a *ratelimit.LimitError if a limit is exceeded.
+func (h *Handler) Allow(ctx context.Context, caller ratelimit.Caller) (context.Context, error) {
+	ctx = ratelimit.WithCaller(ctx, caller)
+	if h.limiter == nil {
+		return ctx, nil
+	}
+	return ctx, h.limiter.Allow(ctx)
+}
+
+// FailureReply returns the reply to send when the turn of ctx failed
+func FailureReply(ctx context.Context) string {
+	if errors.Is(context.Cause(ctx), shutdown.ErrDeadline) {
+		return ShutdownReply
+	}
+	return ErrorReply
+}
+
+// RunTurn adds msg to the agent of the guild's channel and returns its response,
+// calling onUpdate with the answer so far while it streams if onUpdate is not nil.
+// Turns in the same channel never interleave.
+func (h *Handler) RunTurn(ctx context.Context, guildID, channelID string, msg *types.Message, onUpdate func(answer string)) (string, error) {
+	start := time.Now()
+	var responseText string
+	err := h.agents.Do(ctx, guildID, channelID, func(currentAgent *agent.Agent) error {
+		// Add message to memory
+		currentAgent.AddMessage(ctx, msg)
+
+		// Get response from agent
+		// Count the usage of a failed turn too, as its completed steps were paid for
+		result, err := currentAgent.RunStream(ctx, onUpdate)
+		if result != nil && h.limiter != nil {
+			h.limiter.Record(ctx, result.Usage)
+		}
+		if err != nil {
+			return err
+		}
+		responseText = currentAgent.Reply(result)
+		return nil
+	})
+	metrics.ObserveTurn(guildID, channelID, time.Since(start), err)
+	if err != nil {
+		tracing.Fail(trace.SpanFromContext(ctx), err)
+	}
+	return responseText, err
+}
+
+// StartRequest starts the root span of a request from a user in a guild's channel,
+// returning a context that carries the span and the request's log fields
+func StartRequest(name, guildID, channelID, userID string) (context.Context, trace.Span) {
+	ctx := logging.WithRequest(context.Background(), guildID, channelID, userID)
+	return tracing.Start(ctx, name,
+		tracing.RequestID.String(logging.RequestID(ctx)),
+		tracing.GuildID.String(guildID),
+		tracing.ChannelID.String(channelID),
+		tracing.UserID.String(userID),
+	)
+}
+
+// HandleMessage replies to m if it mentions the bot with the given user ID
+func (h *Handler) HandleMessage(s Session, botUserID string, m *discordgo.MessageCreate) {
+	// Ignore messages sent by the bot itself
+	if m.Author.ID == botUserID {
+		return
+	}
+
+	// Check if the bot is mentioned
+	isBotMentioned := false
+	for _, mention := range m.Mentions {
+		if mention.ID == botUserID {
+			isBotMentioned = true
+			break
+		}
+	}
+
+	if !isBotMentioned {
+		return
+	}
+
+	ctx, span := StartRequest("Discord.Message", m.GuildID, m.ChannelID, m.Author.ID)
+	defer span.End()
+	slog.InfoContext(ctx, "Received message", "username", m.Author.Username, logging.Content("content", m.Content))
+
+	ctx, done, err := h.Begin(ctx, "message in channel "+m.ChannelID)
+	if err != nil {
+		slog.InfoContext(ctx, "Declining message during shutdown")
+		s.ChannelMessageSend(m.ChannelID, ShutdownReply)
+		return
+	}
+	defer done()
+
+	ctx, err = h.Allow(ctx, ratelimit.Caller{GuildID: m.GuildID, ChannelID: m.ChannelID, UserID: m.Author.ID})
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, ratelimit.Reply(err))
+		return
+	}
+
+	// Start typing indicator
+	err = s.ChannelTyping(m.ChannelID)
+	if err != nil {
+		slog.WarnContext(ctx, "Error starting typing indicator", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(h.timeout.Load()))
+	defer cancel()
+
+	// Convert Discord message to internal Message type
+	msg := types.DiscordMessageToMessage(ctx, botUserID, m, h.loader)
+
+	if h.stream.Load() {
+		h.streamResponse(ctx, s, m.GuildID, m.ChannelID, msg)
+		return
+	}
+
+	responseText, err := h.RunTurn(ctx, m.GuildID, m.ChannelID, msg, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting response from agent", "error", err)
+		s.ChannelMessageSend(m.ChannelID, FailureReply(ctx))
+		return
+	}
+
+	h.sendChunks(ctx, s, m.ChannelID, responseText)
+	slog.InfoContext(ctx, "Sent response")
+}
+
+// sendChunks sends text as one or more messages within Discord's message length limit
+func (h *Handler) sendChunks(ctx context.Context, s Session, channelID, text string) {
+	_, span := tracing.Start(ctx, "Discord.SendReply")
+	defer span.End()
+
+	chunks := utils.SplitLongText(text, discordbot.MaxMessageLength)
+	for i, chunk := range chunks {
+		_, err := s.ChannelMessageSend(channelID, chunk)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error sending message chunk", "chunk", i+1, "error", err)
+			tracing.Fail(span, err)
+			break
+		}
+		// Add small delay between messages to avoid rate limits
+		if i < len(chunks)-1 {
+			time.Sleep(h.chunkDelay)
+		}
+	}
+}
+
+// streamResponse posts a placeholder reply and edits it as the agent's answer streams in
+func (h *Handler) streamResponse(ctx context.Context, s Session, guildID, channelID string, msg *types.Message) {
+	reply, err := discordbot.NewStreamingMessage(s, channelID, h.placeholder, h.editInterval)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error starting streaming response", "error", err)
+		return
+	}
+
+	responseText, err := h.RunTurn(ctx, guildID, channelID, msg, reply.Update)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting response from agent", "error", err)
+		responseText = FailureReply(ctx)
+	}
+
+	_, span := tracing.Start(ctx, "Discord.SendReply")
+	err = reply.Finish(responseText)
+	tracing.End(span, err)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error sending message", "error", err)
+		return
+	}
+
+	slog.InfoContext(ctx, "Sent response")
+}
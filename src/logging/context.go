@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"slices"
+)
+
+// fieldsKey is the context key of the request's log fields
+type fieldsKey struct{}
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// WithRequest returns a context whose log records carry a new request ID and the
+// guild, channel and user of the request. Empty IDs are left out.
+func WithRequest(ctx context.Context, guildID, channelID, userID string) context.Context {
+	requestID := newRequestID()
+	ctx = context.WithValue(ctx, requestIDKey{}, requestID)
+
+	attrs := []slog.Attr{slog.String("request_id", requestID)}
+	for _, field := range []struct{ key, value string }{{"guild", guildID}, {"channel", channelID}, {"user", userID}} {
+		if field.value != "" {
+			attrs = append(attrs, slog.String(field.key, field.value))
+		}
+	}
+	return With(ctx, attrs...)
+}
+
+// With returns a context whose log records carry attrs after the fields of ctx
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, append(slices.Clip(fields(ctx)), attrs...))
+}
+
+// RequestID returns the ID of the request of ctx, or "" outside a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// fields returns the log fields of ctx
+func fields(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(fieldsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
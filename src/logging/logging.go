@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Log formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Options configures the handler returned by NewHandler
+type Options struct {
+	// Level is the minimum level logged
+	Level slog.Leveler
+	// Format is FormatJSON or FormatText
+	Format string
+	// RedactMessages masks message bodies logged with Content
+	RedactMessages bool
+	// Secrets are values masked wherever they are logged, such as tokens and API keys
+	Secrets []string
+}
+
+// Handler is a slog.Handler that adds the fields of a request's context to its
+// records and masks secrets and, optionally, message bodies
+type Handler struct {
+	next           slog.Handler
+	redactMessages bool
+}
+
+// NewHandler creates a handler writing records to w in the format of opts
+func NewHandler(w io.Writer, opts Options) *Handler {
+	redactor := newRedactor(opts.Secrets)
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level, ReplaceAttr: redactor.replaceAttr}
+
+	var next slog.Handler
+	if opts.Format == FormatText {
+		next = slog.NewTextHandler(w, handlerOpts)
+	} else {
+		next = slog.NewJSONHandler(w, handlerOpts)
+	}
+	return &Handler{next: next, redactMessages: opts.RedactMessages}
+}
+
+// Enabled reports whether records of the level are logged
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle logs r with the fields of ctx
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	record := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	record.AddAttrs(fields(ctx)...)
+	r.Attrs(func(a slog.Attr) bool {
+		record.AddAttrs(h.redactContent(a))
+		return true
+	})
+	return h.next.Handle(ctx, record)
+}
+
+// WithAttrs returns a handler that adds attrs to every record
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redactContent(a)
+	}
+	return &Handler{next: h.next.WithAttrs(redacted), redactMessages: h.redactMessages}
+}
+
+// WithGroup returns a handler that logs the attributes of records in the named group
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), redactMessages: h.redactMessages}
+}
+
+// content is a message body, logged as is unless message bodies are redacted
+type content string
+
+// LogValue returns the message body
+func (c content) LogValue() slog.Value {
+	return slog.StringValue(string(c))
+}
+
+// Content returns an attribute holding a message body, such as a user's message, a
+// model's output or a fetched page, which is masked when message redaction is enabled
+func Content(key, text string) slog.Attr {
+	return slog.Any(key, content(text))
+}
+
+// redactContent masks the message bodies in a when message redaction is enabled
+func (h *Handler) redactContent(a slog.Attr) slog.Attr {
+	if !h.redactMessages {
+		return a
+	}
+	switch a.Value.Kind() {
+	case slog.KindLogValuer:
+		if text, ok := a.Value.Any().(content); ok {
+			return slog.String(a.Key, fmt.Sprintf("[REDACTED %d bytes]", len(text)))
+		}
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]any, len(group))
+		for i, member := range group {
+			redacted[i] = h.redactContent(member)
+		}
+		return slog.Group(a.Key, redacted...)
+	}
+	return a
+}
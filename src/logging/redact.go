@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces masked values
+const Redacted = "[REDACTED]"
+
+// minSecretLength is the length below which configured secrets aren't masked, as
+// short values would mask unrelated text
+const minSecretLength = 8
+
+// sensitiveKeys are the attribute keys whose values are always masked
+var sensitiveKeys = map[string]bool{
+	"token":         true,
+	"api_key":       true,
+	"apikey":        true,
+	"password":      true,
+	"secret":        true,
+	"authorization": true,
+}
+
+// secretPatterns match credentials that weren't configured, such as keys in request URLs
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// Google API keys
+	{regexp.MustCompile(`AIza[0-9A-Za-z_\-]{35}`), Redacted},
+	// OpenAI-style API keys
+	{regexp.MustCompile(`sk-[0-9A-Za-z_\-]{20,}`), Redacted},
+	// Discord bot tokens
+	{regexp.MustCompile(`[MNO][0-9A-Za-z_\-]{23,27}\.[0-9A-Za-z_\-]{6}\.[0-9A-Za-z_\-]{27,40}`), Redacted},
+	// Credentials in URL query strings
+	{regexp.MustCompile(`([?&](?:key|api_key|apikey|token|access_token)=)[^&\s"]+`), "${1}" + Redacted},
+	// Authorization header values
+	{regexp.MustCompile(`((?:Bearer|Bot) )[0-9A-Za-z_\-.]{20,}`), "${1}" + Redacted},
+}
+
+// redactor masks secrets in log attributes
+type redactor struct {
+	secrets []string
+}
+
+// newRedactor creates a redactor masking secrets, ignoring empty and short ones
+func newRedactor(secrets []string) *redactor {
+	r := &redactor{}
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+	return r
+}
+
+// replaceAttr masks the secrets in a, and the whole value of sensitive keys. It is a
+// slog.HandlerOptions.ReplaceAttr function, so it also sees the record's message.
+func (r *redactor) replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, r.redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, r.redact(err.Error()))
+		}
+	}
+	return a
+}
+
+// redact masks the secrets in s
+func (r *redactor) redact(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	for _, p := range secretPatterns {
+		s = p.pattern.ReplaceAllString(s, p.replacement)
+	}
+	return s
+}
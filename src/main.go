@@ -3,158 +3,342 @@ package main
 import (
 	"context"
 	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/attachments"
+	"discord-gemini-bot/src/config"
 	"discord-gemini-bot/src/discordbot"
+	"discord-gemini-bot/src/handler"
+	"discord-gemini-bot/src/logging"
+	"discord-gemini-bot/src/memory"
+	"discord-gemini-bot/src/metrics"
 	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/ratelimit"
+	"discord-gemini-bot/src/shutdown"
 	"discord-gemini-bot/src/tools"
+	"discord-gemini-bot/src/tracing"
 	"discord-gemini-bot/src/types"
-	"discord-gemini-bot/src/utils"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/joho/godotenv"
 )
 
-// Configuration constants
-const (
-	MEMORY_WINDOW_SIZE         = 20
-	DISCORD_MAX_MESSAGE_LENGTH = 2000
-)
-
 // Global variables
 var (
-	discordBotToken     string
-	geminiAPIKey        string
-	model               models.LLMModel
-	toolList            []tools.Tool
-	channelAgents       map[string]*agent.Agent
-	supportedImageTypes = []string{"image/png", "image/jpeg", "image/webp", "image/gif"}
+	// botConfig is the configuration loaded at startup, for settings that need a restart
+	botConfig *config.Config
+	// configWatcher holds the current configuration, which is reloaded while the bot runs
+	configWatcher      *config.Watcher
+	model              models.LLMModel
+	geminiModel        *models.Gemini
+	attachmentIngestor *attachments.Ingestor
+	memoryStore        types.MemoryStore
+	tokenCounter       types.TokenCounter
+	channelAgents      *agent.Registry
+	messages           *handler.Handler
+	limiter            *ratelimit.Limiter
+	// coordinator drains running turns and releases resources on shutdown
+	coordinator = shutdown.NewCoordinator()
+	// logLevel is the minimum level logged, updated when the configuration is reloaded
+	logLevel = new(slog.LevelVar)
 )
 
 func init() {
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
-		log.Println("Warning: .env file not found")
+		slog.Warn(".env file not found")
 	}
 
-	// Get environment variables
-	discordBotToken = os.Getenv("DISCORD_BOT_TOKEN")
-	geminiAPIKey = os.Getenv("GEMINI_API_KEY")
+	// Load the config file, if any, with environment variables overriding its values
+	configPath := config.Path()
+	var err error
+	botConfig, err = config.Load(configPath)
+	if err != nil {
+		fatal("Failed to load configuration", err)
+	}
+	setupLogging(botConfig)
+	configWatcher = config.NewWatcher(configPath, botConfig)
 
-	if discordBotToken == "" {
-		log.Fatal("DISCORD_BOT_TOKEN environment variable is required")
+	// Initialize the model and its fallbacks
+	model, err = newModelChain(botConfig.Model)
+	if err != nil {
+		fatal("Failed to initialize model", err)
 	}
+	slog.Info("Using model", "model", model.Name())
 
-	if geminiAPIKey == "" {
-		log.Fatal("GEMINI_API_KEY environment variable is required")
+	// Initialize attachment ingestion, uploading large images through the Gemini file API
+	attachmentIngestor = attachments.NewIngestor(&http.Client{Timeout: botConfig.Attachments.DownloadTimeout}, botConfig.Attachments.ImageTypes)
+	if geminiModel != nil {
+		attachmentIngestor.SetUploader(geminiModel)
 	}
 
-	// Initialize model
-	var err error
-	model, err = models.NewGemini(geminiAPIKey, "gemini-2.0-flash-exp")
+	// Initialize persistent memory, if configured
+	memoryStore, err = memory.NewStore(botConfig.Memory.Store, botConfig.Memory.StorePath)
 	if err != nil {
-		log.Fatalf("Failed to initialize Gemini model: %v", err)
+		fatal("Failed to initialize memory store", err)
+	}
+
+	// Count memory tokens with Gemini or a local estimate
+	tokenCounter = types.NewEstimateTokenCounter()
+	if geminiModel != nil && botConfig.Memory.TokenCounter == "gemini" {
+		tokenCounter = geminiModel
 	}
 
-	// Initialize tools
-	toolList = []tools.Tool{
-		tools.NewGoogleSearchTool(),
-		tools.NewURLFetchTool(),
+	// Initialize rate limits and quotas, restoring today's counters
+	limiter, err = ratelimit.NewLimiter(botConfig.Limits.Limits, botConfig.Limits.StatePath)
+	if err != nil {
+		fatal("Failed to initialize rate limits", err)
 	}
 
-	// Initialize channel agents map
-	channelAgents = make(map[string]*agent.Agent)
+	// Initialize channel agents registry, updating existing agents after config reloads
+	channelAgents = agent.NewRegistry(newChannelAgent, botConfig.Agent.IdleTTL)
+	channelAgents.SetConfigurer(func(guildID, channelID string, a *agent.Agent) {
+		a.Reconfigure(channelSettings(configWatcher.Config(), guildID, channelID))
+	})
+	metrics.RegisterActiveAgents(channelAgents.Len)
+
+	// Initialize the message handler
+	messages = handler.NewHandler(channelAgents, attachmentIngestor)
+	messages.SetCoordinator(coordinator)
+	messages.SetLimiter(limiter)
+	applyConfig(botConfig)
+	configWatcher.OnReload(applyConfig)
 }
 
-func main() {
-	bot, err := discordbot.NewBot(discordBotToken, messageHandler)
-	if err != nil {
-		log.Fatalf("Failed to create Discord bot: %v", err)
+// applyConfig applies the settings that can change while the bot runs. Agents pick
+// up their new settings before their next turn.
+func applyConfig(cfg *config.Config) {
+	logLevel.Set(cfg.Logging.Level)
+	messages.SetStreaming(cfg.Discord.StreamResponses)
+	messages.SetTimeout(cfg.Discord.TurnTimeout)
+	limiter.SetLimits(cfg.Limits.Limits)
+	if configurable, ok := model.(models.GenerationConfigurable); ok {
+		configurable.SetGenerateOptions(cfg.Model.Generation)
 	}
-	if err := bot.Run(); err != nil {
-		log.Fatalf("Bot error: %v", err)
+	channelAgents.Reconfigure()
+}
+
+// setupLogging logs to stderr in the configured format, masking the configured secrets
+func setupLogging(cfg *config.Config) {
+	logLevel.Set(cfg.Logging.Level)
+	slog.SetDefault(slog.New(logging.NewHandler(os.Stderr, logging.Options{
+		Level:          logLevel,
+		Format:         cfg.Logging.Format,
+		RedactMessages: cfg.Logging.RedactMessages,
+		Secrets:        []string{cfg.Discord.Token, cfg.Model.APIKey, cfg.Tools.GoogleSearch.APIKey},
+	})))
+}
+
+// fatal logs msg with err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// newModelChain creates the configured model followed by its fallbacks, retrying each
+// on temporary failures, and remembers the first Gemini model in the chain for file
+// uploads and token counting
+func newModelChain(cfg config.ModelConfig) (models.LLMModel, error) {
+	policy := models.DefaultRetryPolicy()
+	policy.MaxAttempts = cfg.MaxAttempts
+
+	modelConfig := models.Config{
+		Provider:   cfg.Provider,
+		APIKey:     cfg.APIKey,
+		BaseURL:    cfg.BaseURL,
+		Generation: cfg.Generation,
 	}
-	if geminiModel, ok := model.(*models.Gemini); ok {
-		geminiModel.Close()
+
+	names := append([]string{cfg.Name}, cfg.Fallbacks...)
+	chain := make([]models.LLMModel, 0, len(names))
+	for _, name := range names {
+		modelConfig.Name = name
+		m, err := models.NewModel(modelConfig)
+		if err != nil {
+			return nil, err
+		}
+		if g, ok := m.(*models.Gemini); ok && geminiModel == nil {
+			geminiModel = g
+		}
+		chain = append(chain, m)
 	}
+
+	return models.NewFallbackModel(policy, chain...)
 }
 
-// messageHandler handles Discord messages and is passed to the bot
-func messageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
-	// Ignore messages sent by the bot itself
-	if m.Author.ID == s.State.User.ID {
-		return
+// newChannelAgent creates the agent for a channel, restoring its persisted memory and
+// applying the current settings of the channel and its guild
+func newChannelAgent(guildID, channelID string) (*agent.Agent, error) {
+	windowSize := botConfig.Memory.WindowSize
+	channelMemory := types.NewConversationMemory(windowSize)
+	if memoryStore != nil {
+		var err error
+		channelMemory, err = types.NewPersistentConversationMemory(windowSize, memoryStore, channelID)
+		if err != nil {
+			return nil, fmt.Errorf("error restoring memory for channel %s: %w", channelID, err)
+		}
+	}
+	if botConfig.Memory.TokenBudget > 0 {
+		channelMemory.SetTokenBudget(botConfig.Memory.TokenBudget, tokenCounter)
+	}
+	if botConfig.Memory.Summarize {
+		summarizer := memory.NewLLMSummarizer(model)
+		summarizer.SetLimiter(limiter)
+		channelMemory.SetSummarizer(summarizer)
 	}
 
-	// Check if the bot is mentioned
-	isBotMentioned := false
-	for _, mention := range m.Mentions {
-		if mention.ID == s.State.User.ID {
-			isBotMentioned = true
-			break
+	channelTools, opts := channelSettings(configWatcher.Config(), guildID, channelID)
+	return agent.NewAgentWithOptions(model, channelMemory, channelTools, opts), nil
+}
+
+// channelSettings returns the tools and agent options of a channel under cfg, with
+// the persona, tools and generation settings of the channel and its guild
+func channelSettings(cfg *config.Config, guildID, channelID string) ([]tools.Tool, agent.Options) {
+	settings := cfg.Resolve(guildID, channelID)
+
+	opts := agent.DefaultOptions()
+	opts.MaxSteps = cfg.Agent.MaxSteps
+	opts.NativeFunctionCalling = cfg.Agent.NativeFunctionCalling
+	opts.Debug = cfg.Agent.DebugTranscript
+	opts.Persona = settings.Persona
+	opts.Generation = settings.Generation
+
+	var channelTools []tools.Tool
+	for _, name := range settings.Tools {
+		switch name {
+		case config.ToolGoogleSearch:
+			search := tools.NewGoogleSearchTool()
+			search.SetCredentials(cfg.Tools.GoogleSearch.APIKey, cfg.Tools.GoogleSearch.CSEID)
+			channelTools = append(channelTools, ratelimit.LimitTool(search, limiter))
+		case config.ToolURLFetch:
+			channelTools = append(channelTools, ratelimit.LimitTool(tools.NewURLFetchTool(), limiter))
 		}
 	}
+	return channelTools, opts
+}
 
-	if !isBotMentioned {
-		return
+func main() {
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    botConfig.Tracing.Exporter,
+		Endpoint:    botConfig.Tracing.Endpoint,
+		Insecure:    botConfig.Tracing.Insecure,
+		SampleRatio: botConfig.Tracing.SampleRatio,
+	})
+	if err != nil {
+		fatal("Failed to initialize tracing", err)
 	}
 
-	log.Printf("Received message from %s in channel %s: %s", m.Author.Username, m.ChannelID, m.Content)
+	evictionCtx, stopEviction := context.WithCancel(context.Background())
+	defer stopEviction()
+	go channelAgents.RunEviction(evictionCtx, botConfig.Agent.EvictionInterval)
+	go limiter.RunFlusher(evictionCtx, ratelimit.DefaultFlushInterval)
+	if memoryStore != nil {
+		go channelAgents.RunFlusher(evictionCtx, agent.DefaultFlushInterval)
+	}
 
-	// Convert Discord message to internal Message type
-	msg := types.DiscordMessageToMessage(s, m, supportedImageTypes)
+	// Reload the configuration on SIGHUP, and when its files change if watching is enabled
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			configWatcher.Reload()
+		}
+	}()
+	if len(botConfig.Files()) > 0 && botConfig.Reload.Watch {
+		go configWatcher.Watch(evictionCtx, botConfig.Reload.Interval)
+	}
 
-	// Start typing indicator
-	err := s.ChannelTyping(m.ChannelID)
+	bot, err := discordbot.NewBot(botConfig.Discord.Token, messageHandler)
 	if err != nil {
-		log.Printf("Error starting typing indicator: %v", err)
+		fatal("Failed to create Discord bot", err)
 	}
+	bot.SetCommands(newCommandRouter(), botConfig.Discord.CommandGuildID)
 
-	// Get or create agent for this channel
-	channelID := m.ChannelID
-	currentAgent, exists := channelAgents[channelID]
-	if !exists {
-		log.Printf("Creating new agent for channel %s", channelID)
-		memory := types.NewConversationMemory(MEMORY_WINDOW_SIZE)
-		currentAgent = agent.NewAgent(model, memory, toolList)
-		channelAgents[channelID] = currentAgent
+	var monitoring *http.Server
+	if botConfig.Monitoring.Address != "" {
+		monitoring = startMonitoring(botConfig.Monitoring.Address, func() error {
+			if coordinator.Stopping() {
+				return errors.New("shutting down")
+			}
+			return bot.Ready()
+		})
 	}
 
-	// Add message to memory
-	currentAgent.AddMessage(msg)
+	// Release resources in order once the running turns have stopped
+	coordinator.OnShutdown("Discord session", bot.Close)
+	if monitoring != nil {
+		coordinator.OnShutdown("monitoring server", monitoring.Close)
+	}
+	coordinator.OnShutdown("channel agents", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return channelAgents.Close(ctx)
+	})
+	coordinator.OnShutdown("rate limit counters", limiter.Close)
+	if memoryStore != nil {
+		coordinator.OnShutdown("memory store", memoryStore.Close)
+	}
+	if closer, ok := model.(io.Closer); ok {
+		coordinator.OnShutdown("model", closer.Close)
+	}
+	coordinator.OnShutdown("tracing", func() error {
+		// Export the spans of the last requests
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return shutdownTracing(ctx)
+	})
 
-	// Get response from agent
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := bot.Run(ctx); err != nil {
+		fatal("Bot error", err)
+	}
+	stop()
 
-	responseText, err := currentAgent.GetResponse(ctx)
-	if err != nil {
-		log.Printf("Error getting response from agent: %v", err)
-		s.ChannelMessageSend(m.ChannelID, "Sorry! Something went wrong while processing your request. Please try again later.")
-		return
-	}
-
-	// Handle Discord's message length limit
-	if len(responseText) > DISCORD_MAX_MESSAGE_LENGTH {
-		chunks := utils.SplitLongText(responseText, DISCORD_MAX_MESSAGE_LENGTH)
-		for i, chunk := range chunks {
-			_, err := s.ChannelMessageSend(m.ChannelID, chunk)
-			if err != nil {
-				log.Printf("Error sending message chunk %d: %v", i+1, err)
-				break
-			}
-			// Add small delay between messages to avoid rate limits
-			if i < len(chunks)-1 {
-				time.Sleep(500 * time.Millisecond)
-			}
-		}
-	} else {
-		_, err := s.ChannelMessageSend(m.ChannelID, responseText)
-		if err != nil {
-			log.Printf("Error sending message: %v", err)
-		}
+	timeout := configWatcher.Config().Discord.ShutdownTimeout
+	slog.Info("Shutting down, waiting for running turns to finish", "timeout", timeout)
+	logShutdown(coordinator.Shutdown(timeout))
+}
+
+// logShutdown logs what happened to the running turns and resources during shutdown
+func logShutdown(report *shutdown.Report) {
+	for _, name := range report.Cancelled {
+		slog.Warn("Cancelled turn at the shutdown deadline", "turn", name)
+	}
+	for _, name := range report.Abandoned {
+		slog.Warn("Turn didn't stop after being cancelled", "turn", name)
 	}
+	for _, err := range report.Errors {
+		slog.Error("Error during shutdown", "error", err)
+	}
+	slog.Info("Shutdown complete", "finished", report.Finished, "cancelled", len(report.Cancelled))
+}
 
-	log.Printf("Sent response to channel %s", m.ChannelID)
+// startMonitoring serves the metrics and health endpoints on address in the background,
+// reporting the bot ready when ready returns nil
+func startMonitoring(address string, ready func() error) *http.Server {
+	server := &http.Server{
+		Addr:              address,
+		Handler:           metrics.NewHandler(ready),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		slog.Info("Serving metrics and health checks", "address", address)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Monitoring server error", "error", err)
+		}
+	}()
+	return server
+}
+
+// messageHandler replies to messages that mention the bot
+func messageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	messages.HandleMessage(s, s.State.User.ID, m)
 }
@@ -0,0 +1,110 @@
+package memory
+
+import (
+	"discord-gemini-bot/src/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// JSONFileStore persists each conversation as a JSON file in a directory
+type JSONFileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewJSONFileStore creates a JSON file store in dir, creating the directory if needed
+func NewJSONFileStore(dir string) (*JSONFileStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("memory directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create memory directory: %w", err)
+	}
+	return &JSONFileStore{dir: dir}, nil
+}
+
+// Load returns the snapshot stored under key, or nil if there is none
+func (s *JSONFileStore) Load(key string) (*types.MemorySnapshot, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read memory file: %w", err)
+	}
+
+	var snapshot types.MemorySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse memory file for %s: %w", key, err)
+	}
+	return &snapshot, nil
+}
+
+// Save replaces the snapshot stored under key, writing atomically via a temporary file
+func (s *JSONFileStore) Save(key string, snapshot *types.MemorySnapshot) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode memory: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := os.CreateTemp(s.dir, key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create memory file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write memory file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write memory file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		return fmt.Errorf("failed to replace memory file: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the snapshot stored under key
+func (s *JSONFileStore) Delete(key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete memory file: %w", err)
+	}
+	return nil
+}
+
+// Close releases the store's resources (no-op for this implementation)
+func (s *JSONFileStore) Close() error {
+	return nil
+}
+
+// path returns the file path for key
+func (s *JSONFileStore) path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
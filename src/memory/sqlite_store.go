@@ -0,0 +1,90 @@
+package memory
+
+import (
+	"database/sql"
+	"discord-gemini-bot/src/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore persists conversations as JSON snapshots in a SQLite database
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (or creates) the SQLite database at path
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("memory database path is required")
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open memory database: %w", err)
+	}
+	// SQLite allows a single writer; serialize access through one connection
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS conversation_memory (
+		key        TEXT PRIMARY KEY,
+		snapshot   TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create memory table: %w", err)
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// Load returns the snapshot stored under key, or nil if there is none
+func (s *SQLiteStore) Load(key string) (*types.MemorySnapshot, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT snapshot FROM conversation_memory WHERE key = ?`, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load memory for %s: %w", key, err)
+	}
+
+	var snapshot types.MemorySnapshot
+	if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse memory for %s: %w", key, err)
+	}
+	return &snapshot, nil
+}
+
+// Save replaces the snapshot stored under key
+func (s *SQLiteStore) Save(key string, snapshot *types.MemorySnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode memory: %w", err)
+	}
+
+	_, err = s.db.Exec(`INSERT INTO conversation_memory (key, snapshot, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET snapshot = excluded.snapshot, updated_at = excluded.updated_at`,
+		key, string(data), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to save memory for %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes the snapshot stored under key
+func (s *SQLiteStore) Delete(key string) error {
+	if _, err := s.db.Exec(`DELETE FROM conversation_memory WHERE key = ?`, key); err != nil {
+		return fmt.Errorf("failed to delete memory for %s: %w", key, err)
+	}
+	return nil
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,33 @@
+package memory
+
+import (
+	"discord-gemini-bot/src/types"
+	"fmt"
+	"regexp"
+)
+
+// keyRegex restricts memory keys to characters that are safe in file names
+var keyRegex = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// NewStore creates a memory store of the given kind ("json" or "sqlite") at path.
+// An empty kind returns a nil store, meaning memory is kept in process only.
+func NewStore(kind, path string) (types.MemoryStore, error) {
+	switch kind {
+	case "":
+		return nil, nil
+	case "json":
+		return NewJSONFileStore(path)
+	case "sqlite":
+		return NewSQLiteStore(path)
+	default:
+		return nil, fmt.Errorf("unknown memory store %q", kind)
+	}
+}
+
+// validateKey checks that a memory key can be stored safely
+func validateKey(key string) error {
+	if !keyRegex.MatchString(key) {
+		return fmt.Errorf("invalid memory key %q", key)
+	}
+	return nil
+}
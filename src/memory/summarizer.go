@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"context"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/prompts"
+	"discord-gemini-bot/src/ratelimit"
+	"discord-gemini-bot/src/tracing"
+	"discord-gemini-bot/src/types"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// maxSummaryContentLength truncates long contents such as fetched pages in the summary transcript
+const maxSummaryContentLength = 500
+
+// LLMSummarizer folds evicted messages into a running summary using a language model
+type LLMSummarizer struct {
+	model   models.LLMModel
+	limiter *ratelimit.Limiter
+}
+
+// NewLLMSummarizer creates a new summarizer backed by model
+func NewLLMSummarizer(model models.LLMModel) *LLMSummarizer {
+	return &LLMSummarizer{model: model}
+}
+
+// SetLimiter counts the usage of summaries against the daily budgets of the caller
+// of the context they are made with
+func (s *LLMSummarizer) SetLimiter(limiter *ratelimit.Limiter) {
+	s.limiter = limiter
+}
+
+// Summarize returns summary updated with the evicted messages
+func (s *LLMSummarizer) Summarize(ctx context.Context, summary string, evicted []*types.Message) (updated string, err error) {
+	ctx, span := tracing.Start(ctx, "Memory.Summarize")
+	defer func() { tracing.End(span, err) }()
+
+	if summary == "" {
+		summary = "(empty)"
+	}
+
+	prompt := fmt.Sprintf(prompts.GetSummaryPromptTemplate(), summary, formatTranscript(evicted))
+	request := []*types.Message{types.NewMessage("user", []types.MessageContent{{Type: "text", Content: prompt}})}
+	opts := &models.GenerateOptions{SystemPrompt: prompts.GetSummarySystemPrompt()}
+
+	response, err := s.model.GenerateWithHistoryAsync(ctx, request, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize conversation: %w", err)
+	}
+	span.SetAttributes(
+		tracing.InputTokens.Int(response.Usage.PromptTokens),
+		tracing.OutputTokens.Int(response.Usage.OutputTokens),
+	)
+	if s.limiter != nil {
+		s.limiter.Record(ctx, response.Usage)
+	}
+	if response.Blocked() {
+		return "", fmt.Errorf("summary was blocked (%s)", response)
+	}
+
+	updated = strings.TrimSpace(response.Text)
+	if updated == "" {
+		return "", fmt.Errorf("model returned an empty summary")
+	}
+	return updated, nil
+}
+
+// formatTranscript renders messages as one "role: content" line per content item
+func formatTranscript(messages []*types.Message) string {
+	var sb strings.Builder
+	for _, msg := range messages {
+		for _, c := range msg.Contents {
+			var line string
+			switch c.Type {
+			case "image", "file":
+				line = "[shared an image]"
+			case "function_call":
+				if c.FunctionCall == nil {
+					continue
+				}
+				args, _ := json.Marshal(c.FunctionCall.Args)
+				line = fmt.Sprintf("[called %s with %s]", c.FunctionCall.Name, args)
+			case "function_response":
+				if c.FunctionResponse == nil {
+					continue
+				}
+				output, _ := json.Marshal(c.FunctionResponse.Response)
+				line = fmt.Sprintf("[%s returned %s]", c.FunctionResponse.Name, truncate(string(output)))
+			default:
+				line = truncate(c.Content)
+			}
+			fmt.Fprintf(&sb, "%s: %s\n", msg.Role, line)
+		}
+	}
+	return sb.String()
+}
+
+// truncate shortens text to maxSummaryContentLength runes
+func truncate(text string) string {
+	runes := []rune(text)
+	if len(runes) <= maxSummaryContentLength {
+		return text
+	}
+	return string(runes[:maxSummaryContentLength]) + "..."
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// namespace prefixes the name of every metric
+const namespace = "discord_bot"
+
+// Registry holds the bot's metrics, along with Go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// The bot's metrics
+var (
+	// MessagesHandled counts the turns run for messages and commands, by guild and channel
+	MessagesHandled = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_handled_total",
+		Help:      "Messages and commands answered by an agent, by guild and channel.",
+	}, []string{"guild", "channel"})
+
+	// TurnDuration measures how long agent turns take, by outcome
+	TurnDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "agent_turn_duration_seconds",
+		Help:      "Time taken by an agent to answer a message, by outcome (ok or error).",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 10),
+	}, []string{"outcome"})
+
+	// ModelRequests counts requests to each model, including retries
+	ModelRequests = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "model_requests_total",
+		Help:      "Requests sent to a model, including retries and fallbacks, by model and operation.",
+	}, []string{"model", "operation"})
+
+	// ModelErrors counts failed requests to each model
+	ModelErrors = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "model_errors_total",
+		Help:      "Failed model requests, by model and operation.",
+	}, []string{"model", "operation"})
+
+	// ModelTokens counts the tokens used by each model, by type
+	ModelTokens = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "model_tokens_total",
+		Help:      "Tokens used by successful model requests, by model and type (prompt, output or cached).",
+	}, []string{"model", "type"})
+
+	// ToolInvocations counts tool calls by tool and outcome
+	ToolInvocations = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tool_invocations_total",
+		Help:      "Tool calls made by agents, by tool and outcome (ok, error or not_found).",
+	}, []string{"tool", "outcome"})
+
+	// RateLimited counts requests and tool calls refused by a limit, by scope and kind
+	RateLimited = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Messages and tool calls refused by a rate limit, daily budget or tool quota, by scope and limit.",
+	}, []string{"scope", "limit"})
+)
+
+// Outcomes recorded by the metrics
+const (
+	OutcomeOK       = "ok"
+	OutcomeError    = "error"
+	OutcomeNotFound = "not_found"
+)
+
+// ObserveTurn records a turn answered in a guild's channel. Direct messages have an
+// empty guild ID, recorded as "dm".
+func ObserveTurn(guildID, channelID string, duration time.Duration, err error) {
+	if guildID == "" {
+		guildID = "dm"
+	}
+	MessagesHandled.WithLabelValues(guildID, channelID).Inc()
+	TurnDuration.WithLabelValues(outcome(err)).Observe(duration.Seconds())
+}
+
+// ObserveModelRequest records a request to a model, and the tokens it used if it succeeded
+func ObserveModelRequest(model, operation string, promptTokens, outputTokens, cachedTokens int, err error) {
+	ModelRequests.WithLabelValues(model, operation).Inc()
+	if err != nil {
+		ModelErrors.WithLabelValues(model, operation).Inc()
+		return
+	}
+	ModelTokens.WithLabelValues(model, "prompt").Add(float64(promptTokens))
+	ModelTokens.WithLabelValues(model, "output").Add(float64(outputTokens))
+	ModelTokens.WithLabelValues(model, "cached").Add(float64(cachedTokens))
+}
+
+// ObserveToolInvocation records a tool call with one of the Outcome constants
+func ObserveToolInvocation(tool, outcome string) {
+	ToolInvocations.WithLabelValues(tool, outcome).Inc()
+}
+
+// ObserveRateLimited records a request refused by a limit of a scope
+func ObserveRateLimited(scope, limit string) {
+	RateLimited.WithLabelValues(scope, limit).Inc()
+}
+
+// RegisterActiveAgents reports the number of active channel agents, as returned by count
+func RegisterActiveAgents(count func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_channel_agents",
+		Help:      "Channels with an agent in memory.",
+	}, func() float64 { return float64(count()) }))
+}
+
+// outcome returns the outcome label of an error
+func outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeOK
+}
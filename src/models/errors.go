@@ -0,0 +1,61 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"google.golang.org/genai"
+)
+
+// ErrEmptyResponse is returned when a model responds without any content
+var ErrEmptyResponse = errors.New("empty response")
+
+// StatusError is returned when a model server responds with an HTTP error status
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+// Error returns the status code and the server's message
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Message)
+}
+
+// IsRetryable reports whether a request that failed with err may succeed when it is
+// retried: rate limits, server errors, timeouts, network errors and empty responses
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, ErrEmptyResponse) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return isRetryableStatus(apiErr.Code)
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return isRetryableStatus(statusErr.StatusCode)
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isRetryableStatus reports whether an HTTP status code indicates a temporary failure
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Model providers supported by NewModel
+const (
+	ProviderGemini = "gemini"
+	ProviderOpenAI = "openai"
+	ProviderOllama = "ollama"
+)
+
+// Config selects and configures a model backend
+type Config struct {
+	// Provider is one of ProviderGemini (the default), ProviderOpenAI or ProviderOllama
+	Provider string
+	// Name is the model name. Gemini defaults to gemini-2.0-flash-exp; the other providers require it.
+	Name string
+	// APIKey authenticates with Gemini or an OpenAI-compatible server
+	APIKey string
+	// BaseURL is the address of an OpenAI-compatible or Ollama server
+	BaseURL string
+	// Generation overrides the model's default generation settings
+	Generation GenerateOptions
+}
+
+// NewModel creates the model backend selected by cfg
+func NewModel(cfg Config) (LLMModel, error) {
+	if err := cfg.Generation.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid generation settings: %w", err)
+	}
+
+	switch strings.ToLower(cfg.Provider) {
+	case "", ProviderGemini:
+		model, err := NewGemini(cfg.APIKey, cfg.Name)
+		if err != nil {
+			return nil, err
+		}
+		model.SetGenerateOptions(cfg.Generation)
+		return model, nil
+	case ProviderOpenAI:
+		model, err := NewOpenAI(cfg.BaseURL, cfg.APIKey, cfg.Name)
+		if err != nil {
+			return nil, err
+		}
+		model.SetGenerateOptions(cfg.Generation)
+		return model, nil
+	case ProviderOllama:
+		model, err := NewOllama(cfg.BaseURL, cfg.Name)
+		if err != nil {
+			return nil, err
+		}
+		model.SetGenerateOptions(cfg.Generation)
+		return model, nil
+	default:
+		return nil, fmt.Errorf("unknown model provider %q, expected %s, %s or %s", cfg.Provider, ProviderGemini, ProviderOpenAI, ProviderOllama)
+	}
+}
@@ -0,0 +1,273 @@
+package models
+
+import (
+	"context"
+	"discord-gemini-bot/src/metrics"
+	"discord-gemini-bot/src/tracing"
+	"discord-gemini-bot/src/types"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RetryPolicy configures how often and how long a failed request is retried
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts per model, including the first
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after every retry
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction of it, between 0 and 1
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns the default retry policy
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     8 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Backoff returns the delay before the given retry, counting from 1
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	delay := float64(p.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		delay *= p.Multiplier
+	}
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		delay *= 1 - p.Jitter + 2*p.Jitter*rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// FallbackModel is an LLMModel that retries requests failing with retryable errors
+// with exponential backoff, then falls through an ordered list of models until one
+// of them succeeds. It logs which model served each request.
+type FallbackModel struct {
+	models []LLMModel
+	policy RetryPolicy
+}
+
+// NewFallbackModel creates a model that tries the given models in order, retrying each according to policy
+func NewFallbackModel(policy RetryPolicy, models ...LLMModel) (*FallbackModel, error) {
+	if len(models) == 0 {
+		return nil, fmt.Errorf("at least one model is required")
+	}
+
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+
+	return &FallbackModel{
+		models: models,
+		policy: policy,
+	}, nil
+}
+
+// Models returns the models in the order they are tried
+func (f *FallbackModel) Models() []LLMModel {
+	return append([]LLMModel(nil), f.models...)
+}
+
+// Close closes the models that hold resources
+func (f *FallbackModel) Close() error {
+	var errs []error
+	for _, model := range f.models {
+		if closer, ok := model.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("error closing model %s: %w", model.Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Name returns the name of the primary model, followed by its fallbacks
+func (f *FallbackModel) Name() string {
+	if len(f.models) == 1 {
+		return f.models[0].Name()
+	}
+
+	fallbacks := make([]string, 0, len(f.models)-1)
+	for _, model := range f.models[1:] {
+		fallbacks = append(fallbacks, model.Name())
+	}
+	return fmt.Sprintf("%s (fallback: %s)", f.models[0].Name(), strings.Join(fallbacks, ", "))
+}
+
+// SetSystemPrompt sets the default system prompt of every model
+func (f *FallbackModel) SetSystemPrompt(systemPrompt string) {
+	for _, model := range f.models {
+		model.SetSystemPrompt(systemPrompt)
+	}
+}
+
+// SetGenerateOptions replaces the generation settings of every model that has them
+func (f *FallbackModel) SetGenerateOptions(opts GenerateOptions) {
+	for _, model := range f.models {
+		if configurable, ok := model.(GenerationConfigurable); ok {
+			configurable.SetGenerateOptions(opts)
+		}
+	}
+}
+
+// GenerateAsync generates text with the first model that succeeds
+func (f *FallbackModel) GenerateAsync(ctx context.Context, prompt string, images []map[string]interface{}) (string, error) {
+	response, err := f.try(ctx, f.models, "generate", func(ctx context.Context, model LLMModel) (*Response, error) {
+		text, err := model.GenerateAsync(ctx, prompt, images)
+		if err != nil {
+			return nil, err
+		}
+		return &Response{Text: text}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return response.Text, nil
+}
+
+// GenerateWithHistoryAsync generates text with conversation history with the first model that succeeds
+func (f *FallbackModel) GenerateWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *GenerateOptions) (*Response, error) {
+	return f.try(ctx, f.models, "generate with history", func(ctx context.Context, model LLMModel) (*Response, error) {
+		return model.GenerateWithHistoryAsync(ctx, messages, opts)
+	})
+}
+
+// StreamWithHistoryAsync streams text with conversation history from the first model that
+// succeeds. Once a chunk has been passed to onChunk, a failure is returned as is instead
+// of being retried, as the caller has already seen part of the response.
+func (f *FallbackModel) StreamWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *GenerateOptions, onChunk func(chunk string) error) (*Response, error) {
+	streamed := false
+	return f.try(ctx, f.models, "stream with history", func(ctx context.Context, model LLMModel) (*Response, error) {
+		response, err := model.StreamWithHistoryAsync(ctx, messages, opts, func(chunk string) error {
+			streamed = true
+			return onChunk(chunk)
+		})
+		if err != nil && streamed {
+			return nil, &permanentError{err}
+		}
+		return response, err
+	})
+}
+
+// SupportsFunctionCalling reports whether any model in the chain supports function calling
+func (f *FallbackModel) SupportsFunctionCalling() bool {
+	return len(f.functionModels()) > 0
+}
+
+// GenerateWithFunctionsAsync generates the next message with the first model that
+// supports function calling and succeeds
+func (f *FallbackModel) GenerateWithFunctionsAsync(ctx context.Context, messages []*types.Message, functions []*types.FunctionDeclaration, opts *GenerateOptions) (*Response, error) {
+	functionModels := f.functionModels()
+	if len(functionModels) == 0 {
+		return nil, fmt.Errorf("no model supports function calling")
+	}
+
+	return f.try(ctx, functionModels, "generate with functions", func(ctx context.Context, model LLMModel) (*Response, error) {
+		return model.(FunctionCallingModel).GenerateWithFunctionsAsync(ctx, messages, functions, opts)
+	})
+}
+
+// functionModels returns the models in the chain that support function calling
+func (f *FallbackModel) functionModels() []LLMModel {
+	var functionModels []LLMModel
+	for _, model := range f.models {
+		if SupportsFunctionCalling(model) {
+			functionModels = append(functionModels, model)
+		}
+	}
+	return functionModels
+}
+
+// permanentError marks an error that must not be retried or passed to the next model
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// try runs request against each of models in turn, retrying retryable errors, until one succeeds
+func (f *FallbackModel) try(ctx context.Context, models []LLMModel, operation string, request func(ctx context.Context, model LLMModel) (*Response, error)) (*Response, error) {
+	var lastErr error
+	for i, model := range models {
+		for attempt := 1; attempt <= f.policy.MaxAttempts; attempt++ {
+			if attempt > 1 {
+				delay := f.policy.Backoff(attempt - 1)
+				slog.WarnContext(ctx, "Retrying model request", "operation", operation, "model", model.Name(), "delay", delay, "attempt", attempt, "max_attempts", f.policy.MaxAttempts, "error", lastErr)
+				if err := sleep(ctx, delay); err != nil {
+					return nil, fmt.Errorf("%s cancelled while waiting to retry: %w", operation, lastErr)
+				}
+			}
+
+			attemptCtx, span := tracing.Start(ctx, "Model.Generate", tracing.Model.String(model.Name()), tracing.Operation.String(operation), tracing.Attempt.Int(attempt))
+			response, err := request(attemptCtx, model)
+			observeRequest(span, model, operation, response, err)
+			if err == nil {
+				slog.InfoContext(ctx, "Model served request", "operation", operation, "model", model.Name(), "attempt", attempt, "fallbacks", i, "response", response.String())
+				return response, nil
+			}
+			lastErr = err
+
+			if perm, ok := err.(*permanentError); ok {
+				return nil, perm.err
+			}
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			if !IsRetryable(err) {
+				break
+			}
+		}
+
+		if i < len(models)-1 {
+			slog.WarnContext(ctx, "Model failed, falling back", "operation", operation, "model", model.Name(), "fallback", models[i+1].Name(), "error", lastErr)
+		}
+	}
+
+	return nil, fmt.Errorf("all models failed to %s: %w", operation, lastErr)
+}
+
+// observeRequest records a request to model in the metrics and ends its span
+func observeRequest(span trace.Span, model LLMModel, operation string, response *Response, err error) {
+	var usage Usage
+	if response != nil {
+		usage = response.Usage
+		span.SetAttributes(
+			tracing.FinishReason.String(string(response.FinishReason)),
+			tracing.InputTokens.Int(usage.PromptTokens),
+			tracing.OutputTokens.Int(usage.OutputTokens),
+			tracing.CachedTokens.Int(usage.CachedTokens),
+		)
+	}
+	metrics.ObserveModelRequest(model.Name(), operation, usage.PromptTokens, usage.OutputTokens, usage.CachedTokens, err)
+	tracing.End(span, err)
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,10 +1,12 @@
 package models
 
 import (
+	"bytes"
 	"context"
 	"discord-gemini-bot/src/types"
 	"encoding/base64"
 	"fmt"
+	"strings"
 
 	"google.golang.org/genai"
 )
@@ -14,8 +16,7 @@ type Gemini struct {
 	client       *genai.Client
 	modelName    string
 	systemPrompt string
-	temperature  float32
-	maxTokens    int32
+	defaults     *generationDefaults
 }
 
 // NewGemini creates a new Gemini model instance
@@ -39,18 +40,28 @@ func NewGemini(apiKey, modelName string) (*Gemini, error) {
 	}
 
 	return &Gemini{
-		client:      client,
-		modelName:   modelName,
-		temperature: 1.0,
-		maxTokens:   8192,
+		client:    client,
+		modelName: modelName,
+		defaults:  newGenerationDefaults(),
 	}, nil
 }
 
-// SetSystemPrompt sets the system prompt for the model
+// Name returns the name of the underlying model
+func (g *Gemini) Name() string {
+	return g.modelName
+}
+
+// SetSystemPrompt sets the default system prompt for the model
 func (g *Gemini) SetSystemPrompt(systemPrompt string) {
 	g.systemPrompt = systemPrompt
 }
 
+// SetGenerateOptions replaces the model's generation settings with opts applied over
+// the built-in defaults. It is safe to call while requests are made.
+func (g *Gemini) SetGenerateOptions(opts GenerateOptions) {
+	g.defaults.set(opts)
+}
+
 // GenerateAsync generates text asynchronously based on the given prompt
 func (g *Gemini) GenerateAsync(ctx context.Context, prompt string, images []map[string]interface{}) (string, error) {
 	// Build content parts
@@ -81,28 +92,17 @@ func (g *Gemini) GenerateAsync(ctx context.Context, prompt string, images []map[
 		}
 	}
 
-	// Create generation config
-	config := &genai.GenerateContentConfig{
-		Temperature:     &g.temperature,
-		MaxOutputTokens: g.maxTokens,
-	}
-
-	// Add system instruction if available
-	if g.systemPrompt != "" {
-		config.SystemInstruction = genai.NewContentFromText(g.systemPrompt, genai.RoleUser)
-	}
-
-	resp, err := g.client.Models.GenerateContent(ctx, g.modelName, contents, config)
+	resp, err := g.client.Models.GenerateContent(ctx, g.modelName, contents, g.generationConfig(nil))
 	if err != nil {
 		return "", fmt.Errorf("failed to generate content: %w", err)
 	}
 
 	if len(resp.Candidates) == 0 {
-		return "", fmt.Errorf("no candidates returned")
+		return "", fmt.Errorf("no candidates returned: %w", ErrEmptyResponse)
 	}
 
 	if len(resp.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no content parts returned")
+		return "", fmt.Errorf("no content parts returned: %w", ErrEmptyResponse)
 	}
 
 	// Extract text from the response
@@ -116,51 +116,257 @@ func (g *Gemini) GenerateAsync(ctx context.Context, prompt string, images []map[
 	return result, nil
 }
 
-// GenerateWithHistoryAsync generates text asynchronously with conversation history
-func (g *Gemini) GenerateWithHistoryAsync(ctx context.Context, messages []*types.Message) (string, error) {
-	// Convert messages to genai.Content format using ToGenaiContent
-	contents := make([]*genai.Content, 0, len(messages))
-	for _, msg := range messages {
-		content, err := msg.ToGenaiContent()
+// GenerateWithHistoryAsync generates a response asynchronously with conversation history
+func (g *Gemini) GenerateWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *GenerateOptions) (*Response, error) {
+	contents, err := types.MessagesToGenaiContent(messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert message to genai.Content: %w", err)
+	}
+
+	resp, err := g.client.Models.GenerateContent(ctx, g.modelName, contents, g.generationConfig(opts))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate content with history: %w", err)
+	}
+
+	response := newGeminiResponse(resp)
+	if response.Blocked() {
+		return response, nil
+	}
+
+	if len(resp.Candidates) == 0 {
+		return nil, fmt.Errorf("no candidates returned: %w", ErrEmptyResponse)
+	}
+
+	if resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return nil, fmt.Errorf("no content parts returned (finish reason %s): %w", response.finishReason(), ErrEmptyResponse)
+	}
+
+	return response, nil
+}
+
+// StreamWithHistoryAsync generates a response with conversation history, calling onChunk
+// with each piece of text as it is streamed from the model
+func (g *Gemini) StreamWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *GenerateOptions, onChunk func(chunk string) error) (*Response, error) {
+	contents, err := types.MessagesToGenaiContent(messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert message to genai.Content: %w", err)
+	}
+
+	var result strings.Builder
+	response := &Response{}
+	for resp, err := range g.client.Models.GenerateContentStream(ctx, g.modelName, contents, g.generationConfig(opts)) {
 		if err != nil {
-			return "", fmt.Errorf("failed to convert message to genai.Content: %w", err)
+			return nil, fmt.Errorf("failed to stream content with history: %w", err)
+		}
+
+		// Metadata such as the finish reason and usage arrives with the last chunks
+		chunkResponse := newGeminiResponse(resp)
+		response.merge(chunkResponse)
+
+		if chunkResponse.Text == "" {
+			continue
+		}
+
+		result.WriteString(chunkResponse.Text)
+		if err := onChunk(chunkResponse.Text); err != nil {
+			return nil, err
 		}
-		contents = append(contents, content)
 	}
+	response.Text = result.String()
 
-	// Create generation config
-	config := &genai.GenerateContentConfig{
-		Temperature:     &g.temperature,
-		MaxOutputTokens: g.maxTokens,
+	if response.Text == "" && !response.Blocked() {
+		return nil, fmt.Errorf("no content parts returned (finish reason %s): %w", response.finishReason(), ErrEmptyResponse)
+	}
+	return response, nil
+}
+
+// SupportsFunctionCalling reports that Gemini supports native function calling
+func (g *Gemini) SupportsFunctionCalling() bool {
+	return true
+}
+
+// GenerateWithFunctionsAsync generates the next model message with conversation history,
+// declaring the given functions so the model can request structured calls to them
+func (g *Gemini) GenerateWithFunctionsAsync(ctx context.Context, messages []*types.Message, functions []*types.FunctionDeclaration, opts *GenerateOptions) (*Response, error) {
+	contents, err := types.MessagesToGenaiContent(messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert message to genai.Content: %w", err)
 	}
 
-	// Add system instruction if available
-	if g.systemPrompt != "" {
-		config.SystemInstruction = genai.NewContentFromText(g.systemPrompt, genai.RoleUser)
+	config := g.generationConfig(opts)
+	if len(functions) > 0 {
+		declarations := make([]*genai.FunctionDeclaration, 0, len(functions))
+		for _, fn := range functions {
+			declarations = append(declarations, fn.ToGenaiFunctionDeclaration())
+		}
+		config.Tools = []*genai.Tool{{FunctionDeclarations: declarations}}
 	}
 
 	resp, err := g.client.Models.GenerateContent(ctx, g.modelName, contents, config)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate content with history: %w", err)
+		return nil, fmt.Errorf("failed to generate content with functions: %w", err)
+	}
+
+	response := newGeminiResponse(resp)
+	if response.Blocked() {
+		return response, nil
 	}
 
 	if len(resp.Candidates) == 0 {
-		return "", fmt.Errorf("no candidates returned")
+		return nil, fmt.Errorf("no candidates returned: %w", ErrEmptyResponse)
 	}
 
-	if len(resp.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no content parts returned")
+	if resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return nil, fmt.Errorf("no content parts returned (finish reason %s): %w", response.finishReason(), ErrEmptyResponse)
 	}
 
-	// Extract text from the response
-	var result string
+	// Convert text and function call parts to message contents
+	var contentsOut []types.MessageContent
 	for _, part := range resp.Candidates[0].Content.Parts {
-		if part.Text != "" {
-			result += part.Text
+		switch {
+		case part.FunctionCall != nil:
+			contentsOut = append(contentsOut, types.MessageContent{
+				Type: "function_call",
+				FunctionCall: &types.FunctionCall{
+					ID:   part.FunctionCall.ID,
+					Name: part.FunctionCall.Name,
+					Args: part.FunctionCall.Args,
+				},
+			})
+		case part.Text != "":
+			contentsOut = append(contentsOut, types.MessageContent{Type: "text", Content: part.Text})
 		}
 	}
 
-	return result, nil
+	response.Message = types.NewMessage("AI", contentsOut)
+	return response, nil
+}
+
+// generationConfig builds the generation config for a request, applying opts over the model's defaults
+func (g *Gemini) generationConfig(opts *GenerateOptions) *genai.GenerateContentConfig {
+	settings := g.defaults.apply(opts)
+	config := &genai.GenerateContentConfig{
+		Temperature:     settings.Temperature,
+		MaxOutputTokens: settings.MaxOutputTokens,
+		TopP:            settings.TopP,
+		StopSequences:   settings.StopSequences,
+		CandidateCount:  settings.CandidateCount,
+	}
+	if settings.TopK != nil {
+		topK := float32(*settings.TopK)
+		config.TopK = &topK
+	}
+	for _, setting := range settings.SafetySettings {
+		config.SafetySettings = append(config.SafetySettings, &genai.SafetySetting{
+			Category:  genai.HarmCategory(setting.Category),
+			Threshold: genai.HarmBlockThreshold(setting.Threshold),
+		})
+	}
+
+	systemPrompt := g.systemPrompt
+	if settings.SystemPrompt != "" {
+		systemPrompt = settings.SystemPrompt
+	}
+
+	// Add system instruction if available
+	if systemPrompt != "" {
+		config.SystemInstruction = genai.NewContentFromText(systemPrompt, genai.RoleUser)
+	}
+
+	return config
+}
+
+// newGeminiResponse converts a Gemini response to a Response with the text of its first candidate
+func newGeminiResponse(resp *genai.GenerateContentResponse) *Response {
+	response := &Response{ModelVersion: resp.ModelVersion}
+
+	if usage := resp.UsageMetadata; usage != nil {
+		response.Usage = Usage{
+			PromptTokens: int(usage.PromptTokenCount),
+			OutputTokens: int(usage.CandidatesTokenCount + usage.ThoughtsTokenCount),
+			CachedTokens: int(usage.CachedContentTokenCount),
+			TotalTokens:  int(usage.TotalTokenCount),
+		}
+	}
+
+	if feedback := resp.PromptFeedback; feedback != nil && feedback.BlockReason != "" {
+		response.FinishReason = FinishReasonPromptBlocked
+		response.BlockReason = string(feedback.BlockReason)
+		if feedback.BlockReasonMessage != "" {
+			response.BlockReason += ": " + feedback.BlockReasonMessage
+		}
+		response.SafetyRatings = geminiSafetyRatings(feedback.SafetyRatings)
+		return response
+	}
+
+	if len(resp.Candidates) == 0 {
+		return response
+	}
+
+	candidate := resp.Candidates[0]
+	response.FinishReason = geminiFinishReason(candidate.FinishReason)
+	response.SafetyRatings = geminiSafetyRatings(candidate.SafetyRatings)
+	if candidate.Content != nil {
+		var text strings.Builder
+		for _, part := range candidate.Content.Parts {
+			text.WriteString(part.Text)
+		}
+		response.Text = text.String()
+	}
+	return response
+}
+
+// geminiFinishReason converts a Gemini finish reason, folding its content filter reasons into FinishReasonSafety
+func geminiFinishReason(reason genai.FinishReason) FinishReason {
+	switch reason {
+	case "", genai.FinishReasonUnspecified:
+		return FinishReasonUnspecified
+	case genai.FinishReasonSafety, genai.FinishReasonBlocklist, genai.FinishReasonProhibitedContent,
+		genai.FinishReasonSPII, genai.FinishReasonImageSafety:
+		return FinishReasonSafety
+	default:
+		return FinishReason(reason)
+	}
+}
+
+// geminiSafetyRatings converts Gemini safety ratings
+func geminiSafetyRatings(ratings []*genai.SafetyRating) []SafetyRating {
+	if len(ratings) == 0 {
+		return nil
+	}
+
+	converted := make([]SafetyRating, 0, len(ratings))
+	for _, rating := range ratings {
+		converted = append(converted, SafetyRating{
+			Category:    string(rating.Category),
+			Probability: string(rating.Probability),
+			Blocked:     rating.Blocked,
+		})
+	}
+	return converted
+}
+
+// CountTokens counts the tokens messages use in the model's context window
+func (g *Gemini) CountTokens(ctx context.Context, messages []*types.Message) (int, error) {
+	contents, err := types.MessagesToGenaiContent(messages)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert message to genai.Content: %w", err)
+	}
+
+	resp, err := g.client.Models.CountTokens(ctx, g.modelName, contents, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count tokens: %w", err)
+	}
+	return int(resp.TotalTokens), nil
+}
+
+// UploadFile uploads data to the Gemini file API and returns the file URI
+func (g *Gemini) UploadFile(ctx context.Context, data []byte, mimeType string) (string, error) {
+	file, err := g.client.Files.Upload(ctx, bytes.NewReader(data), &genai.UploadFileConfig{MIMEType: mimeType})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload file: %w", err)
+	}
+	return file.URI, nil
 }
 
 // Close closes the Gemini client (no-op for this implementation)
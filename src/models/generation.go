@@ -0,0 +1,183 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Harm categories that safety settings apply to
+var harmCategories = []string{
+	"HARM_CATEGORY_HARASSMENT",
+	"HARM_CATEGORY_HATE_SPEECH",
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT",
+	"HARM_CATEGORY_DANGEROUS_CONTENT",
+	"HARM_CATEGORY_CIVIC_INTEGRITY",
+}
+
+// Thresholds at which content in a harm category is blocked
+var harmBlockThresholds = []string{
+	"BLOCK_NONE",
+	"BLOCK_ONLY_HIGH",
+	"BLOCK_MEDIUM_AND_ABOVE",
+	"BLOCK_LOW_AND_ABOVE",
+	"OFF",
+}
+
+// defaultGenerateOptions returns the generation settings models use unless configured otherwise
+func defaultGenerateOptions() GenerateOptions {
+	temperature := float32(1.0)
+	return GenerateOptions{
+		Temperature:     &temperature,
+		MaxOutputTokens: 8192,
+	}
+}
+
+// generationDefaults holds the generation settings a model applies requests over.
+// It is safe for concurrent use.
+type generationDefaults struct {
+	mu       sync.RWMutex
+	settings GenerateOptions
+}
+
+// newGenerationDefaults returns the built-in generation settings
+func newGenerationDefaults() *generationDefaults {
+	return &generationDefaults{settings: defaultGenerateOptions()}
+}
+
+// set replaces the settings with opts applied over the built-in defaults, so a
+// setting left out of opts goes back to its default
+func (d *generationDefaults) set(opts GenerateOptions) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.settings = defaultGenerateOptions().Merge(&opts)
+}
+
+// apply returns the settings with opts applied over them
+func (d *generationDefaults) apply(opts *GenerateOptions) GenerateOptions {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.settings.Merge(opts)
+}
+
+// SafetySetting sets the threshold at which a safety filter blocks content in a harm
+// category. Categories and thresholds use Gemini's names, such as
+// HARM_CATEGORY_HARASSMENT and BLOCK_ONLY_HIGH, or their short lowercase forms such
+// as harassment and block_only_high.
+type SafetySetting struct {
+	Category  string `json:"category" yaml:"category"`
+	Threshold string `json:"threshold" yaml:"threshold"`
+}
+
+// Normalize returns the setting with its category and threshold in Gemini's names,
+// or an error if either is unknown
+func (s SafetySetting) Normalize() (SafetySetting, error) {
+	category := strings.ToUpper(strings.TrimSpace(s.Category))
+	if !strings.HasPrefix(category, "HARM_CATEGORY_") {
+		category = "HARM_CATEGORY_" + category
+	}
+	if !slices.Contains(harmCategories, category) {
+		return s, fmt.Errorf("unknown harm category %q, expected one of %s", s.Category, strings.Join(harmCategories, ", "))
+	}
+
+	threshold := strings.ToUpper(strings.TrimSpace(s.Threshold))
+	if !slices.Contains(harmBlockThresholds, threshold) {
+		return s, fmt.Errorf("unknown block threshold %q for %s, expected one of %s", s.Threshold, category, strings.Join(harmBlockThresholds, ", "))
+	}
+
+	return SafetySetting{Category: category, Threshold: threshold}, nil
+}
+
+// ParseSafetySettings parses a comma-separated list of category=threshold pairs,
+// for example "harassment=block_only_high,dangerous_content=block_low_and_above"
+func ParseSafetySettings(value string) ([]SafetySetting, error) {
+	var settings []SafetySetting
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		category, threshold, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid safety setting %q, expected category=threshold", pair)
+		}
+
+		setting, err := SafetySetting{Category: category, Threshold: threshold}.Normalize()
+		if err != nil {
+			return nil, err
+		}
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+// Validate checks the options' parameters are in range and normalizes their safety settings
+func (o *GenerateOptions) Validate() error {
+	if o.Temperature != nil && (*o.Temperature < 0 || *o.Temperature > 2) {
+		return fmt.Errorf("temperature %v must be between 0 and 2", *o.Temperature)
+	}
+	if o.TopP != nil && (*o.TopP < 0 || *o.TopP > 1) {
+		return fmt.Errorf("top_p %v must be between 0 and 1", *o.TopP)
+	}
+	if o.TopK != nil && *o.TopK < 1 {
+		return fmt.Errorf("top_k %d must be at least 1", *o.TopK)
+	}
+	if o.MaxOutputTokens < 0 {
+		return fmt.Errorf("max_output_tokens %d must not be negative", o.MaxOutputTokens)
+	}
+	if o.CandidateCount < 0 {
+		return fmt.Errorf("candidate_count %d must not be negative", o.CandidateCount)
+	}
+
+	for i, setting := range o.SafetySettings {
+		normalized, err := setting.Normalize()
+		if err != nil {
+			return fmt.Errorf("safety_settings: %w", err)
+		}
+		o.SafetySettings[i] = normalized
+	}
+	return nil
+}
+
+// Merge returns o with the fields set in override replacing its own. Safety settings
+// are merged by category, so an override only changes the categories it names.
+func (o GenerateOptions) Merge(override *GenerateOptions) GenerateOptions {
+	if override == nil {
+		return o
+	}
+
+	merged := o
+	if override.SystemPrompt != "" {
+		merged.SystemPrompt = override.SystemPrompt
+	}
+	if override.Temperature != nil {
+		merged.Temperature = override.Temperature
+	}
+	if override.MaxOutputTokens > 0 {
+		merged.MaxOutputTokens = override.MaxOutputTokens
+	}
+	if override.TopP != nil {
+		merged.TopP = override.TopP
+	}
+	if override.TopK != nil {
+		merged.TopK = override.TopK
+	}
+	if len(override.StopSequences) > 0 {
+		merged.StopSequences = override.StopSequences
+	}
+	if override.CandidateCount > 0 {
+		merged.CandidateCount = override.CandidateCount
+	}
+
+	if len(override.SafetySettings) > 0 {
+		merged.SafetySettings = nil
+		for _, setting := range o.SafetySettings {
+			if !slices.ContainsFunc(override.SafetySettings, func(s SafetySetting) bool { return s.Category == setting.Category }) {
+				merged.SafetySettings = append(merged.SafetySettings, setting)
+			}
+		}
+		merged.SafetySettings = append(merged.SafetySettings, override.SafetySettings...)
+	}
+	return merged
+}
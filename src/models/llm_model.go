@@ -5,14 +5,74 @@ import (
 	"discord-gemini-bot/src/types"
 )
 
+// GenerateOptions overrides the model's settings for a single request, so callers
+// sharing one model instance can each use their own system prompt and parameters.
+// Unset fields keep the model's defaults.
+type GenerateOptions struct {
+	// SystemPrompt replaces the model's default system prompt when set
+	SystemPrompt string `json:"-" yaml:"-"`
+	// Temperature replaces the model's default temperature when set
+	Temperature *float32 `json:"temperature,omitempty" yaml:"temperature,omitempty"`
+	// MaxOutputTokens replaces the model's default output limit when above zero
+	MaxOutputTokens int32 `json:"max_output_tokens,omitempty" yaml:"max_output_tokens,omitempty"`
+	// TopP replaces the model's default nucleus sampling probability when set
+	TopP *float32 `json:"top_p,omitempty" yaml:"top_p,omitempty"`
+	// TopK replaces the model's default top-k sampling limit when set
+	TopK *int32 `json:"top_k,omitempty" yaml:"top_k,omitempty"`
+	// StopSequences replaces the model's default stop sequences when not empty
+	StopSequences []string `json:"stop_sequences,omitempty" yaml:"stop_sequences,omitempty"`
+	// CandidateCount replaces the model's default number of candidates when above zero.
+	// Only the first candidate is used.
+	CandidateCount int32 `json:"candidate_count,omitempty" yaml:"candidate_count,omitempty"`
+	// SafetySettings replace the model's default settings for the same harm categories.
+	// Only Gemini applies them.
+	SafetySettings []SafetySetting `json:"safety_settings,omitempty" yaml:"safety_settings,omitempty"`
+}
+
 // LLMModel is an abstract interface for Large Language Models
 type LLMModel interface {
+	// Name returns the name of the underlying model
+	Name() string
+
 	// GenerateAsync generates text asynchronously based on the given prompt
 	GenerateAsync(ctx context.Context, prompt string, images []map[string]interface{}) (string, error)
-	
-	// GenerateWithHistoryAsync generates text asynchronously with conversation history
-	GenerateWithHistoryAsync(ctx context.Context, messages []*types.Message) (string, error)
-	
-	// SetSystemPrompt sets the system prompt for the model
+
+	// GenerateWithHistoryAsync generates a response asynchronously with conversation history.
+	// opts may be nil to use the model's defaults. A response blocked by a content
+	// filter is returned without an error and with an empty text; see Response.Blocked.
+	GenerateWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *GenerateOptions) (*Response, error)
+
+	// StreamWithHistoryAsync generates a response with conversation history like
+	// GenerateWithHistoryAsync, calling onChunk with each piece of text as it arrives.
+	// It returns the complete response. An error from onChunk stops the stream.
+	StreamWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *GenerateOptions, onChunk func(chunk string) error) (*Response, error)
+
+	// SetSystemPrompt sets the default system prompt for the model
 	SetSystemPrompt(systemPrompt string)
 }
+
+// GenerationConfigurable is implemented by models whose generation settings can be
+// replaced while they are in use
+type GenerationConfigurable interface {
+	SetGenerateOptions(opts GenerateOptions)
+}
+
+// FunctionCallingModel is implemented by models that support native function calling
+type FunctionCallingModel interface {
+	LLMModel
+
+	// GenerateWithFunctionsAsync generates the next model message with conversation history,
+	// letting the model respond with structured calls to the declared functions. The
+	// message is returned in Response.Message.
+	GenerateWithFunctionsAsync(ctx context.Context, messages []*types.Message, functions []*types.FunctionDeclaration, opts *GenerateOptions) (*Response, error)
+
+	// SupportsFunctionCalling reports whether GenerateWithFunctionsAsync can be used.
+	// A model wrapping other models may only support it through some of them.
+	SupportsFunctionCalling() bool
+}
+
+// SupportsFunctionCalling reports whether model supports native function calling
+func SupportsFunctionCalling(model LLMModel) bool {
+	functionModel, ok := model.(FunctionCallingModel)
+	return ok && functionModel.SupportsFunctionCalling()
+}
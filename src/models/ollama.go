@@ -0,0 +1,323 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"discord-gemini-bot/src/types"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultOllamaURL is the address an Ollama server listens on by default
+const DefaultOllamaURL = "http://localhost:11434"
+
+// Ollama implements the LLMModel interface using an Ollama server's chat API
+type Ollama struct {
+	httpClient   *http.Client
+	baseURL      string
+	modelName    string
+	systemPrompt string
+	defaults     *generationDefaults
+}
+
+// ollamaMessage is a message in Ollama's chat format, with images as raw base64
+type ollamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+// ollamaOptions are the model parameters of an Ollama chat request
+type ollamaOptions struct {
+	Temperature *float32 `json:"temperature,omitempty"`
+	NumPredict  int32    `json:"num_predict,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	TopK        *int32   `json:"top_k,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+// ollamaRequest is the body of an Ollama chat request
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  ollamaOptions   `json:"options"`
+}
+
+// ollamaResponse is an Ollama chat response, or one line of a streamed one
+type ollamaResponse struct {
+	Model      string        `json:"model"`
+	Message    ollamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason,omitempty"`
+	// PromptEvalCount and EvalCount are the prompt and output token counts, sent once done
+	PromptEvalCount int    `json:"prompt_eval_count,omitempty"`
+	EvalCount       int    `json:"eval_count,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// NewOllama creates a new model served by the Ollama server at baseURL, which
+// defaults to DefaultOllamaURL
+func NewOllama(baseURL, modelName string) (*Ollama, error) {
+	if modelName == "" {
+		return nil, fmt.Errorf("model name is required")
+	}
+
+	if baseURL == "" {
+		baseURL = DefaultOllamaURL
+	}
+
+	return &Ollama{
+		httpClient: &http.Client{Timeout: 10 * time.Minute},
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		modelName:  modelName,
+		defaults:   newGenerationDefaults(),
+	}, nil
+}
+
+// SetHTTPClient sets the HTTP client used for requests
+func (o *Ollama) SetHTTPClient(client *http.Client) {
+	o.httpClient = client
+}
+
+// Close closes the idle connections of the HTTP client
+func (o *Ollama) Close() error {
+	o.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// Name returns the name of the underlying model
+func (o *Ollama) Name() string {
+	return o.modelName
+}
+
+// SetSystemPrompt sets the default system prompt for the model
+func (o *Ollama) SetSystemPrompt(systemPrompt string) {
+	o.systemPrompt = systemPrompt
+}
+
+// SetGenerateOptions replaces the model's generation settings with opts applied over
+// the built-in defaults. Safety settings and the candidate count are ignored. It is
+// safe to call while requests are made.
+func (o *Ollama) SetGenerateOptions(opts GenerateOptions) {
+	o.defaults.set(opts)
+}
+
+// GenerateAsync generates text asynchronously based on the given prompt
+func (o *Ollama) GenerateAsync(ctx context.Context, prompt string, images []map[string]interface{}) (string, error) {
+	contents := []types.MessageContent{{Type: "text", Content: prompt}}
+	for _, image := range images {
+		if data, ok := image["data"].(string); ok {
+			mimeType, _ := image["mime_type"].(string)
+			contents = append(contents, types.MessageContent{Type: "image", Content: mimeType + "," + data})
+		}
+	}
+
+	response, err := o.GenerateWithHistoryAsync(ctx, []*types.Message{types.NewMessage("user", contents)}, nil)
+	if err != nil {
+		return "", err
+	}
+	return response.Text, nil
+}
+
+// GenerateWithHistoryAsync generates a response asynchronously with conversation history
+func (o *Ollama) GenerateWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *GenerateOptions) (*Response, error) {
+	resp, err := o.post(ctx, o.chatRequest(messages, opts, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chat ollamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chat); err != nil {
+		return nil, fmt.Errorf("failed to decode chat response: %w", err)
+	}
+
+	if chat.Error != "" {
+		return nil, fmt.Errorf("chat failed: %s", chat.Error)
+	}
+
+	if chat.Message.Content == "" {
+		return nil, fmt.Errorf("no content returned: %w", ErrEmptyResponse)
+	}
+
+	response := newOllamaResponse(&chat)
+	response.Text = chat.Message.Content
+	return response, nil
+}
+
+// StreamWithHistoryAsync generates text with conversation history, calling onChunk
+// with each piece of text as it is streamed from the server
+func (o *Ollama) StreamWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *GenerateOptions, onChunk func(chunk string) error) (*Response, error) {
+	resp, err := o.post(ctx, o.chatRequest(messages, opts, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result strings.Builder
+	response := &Response{}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// Ollama streams one JSON object per line
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk ollamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, fmt.Errorf("failed to decode chat response chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("chat failed: %s", chunk.Error)
+		}
+
+		if chunk.Message.Content != "" {
+			result.WriteString(chunk.Message.Content)
+			if err := onChunk(chunk.Message.Content); err != nil {
+				return nil, err
+			}
+		}
+		if chunk.Done {
+			response = newOllamaResponse(&chunk)
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read chat stream: %w", err)
+	}
+
+	if result.Len() == 0 {
+		return nil, fmt.Errorf("no content returned: %w", ErrEmptyResponse)
+	}
+	response.Text = result.String()
+	return response, nil
+}
+
+// chatRequest builds a chat request, applying opts over the model's defaults
+func (o *Ollama) chatRequest(messages []*types.Message, opts *GenerateOptions, stream bool) *ollamaRequest {
+	settings := o.defaults.apply(opts)
+	request := &ollamaRequest{
+		Model:  o.modelName,
+		Stream: stream,
+		Options: ollamaOptions{
+			Temperature: settings.Temperature,
+			NumPredict:  settings.MaxOutputTokens,
+			TopP:        settings.TopP,
+			TopK:        settings.TopK,
+			Stop:        settings.StopSequences,
+		},
+	}
+
+	systemPrompt := o.systemPrompt
+	if settings.SystemPrompt != "" {
+		systemPrompt = settings.SystemPrompt
+	}
+
+	if systemPrompt != "" {
+		request.Messages = append(request.Messages, ollamaMessage{Role: "system", Content: systemPrompt})
+	}
+	request.Messages = append(request.Messages, messagesToOllamaMessages(messages)...)
+	return request
+}
+
+// post sends a chat request and returns the response if it succeeded
+func (o *Ollama) post(ctx context.Context, request *ollamaRequest) (*http.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode chat request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send chat request: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		message := strings.TrimSpace(string(data))
+		var errorBody ollamaResponse
+		if json.Unmarshal(data, &errorBody) == nil && errorBody.Error != "" {
+			message = errorBody.Error
+		}
+		return nil, fmt.Errorf("chat request failed with %w", &StatusError{StatusCode: resp.StatusCode, Message: message})
+	}
+
+	return resp, nil
+}
+
+// newOllamaResponse converts the metadata of a finished Ollama chat response to a Response
+func newOllamaResponse(chat *ollamaResponse) *Response {
+	response := &Response{
+		ModelVersion: chat.Model,
+		Usage: Usage{
+			PromptTokens: chat.PromptEvalCount,
+			OutputTokens: chat.EvalCount,
+			TotalTokens:  chat.PromptEvalCount + chat.EvalCount,
+		},
+	}
+
+	switch chat.DoneReason {
+	case "":
+		response.FinishReason = FinishReasonUnspecified
+	case "stop":
+		response.FinishReason = FinishReasonStop
+	case "length":
+		response.FinishReason = FinishReasonMaxTokens
+	default:
+		response.FinishReason = FinishReason(strings.ToUpper(chat.DoneReason))
+	}
+	return response
+}
+
+// messagesToOllamaMessages converts messages to Ollama's chat format, merging
+// consecutive messages with the same role like messagesToChatMessages
+func messagesToOllamaMessages(messages []*types.Message) []ollamaMessage {
+	var ollamaMessages []ollamaMessage
+	for _, msg := range messages {
+		role := chatRole(msg.Role)
+
+		var texts []string
+		var images []string
+		for _, part := range contentsToChatParts(msg.Contents) {
+			if part.Type == "image_url" {
+				// Ollama takes the raw base64 data instead of a data URL
+				_, data, _ := strings.Cut(part.ImageURL.URL, ";base64,")
+				images = append(images, data)
+				continue
+			}
+			texts = append(texts, part.Text)
+		}
+		if len(texts) == 0 && len(images) == 0 {
+			continue
+		}
+
+		content := strings.Join(texts, "\n\n")
+		if last := len(ollamaMessages) - 1; last >= 0 && ollamaMessages[last].Role == role {
+			if content != "" {
+				if ollamaMessages[last].Content != "" {
+					ollamaMessages[last].Content += "\n\n"
+				}
+				ollamaMessages[last].Content += content
+			}
+			ollamaMessages[last].Images = append(ollamaMessages[last].Images, images...)
+			continue
+		}
+		ollamaMessages = append(ollamaMessages, ollamaMessage{Role: role, Content: content, Images: images})
+	}
+	return ollamaMessages
+}
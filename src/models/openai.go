@@ -0,0 +1,437 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"discord-gemini-bot/src/types"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAI implements the LLMModel interface for OpenAI-compatible chat completion
+// endpoints, such as OpenAI itself, vLLM, llama.cpp server and LM Studio
+type OpenAI struct {
+	httpClient   *http.Client
+	baseURL      string
+	apiKey       string
+	modelName    string
+	systemPrompt string
+	defaults     *generationDefaults
+}
+
+// chatMessage is a message in the chat completion wire format. Content is either a
+// string or a list of chatParts.
+type chatMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+// chatPart is a text or image part of a multimodal chat message
+type chatPart struct {
+	Type     string        `json:"type"`
+	Text     string        `json:"text,omitempty"`
+	ImageURL *chatImageURL `json:"image_url,omitempty"`
+}
+
+// chatImageURL references an image by URL, including base64 data URLs
+type chatImageURL struct {
+	URL string `json:"url"`
+}
+
+// chatRequest is the body of a chat completion request. TopK is not part of the
+// OpenAI API, but servers such as vLLM and llama.cpp accept it.
+type chatRequest struct {
+	Model       string        `json:"model"`
+	Messages    []chatMessage `json:"messages"`
+	Temperature *float32      `json:"temperature,omitempty"`
+	MaxTokens   int32         `json:"max_tokens,omitempty"`
+	TopP        *float32      `json:"top_p,omitempty"`
+	TopK        *int32        `json:"top_k,omitempty"`
+	Stop        []string      `json:"stop,omitempty"`
+	N           int32         `json:"n,omitempty"`
+	Stream      bool          `json:"stream,omitempty"`
+	// StreamOptions asks for the token usage in a final chunk of a streamed response
+	StreamOptions *chatStreamOptions `json:"stream_options,omitempty"`
+}
+
+// chatStreamOptions are the options of a streamed chat completion request
+type chatStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// chatResponse is a chat completion response, or a chunk of a streamed one
+type chatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Model string     `json:"model"`
+	Usage *chatUsage `json:"usage,omitempty"`
+	Error *chatError `json:"error,omitempty"`
+}
+
+// chatUsage is the token usage reported with a chat completion
+type chatUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details,omitempty"`
+}
+
+// chatError is the error object returned by OpenAI-compatible servers
+type chatError struct {
+	Message string `json:"message"`
+}
+
+// NewOpenAI creates a new model for the OpenAI-compatible API at baseURL, for example
+// "https://api.openai.com/v1" or "http://localhost:8000/v1". The API key may be empty
+// for local servers that do not check it.
+func NewOpenAI(baseURL, apiKey, modelName string) (*OpenAI, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("base URL is required")
+	}
+
+	if modelName == "" {
+		return nil, fmt.Errorf("model name is required")
+	}
+
+	return &OpenAI{
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		modelName:  modelName,
+		defaults:   newGenerationDefaults(),
+	}, nil
+}
+
+// SetHTTPClient sets the HTTP client used for requests
+func (o *OpenAI) SetHTTPClient(client *http.Client) {
+	o.httpClient = client
+}
+
+// Close closes the idle connections of the HTTP client
+func (o *OpenAI) Close() error {
+	o.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// Name returns the name of the underlying model
+func (o *OpenAI) Name() string {
+	return o.modelName
+}
+
+// SetSystemPrompt sets the default system prompt for the model
+func (o *OpenAI) SetSystemPrompt(systemPrompt string) {
+	o.systemPrompt = systemPrompt
+}
+
+// SetGenerateOptions replaces the model's generation settings with opts applied over
+// the built-in defaults. Safety settings are ignored. It is safe to call while
+// requests are made.
+func (o *OpenAI) SetGenerateOptions(opts GenerateOptions) {
+	o.defaults.set(opts)
+}
+
+// GenerateAsync generates text asynchronously based on the given prompt
+func (o *OpenAI) GenerateAsync(ctx context.Context, prompt string, images []map[string]interface{}) (string, error) {
+	contents := []types.MessageContent{{Type: "text", Content: prompt}}
+	for _, image := range images {
+		data, ok := image["data"].(string)
+		if !ok {
+			continue
+		}
+		mimeType, ok := image["mime_type"].(string)
+		if !ok {
+			continue
+		}
+		contents = append(contents, types.MessageContent{Type: "image", Content: mimeType + "," + data})
+	}
+
+	response, err := o.GenerateWithHistoryAsync(ctx, []*types.Message{types.NewMessage("user", contents)}, nil)
+	if err != nil {
+		return "", err
+	}
+	return response.Text, nil
+}
+
+// GenerateWithHistoryAsync generates a response asynchronously with conversation history
+func (o *OpenAI) GenerateWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *GenerateOptions) (*Response, error) {
+	resp, err := o.post(ctx, o.chatRequest(messages, opts, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var completion chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return nil, fmt.Errorf("failed to decode chat completion: %w", err)
+	}
+
+	if completion.Error != nil {
+		return nil, fmt.Errorf("chat completion failed: %s", completion.Error.Message)
+	}
+
+	if len(completion.Choices) == 0 {
+		return nil, fmt.Errorf("no choices returned: %w", ErrEmptyResponse)
+	}
+
+	response := newChatResponse(&completion)
+	response.Text = completion.Choices[0].Message.Content
+	if response.Text == "" && !response.Blocked() {
+		return nil, fmt.Errorf("no content returned (finish reason %s): %w", response.finishReason(), ErrEmptyResponse)
+	}
+	return response, nil
+}
+
+// StreamWithHistoryAsync generates text with conversation history, calling onChunk
+// with each piece of text as it is streamed from the server
+func (o *OpenAI) StreamWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *GenerateOptions, onChunk func(chunk string) error) (*Response, error) {
+	resp, err := o.post(ctx, o.chatRequest(messages, opts, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result strings.Builder
+	response := &Response{}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// Server-sent events carry each chunk on a "data:" line
+		data, found := strings.CutPrefix(scanner.Text(), "data:")
+		if !found {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk chatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to decode chat completion chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return nil, fmt.Errorf("chat completion failed: %s", chunk.Error.Message)
+		}
+
+		// The finish reason arrives with the last choice, and the usage in a chunk without choices
+		response.merge(newChatResponse(&chunk))
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		text := chunk.Choices[0].Delta.Content
+		result.WriteString(text)
+		if err := onChunk(text); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read chat completion stream: %w", err)
+	}
+	response.Text = result.String()
+
+	if response.Text == "" && !response.Blocked() {
+		return nil, fmt.Errorf("no content returned: %w", ErrEmptyResponse)
+	}
+	return response, nil
+}
+
+// chatRequest builds a chat completion request, applying opts over the model's defaults
+func (o *OpenAI) chatRequest(messages []*types.Message, opts *GenerateOptions, stream bool) *chatRequest {
+	settings := o.defaults.apply(opts)
+	request := &chatRequest{
+		Model:       o.modelName,
+		Temperature: settings.Temperature,
+		MaxTokens:   settings.MaxOutputTokens,
+		TopP:        settings.TopP,
+		TopK:        settings.TopK,
+		Stop:        settings.StopSequences,
+		N:           settings.CandidateCount,
+		Stream:      stream,
+	}
+	if stream {
+		request.StreamOptions = &chatStreamOptions{IncludeUsage: true}
+	}
+
+	systemPrompt := o.systemPrompt
+	if settings.SystemPrompt != "" {
+		systemPrompt = settings.SystemPrompt
+	}
+
+	if systemPrompt != "" {
+		request.Messages = append(request.Messages, chatMessage{Role: "system", Content: systemPrompt})
+	}
+	request.Messages = append(request.Messages, messagesToChatMessages(messages)...)
+	return request
+}
+
+// post sends a chat completion request and returns the response if it succeeded
+func (o *OpenAI) post(ctx context.Context, request *chatRequest) (*http.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode chat completion request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send chat completion request: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		message := strings.TrimSpace(string(data))
+		var errorBody chatResponse
+		if json.Unmarshal(data, &errorBody) == nil && errorBody.Error != nil {
+			message = errorBody.Error.Message
+		}
+		return nil, fmt.Errorf("chat completion request failed with %w", &StatusError{StatusCode: resp.StatusCode, Message: message})
+	}
+
+	return resp, nil
+}
+
+// newChatResponse converts the metadata of a chat completion, or a chunk of a streamed one, to a Response
+func newChatResponse(completion *chatResponse) *Response {
+	response := &Response{ModelVersion: completion.Model}
+	if len(completion.Choices) > 0 {
+		response.FinishReason = chatFinishReason(completion.Choices[0].FinishReason)
+	}
+	if usage := completion.Usage; usage != nil {
+		response.Usage = Usage{
+			PromptTokens: usage.PromptTokens,
+			OutputTokens: usage.CompletionTokens,
+			TotalTokens:  usage.TotalTokens,
+		}
+		if usage.PromptTokensDetails != nil {
+			response.Usage.CachedTokens = usage.PromptTokensDetails.CachedTokens
+		}
+	}
+	return response
+}
+
+// chatFinishReason converts a chat completion finish reason
+func chatFinishReason(reason string) FinishReason {
+	switch reason {
+	case "":
+		return FinishReasonUnspecified
+	case "stop", "tool_calls", "function_call":
+		return FinishReasonStop
+	case "length":
+		return FinishReasonMaxTokens
+	case "content_filter":
+		return FinishReasonSafety
+	default:
+		return FinishReason(strings.ToUpper(reason))
+	}
+}
+
+// messagesToChatMessages converts messages to the chat completion wire format. Model
+// messages become assistant messages and everything else user messages. Consecutive
+// messages with the same role are merged, as many chat templates require the roles to alternate.
+func messagesToChatMessages(messages []*types.Message) []chatMessage {
+	var chatMessages []chatMessage
+	var parts []chatPart
+	role := ""
+
+	flush := func() {
+		if len(parts) > 0 {
+			chatMessages = append(chatMessages, chatMessage{Role: role, Content: chatContent(parts)})
+		}
+		parts = nil
+	}
+
+	for _, msg := range messages {
+		msgRole := chatRole(msg.Role)
+		if msgRole != role {
+			flush()
+			role = msgRole
+		}
+		parts = append(parts, contentsToChatParts(msg.Contents)...)
+	}
+	flush()
+
+	return chatMessages
+}
+
+// chatRole maps a message role to a chat role: model messages are assistant messages
+// and everything else, including users, summaries and tool results, user messages
+func chatRole(role string) string {
+	if role == "AI" || role == "assistant" {
+		return "assistant"
+	}
+	return "user"
+}
+
+// contentsToChatParts converts message contents to chat message parts
+func contentsToChatParts(contents []types.MessageContent) []chatPart {
+	parts := make([]chatPart, 0, len(contents))
+	for _, c := range contents {
+		switch c.Type {
+		case "image":
+			// Image contents are stored as "mime,base64", which maps directly to a data URL
+			mimeType, data, found := strings.Cut(c.Content, ",")
+			if !found {
+				mimeType, data = "image/png", c.Content
+			}
+			parts = append(parts, chatPart{Type: "image_url", ImageURL: &chatImageURL{URL: "data:" + mimeType + ";base64," + data}})
+		case "file":
+			// Files uploaded to the Gemini file API cannot be read by other backends
+			mimeType, _, _ := strings.Cut(c.Content, ",")
+			parts = append(parts, chatPart{Type: "text", Text: fmt.Sprintf("[Attachment of type %s is not supported by this model]", mimeType)})
+		case "function_call":
+			if c.FunctionCall == nil {
+				continue
+			}
+			args, _ := json.Marshal(c.FunctionCall.Args)
+			parts = append(parts, chatPart{Type: "text", Text: fmt.Sprintf("Function call: %s(%s)", c.FunctionCall.Name, args)})
+		case "function_response":
+			if c.FunctionResponse == nil {
+				continue
+			}
+			response, _ := json.Marshal(c.FunctionResponse.Response)
+			parts = append(parts, chatPart{Type: "text", Text: fmt.Sprintf("Function %s returned: %s", c.FunctionResponse.Name, response)})
+		default:
+			if c.Content != "" {
+				parts = append(parts, chatPart{Type: "text", Text: c.Content})
+			}
+		}
+	}
+	return parts
+}
+
+// chatContent returns parts as a plain string when they are all text, which every
+// server supports, and as a list of parts otherwise
+func chatContent(parts []chatPart) interface{} {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type != "text" {
+			return parts
+		}
+		texts = append(texts, part.Text)
+	}
+	return strings.Join(texts, "\n\n")
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"discord-gemini-bot/src/types"
+	"fmt"
+	"strings"
+)
+
+// FinishReason is why a model stopped generating a response
+type FinishReason string
+
+// Finish reasons reported by every backend. Backends may report other, provider
+// specific reasons as is.
+const (
+	// FinishReasonUnspecified means the backend didn't report a reason
+	FinishReasonUnspecified FinishReason = ""
+	// FinishReasonStop means the model finished its response naturally
+	FinishReasonStop FinishReason = "STOP"
+	// FinishReasonMaxTokens means the response was cut off at the output token limit
+	FinishReasonMaxTokens FinishReason = "MAX_TOKENS"
+	// FinishReasonSafety means the response was blocked by a safety or content filter
+	FinishReasonSafety FinishReason = "SAFETY"
+	// FinishReasonRecitation means the response was blocked for reciting training data
+	FinishReasonRecitation FinishReason = "RECITATION"
+	// FinishReasonPromptBlocked means the prompt itself was blocked, so nothing was generated
+	FinishReasonPromptBlocked FinishReason = "PROMPT_BLOCKED"
+	// FinishReasonOther means the model stopped for another reason
+	FinishReasonOther FinishReason = "OTHER"
+)
+
+// SafetyRating is a safety filter's assessment of a prompt or response for one harm category
+type SafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	// Blocked is true when this rating caused the content to be blocked
+	Blocked bool `json:"blocked,omitempty"`
+}
+
+// Usage counts the tokens used by a request
+type Usage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	// CachedTokens is the part of PromptTokens served from a context cache
+	CachedTokens int `json:"cached_tokens,omitempty"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// Add returns the sum of both usages
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens: u.PromptTokens + other.PromptTokens,
+		OutputTokens: u.OutputTokens + other.OutputTokens,
+		CachedTokens: u.CachedTokens + other.CachedTokens,
+		TotalTokens:  u.TotalTokens + other.TotalTokens,
+	}
+}
+
+// Response is a model's response to a request, with the metadata reported by the backend
+type Response struct {
+	// Text is the generated text
+	Text string
+	// Message is the complete model message, including function calls. It is only
+	// set by GenerateWithFunctionsAsync, and is nil when the response was blocked.
+	Message *types.Message
+	// FinishReason is why the model stopped generating
+	FinishReason FinishReason
+	// BlockReason is the backend's explanation when the prompt was blocked
+	BlockReason string
+	// SafetyRatings are the safety filter's ratings of the response, or of the prompt when it was blocked
+	SafetyRatings []SafetyRating
+	// Usage is the number of tokens used by the request
+	Usage Usage
+	// ModelVersion is the exact model version that served the request, when the backend reports it
+	ModelVersion string
+}
+
+// Blocked reports whether the prompt or the response was blocked by a content filter
+func (r *Response) Blocked() bool {
+	switch r.FinishReason {
+	case FinishReasonSafety, FinishReasonRecitation, FinishReasonPromptBlocked:
+		return true
+	default:
+		return false
+	}
+}
+
+// Truncated reports whether the response was cut off at the output token limit
+func (r *Response) Truncated() bool {
+	return r.FinishReason == FinishReasonMaxTokens
+}
+
+// BlockedCategories returns the harm categories that caused the response to be blocked
+func (r *Response) BlockedCategories() []string {
+	var categories []string
+	for _, rating := range r.SafetyRatings {
+		if rating.Blocked {
+			categories = append(categories, rating.Category)
+		}
+	}
+	return categories
+}
+
+// String summarizes the response metadata for logging
+func (r *Response) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "finish reason %s, %d prompt and %d output tokens", r.finishReason(), r.Usage.PromptTokens, r.Usage.OutputTokens)
+	if r.Usage.CachedTokens > 0 {
+		fmt.Fprintf(&sb, " (%d cached)", r.Usage.CachedTokens)
+	}
+	if r.ModelVersion != "" {
+		fmt.Fprintf(&sb, ", model %s", r.ModelVersion)
+	}
+	if r.BlockReason != "" {
+		fmt.Fprintf(&sb, ", blocked: %s", r.BlockReason)
+	}
+	if categories := r.BlockedCategories(); len(categories) > 0 {
+		fmt.Fprintf(&sb, ", blocked categories: %s", strings.Join(categories, ", "))
+	}
+	return sb.String()
+}
+
+// merge copies the metadata reported in a later chunk of a streamed response, leaving the text alone
+func (r *Response) merge(chunk *Response) {
+	if chunk.FinishReason != FinishReasonUnspecified {
+		r.FinishReason = chunk.FinishReason
+	}
+	if chunk.BlockReason != "" {
+		r.BlockReason = chunk.BlockReason
+	}
+	if len(chunk.SafetyRatings) > 0 {
+		r.SafetyRatings = chunk.SafetyRatings
+	}
+	// Streamed chunks report the usage so far, so the last one is the total
+	if chunk.Usage != (Usage{}) {
+		r.Usage = chunk.Usage
+	}
+	if chunk.ModelVersion != "" {
+		r.ModelVersion = chunk.ModelVersion
+	}
+}
+
+// finishReason returns the finish reason, or "unspecified" when there is none
+func (r *Response) finishReason() FinishReason {
+	if r.FinishReason == FinishReasonUnspecified {
+		return "unspecified"
+	}
+	return r.FinishReason
+}
@@ -0,0 +1,316 @@
+package ratelimit
+
+import (
+	"context"
+	"discord-gemini-bot/src/metrics"
+	"discord-gemini-bot/src/models"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxIdleBuckets is the number of buckets kept before full buckets are dropped
+	maxIdleBuckets = 1024
+	// DefaultFlushInterval is how often RunFlusher is usually run to save the counters
+	DefaultFlushInterval = 10 * time.Second
+)
+
+// Limiter enforces message rate limits, daily model budgets and tool quotas. Daily
+// counters reset at midnight UTC and are saved to a file, if configured, by Flush so
+// they survive restarts. It is safe for concurrent use.
+type Limiter struct {
+	path string
+	now  func() time.Time
+
+	mu      sync.Mutex
+	limits  Limits
+	buckets map[string]*bucket
+	state   *state
+	// dirty is set when the counters changed since they were last saved
+	dirty bool
+
+	// flushMu serializes writes of the counters, so an older copy never replaces a newer one
+	flushMu sync.Mutex
+}
+
+// bucket is a token bucket refilled at a steady rate
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewLimiter creates a limiter enforcing limits, restoring the daily counters saved
+// at path. An empty path keeps the counters in memory only.
+func NewLimiter(limits Limits, path string) (*Limiter, error) {
+	l := &Limiter{
+		path:    path,
+		now:     time.Now,
+		limits:  limits,
+		buckets: make(map[string]*bucket),
+	}
+	st, err := loadState(path)
+	if err != nil {
+		return nil, err
+	}
+	l.state = st
+	return l, nil
+}
+
+// SetLimits replaces the limits, keeping the counters. It is safe to call while
+// requests are checked.
+func (l *Limiter) SetLimits(limits Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+}
+
+// SetClock sets the function returning the current time
+func (l *Limiter) SetClock(now func() time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.now = now
+}
+
+// scopes returns the scopes of caller with their counter keys, from the narrowest.
+// Direct messages have no guild scope.
+func scopes(caller Caller) [][2]string {
+	keys := [][2]string{
+		{ScopeUser, ScopeUser + ":" + caller.UserID},
+		{ScopeChannel, ScopeChannel + ":" + caller.ChannelID},
+	}
+	if caller.GuildID != "" {
+		keys = append(keys, [2]string{ScopeGuild, ScopeGuild + ":" + caller.GuildID})
+	}
+	return append(keys, [2]string{ScopeGlobal, ScopeGlobal})
+}
+
+// Allow checks a new message from the caller of ctx against the daily budgets and
+// rate limits of its user, channel, guild and the whole bot, taking a token from
+// each rate limit if it is allowed. It returns a *LimitError if a limit is exceeded.
+// Requests without a caller are always allowed.
+func (l *Limiter) Allow(ctx context.Context) error {
+	caller, ok := CallerFrom(ctx)
+	if !ok {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.rollover(now)
+
+	keys := scopes(caller)
+	for _, key := range keys {
+		limit := l.limits.scope(key[0])
+		usage := l.state.Usage[key[1]]
+		if usage == nil {
+			continue
+		}
+		if limit.DailyTokens > 0 && usage.Tokens >= limit.DailyTokens {
+			return l.limited(ctx, &LimitError{Scope: key[0], Kind: KindDailyTokens})
+		}
+		if limit.DailyCost > 0 && usage.Cost >= limit.DailyCost {
+			return l.limited(ctx, &LimitError{Scope: key[0], Kind: KindDailyCost})
+		}
+	}
+
+	// Check every bucket before taking from any, so a message refused by one limit
+	// doesn't count against the others
+	var taken []*bucket
+	for _, key := range keys {
+		limit := l.limits.scope(key[0])
+		if limit.PerMinute <= 0 {
+			continue
+		}
+		b := l.refill(key[1], limit, now)
+		if b.tokens < 1 {
+			wait := time.Duration((1 - b.tokens) / limit.PerMinute * float64(time.Minute))
+			return l.limited(ctx, &LimitError{Scope: key[0], Kind: KindRate, RetryAfter: wait})
+		}
+		taken = append(taken, b)
+	}
+	for _, b := range taken {
+		b.tokens--
+	}
+	l.prune(now)
+	return nil
+}
+
+// limited records a refused request and returns err
+func (l *Limiter) limited(ctx context.Context, err *LimitError) error {
+	metrics.ObserveRateLimited(err.Scope, err.Kind)
+	slog.InfoContext(ctx, "Request limited", "scope", err.Scope, "limit", err.Kind, "retry_after", err.RetryAfter)
+	return err
+}
+
+// refill returns the bucket of key topped up for the time elapsed since its last use;
+// the caller must hold l.mu
+func (l *Limiter) refill(key string, limit Limit, now time.Time) *bucket {
+	burst := float64(max(limit.Burst, 1))
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: burst, updated: now}
+		l.buckets[key] = b
+	}
+	elapsed := now.Sub(b.updated).Minutes()
+	b.tokens = math.Min(burst, b.tokens+elapsed*limit.PerMinute)
+	b.updated = now
+	return b
+}
+
+// prune drops the buckets that have refilled completely once there are many of them;
+// the caller must hold l.mu
+func (l *Limiter) prune(now time.Time) {
+	if len(l.buckets) <= maxIdleBuckets {
+		return
+	}
+	for key, b := range l.buckets {
+		scope, _, _ := strings.Cut(key, ":")
+		limit := l.limits.scope(scope)
+		if limit.PerMinute <= 0 || b.tokens+now.Sub(b.updated).Minutes()*limit.PerMinute >= float64(max(limit.Burst, 1)) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Record adds the tokens and estimated cost of usage to the daily counters of the
+// caller of ctx
+func (l *Limiter) Record(ctx context.Context, usage models.Usage) {
+	caller, ok := CallerFrom(ctx)
+	if !ok {
+		return
+	}
+	tokens := usage.TotalTokens
+	if tokens == 0 {
+		tokens = usage.PromptTokens + usage.OutputTokens
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollover(l.now())
+
+	cost := l.limits.Pricing.Cost(usage)
+	for _, key := range scopes(caller) {
+		counter := l.state.Usage[key[1]]
+		if counter == nil {
+			counter = &Usage{}
+			l.state.Usage[key[1]] = counter
+		}
+		counter.Tokens += tokens
+		counter.Cost += cost
+	}
+	l.dirty = true
+}
+
+// UseTool counts a call of the named tool on behalf of the caller of ctx against the
+// tool's quotas. It returns a *LimitError without counting the call if a quota is
+// used up. Tools without a quota are always allowed.
+func (l *Limiter) UseTool(ctx context.Context, tool string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	quota, exists := l.limits.Tools[tool]
+	if !exists || (quota.Daily <= 0 && quota.PerUserDaily <= 0) {
+		return nil
+	}
+	l.rollover(l.now())
+
+	globalKey := tool
+	if quota.Daily > 0 && l.state.Tools[globalKey] >= quota.Daily {
+		return l.limited(ctx, &LimitError{Scope: ScopeGlobal, Kind: KindToolQuota, Tool: tool})
+	}
+	caller, hasCaller := CallerFrom(ctx)
+	userKey := tool + ":" + ScopeUser + ":" + caller.UserID
+	if hasCaller && quota.PerUserDaily > 0 && l.state.Tools[userKey] >= quota.PerUserDaily {
+		return l.limited(ctx, &LimitError{Scope: ScopeUser, Kind: KindToolQuota, Tool: tool})
+	}
+
+	l.state.Tools[globalKey]++
+	if hasCaller {
+		l.state.Tools[userKey]++
+	}
+	l.dirty = true
+	return nil
+}
+
+// Usage returns the tokens and cost counted today for a scope's ID, such as a user
+// ID, or for the whole bot with ScopeGlobal
+func (l *Limiter) Usage(scope, id string) Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollover(l.now())
+
+	key := scope
+	if scope != ScopeGlobal {
+		key += ":" + id
+	}
+	if usage := l.state.Usage[key]; usage != nil {
+		return *usage
+	}
+	return Usage{}
+}
+
+// Flush saves the daily counters if they changed since they were last saved. They are
+// encoded while the limiter is locked but written after, so requests being checked
+// don't wait for the disk.
+func (l *Limiter) Flush() error {
+	if l.path == "" {
+		return nil
+	}
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	data, err := l.state.encode()
+	l.dirty = false
+	l.mu.Unlock()
+
+	if err == nil {
+		err = writeState(l.path, data)
+	}
+	if err != nil {
+		// Try again with the next flush
+		l.mu.Lock()
+		l.dirty = true
+		l.mu.Unlock()
+	}
+	return err
+}
+
+// RunFlusher saves changed counters every interval until ctx is done
+func (l *Limiter) RunFlusher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Flush(); err != nil {
+				slog.Error("Error saving rate limit counters", "error", err)
+			}
+		}
+	}
+}
+
+// Close saves the daily counters if they changed
+func (l *Limiter) Close() error {
+	return l.Flush()
+}
+
+// rollover resets the daily counters when the UTC day has changed since they were
+// started; the caller must hold l.mu
+func (l *Limiter) rollover(now time.Time) {
+	day := now.UTC().Format(time.DateOnly)
+	if l.state.Day != day {
+		*l.state = *newState(day)
+		l.dirty = true
+	}
+}
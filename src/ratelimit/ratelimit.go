@@ -0,0 +1,168 @@
+package ratelimit
+
+import (
+	"context"
+	"discord-gemini-bot/src/models"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Scopes that limits apply to
+const (
+	ScopeUser    = "user"
+	ScopeChannel = "channel"
+	ScopeGuild   = "guild"
+	ScopeGlobal  = "global"
+)
+
+// Kinds of limits
+const (
+	KindRate        = "rate"
+	KindDailyTokens = "daily_tokens"
+	KindDailyCost   = "daily_cost"
+	KindToolQuota   = "tool_quota"
+)
+
+// Limit bounds the messages and the daily model usage of each user, channel or guild,
+// or of the whole bot. Zero values disable a limit.
+type Limit struct {
+	// PerMinute is the sustained number of messages per minute
+	PerMinute float64 `yaml:"per_minute"`
+	// Burst is the number of messages that may be sent at once, 1 if not set
+	Burst int `yaml:"burst"`
+	// DailyTokens is the number of model tokens that may be used per day
+	DailyTokens int `yaml:"daily_tokens"`
+	// DailyCost is the estimated model cost in US dollars that may be spent per day
+	DailyCost float64 `yaml:"daily_cost"`
+}
+
+// Pricing estimates the cost of model requests from their token usage
+type Pricing struct {
+	// InputPerMillion is the price in US dollars of a million prompt tokens
+	InputPerMillion float64 `yaml:"input_per_million"`
+	// OutputPerMillion is the price in US dollars of a million output tokens
+	OutputPerMillion float64 `yaml:"output_per_million"`
+}
+
+// Cost returns the estimated cost of usage in US dollars
+func (p Pricing) Cost(usage models.Usage) float64 {
+	return (float64(usage.PromptTokens)*p.InputPerMillion + float64(usage.OutputTokens)*p.OutputPerMillion) / 1e6
+}
+
+// ToolQuota bounds the daily calls of an expensive tool. Zero values disable a quota.
+type ToolQuota struct {
+	// Daily is the number of calls per day for the whole bot
+	Daily int `yaml:"daily"`
+	// PerUserDaily is the number of calls per day on behalf of each user
+	PerUserDaily int `yaml:"per_user_daily"`
+}
+
+// Limits are the limits of every scope, the pricing used for cost budgets and the
+// quotas of tools by name
+type Limits struct {
+	User    Limit                `yaml:"user"`
+	Channel Limit                `yaml:"channel"`
+	Guild   Limit                `yaml:"guild"`
+	Global  Limit                `yaml:"global"`
+	Pricing Pricing              `yaml:"pricing"`
+	Tools   map[string]ToolQuota `yaml:"tools"`
+}
+
+// scope returns the limit of a scope
+func (l *Limits) scope(scope string) Limit {
+	switch scope {
+	case ScopeUser:
+		return l.User
+	case ScopeChannel:
+		return l.Channel
+	case ScopeGuild:
+		return l.Guild
+	default:
+		return l.Global
+	}
+}
+
+// Caller identifies who a request is made on behalf of. The guild ID is empty for
+// direct messages.
+type Caller struct {
+	GuildID   string
+	ChannelID string
+	UserID    string
+}
+
+// callerKey is the context key of the caller
+type callerKey struct{}
+
+// WithCaller returns a context carrying caller, so usage and tool calls made for the
+// request are counted against its limits
+func WithCaller(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFrom returns the caller of ctx, if any
+func CallerFrom(ctx context.Context) (Caller, bool) {
+	caller, ok := ctx.Value(callerKey{}).(Caller)
+	return caller, ok
+}
+
+// LimitError is returned when a request exceeds a limit
+type LimitError struct {
+	// Scope is the scope whose limit was exceeded
+	Scope string
+	// Kind is the kind of limit exceeded
+	Kind string
+	// Tool is the tool whose quota was exceeded, for KindToolQuota
+	Tool string
+	// RetryAfter is how long until the request would be allowed, for KindRate
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	switch e.Kind {
+	case KindRate:
+		return fmt.Sprintf("%s rate limit exceeded, retry after %s", e.Scope, e.RetryAfter)
+	case KindToolQuota:
+		return fmt.Sprintf("%s daily %s quota exceeded", e.Scope, e.Tool)
+	default:
+		return fmt.Sprintf("%s %s budget exceeded", e.Scope, e.Kind)
+	}
+}
+
+// Reply returns a friendly message explaining the limit to Discord users
+func (e *LimitError) Reply() string {
+	if e.Kind == KindRate {
+		wait := fmt.Sprintf("%d seconds", int(math.Ceil(e.RetryAfter.Seconds())))
+		switch e.Scope {
+		case ScopeUser:
+			return fmt.Sprintf("Whoa, that's a lot of questions! Please wait %s before asking me again.", wait)
+		case ScopeChannel:
+			return fmt.Sprintf("This channel is keeping me very busy. Please try again in %s.", wait)
+		case ScopeGuild:
+			return fmt.Sprintf("This server is keeping me very busy. Please try again in %s.", wait)
+		default:
+			return fmt.Sprintf("I'm very busy right now. Please try again in %s.", wait)
+		}
+	}
+
+	switch e.Scope {
+	case ScopeUser:
+		return "You've reached your daily usage limit. It resets at midnight UTC."
+	case ScopeChannel:
+		return "This channel has reached its daily usage limit. It resets at midnight UTC."
+	case ScopeGuild:
+		return "This server has reached its daily usage limit. It resets at midnight UTC."
+	default:
+		return "I've reached my daily usage limit. It resets at midnight UTC."
+	}
+}
+
+// Reply returns the friendly message for err if it is a *LimitError, or "" otherwise
+func Reply(err error) string {
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		return limitErr.Reply()
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Usage is the model usage counted for a scope during a day
+type Usage struct {
+	Tokens int     `json:"tokens"`
+	Cost   float64 `json:"cost"`
+}
+
+// state holds the counters of a UTC day
+type state struct {
+	// Day is the date the counters were started on, as YYYY-MM-DD
+	Day string `json:"day"`
+	// Usage maps scope keys, such as "user:<id>" or "global", to their model usage
+	Usage map[string]*Usage `json:"usage"`
+	// Tools maps tool names, alone or followed by a scope key, to their call counts
+	Tools map[string]int `json:"tools"`
+}
+
+// newState creates empty counters for day
+func newState(day string) *state {
+	return &state{
+		Day:   day,
+		Usage: make(map[string]*Usage),
+		Tools: make(map[string]int),
+	}
+}
+
+// loadState reads the counters saved at path, or returns empty counters if path is
+// empty or doesn't exist yet
+func loadState(path string) (*state, error) {
+	st := newState("")
+	if path == "" {
+		return st, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate limit counters: %w", err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to parse rate limit counters in %s: %w", path, err)
+	}
+	if st.Usage == nil {
+		st.Usage = make(map[string]*Usage)
+	}
+	if st.Tools == nil {
+		st.Tools = make(map[string]int)
+	}
+	return st, nil
+}
+
+// encode returns the counters as JSON
+func (st *state) encode() ([]byte, error) {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode rate limit counters: %w", err)
+	}
+	return data, nil
+}
+
+// writeState writes encoded counters to path atomically via a temporary file
+func writeState(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create rate limit directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create rate limit file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write rate limit file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write rate limit file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace rate limit file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"context"
+	"discord-gemini-bot/src/tools"
+	"fmt"
+	"io"
+)
+
+// limitedTool is a tool whose calls count against its quotas
+type limitedTool struct {
+	tools.Tool
+	limiter *Limiter
+}
+
+// LimitTool returns tool with its calls counted against the limiter's quota for it.
+// Once the quota is used up, calls report an error telling the model to carry on
+// without the tool.
+func LimitTool(tool tools.Tool, limiter *Limiter) tools.Tool {
+	return &limitedTool{Tool: tool, limiter: limiter}
+}
+
+// ARun runs the tool if its quota allows
+func (lt *limitedTool) ARun(ctx context.Context, args ...interface{}) (*tools.ToolResult, error) {
+	if err := lt.limiter.UseTool(ctx, lt.Name()); err != nil {
+		return &tools.ToolResult{
+			ReturnDisplay: fmt.Sprintf("Error: the daily quota of %s is used up. Answer without it.", lt.Name()),
+		}, nil
+	}
+	return lt.Tool.ARun(ctx, args...)
+}
+
+// Close closes the tool if it holds resources
+func (lt *limitedTool) Close() error {
+	if closer, ok := lt.Tool.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
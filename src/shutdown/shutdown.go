@@ -0,0 +1,184 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultCancelGrace is how long tasks cancelled at the deadline are given to return
+const DefaultCancelGrace = 5 * time.Second
+
+// ErrShuttingDown is returned by Begin once shutdown has started
+var ErrShuttingDown = errors.New("shutting down")
+
+// ErrDeadline is the cause of the context of a task cancelled because it was still
+// running at the shutdown deadline
+var ErrDeadline = errors.New("cancelled by shutdown")
+
+// Coordinator tracks running tasks, such as agent turns, so that shutdown can stop
+// new tasks, let running ones finish until a deadline, cancel the rest and then
+// release resources. It is safe for concurrent use.
+type Coordinator struct {
+	cancelGrace time.Duration
+
+	mu       sync.Mutex
+	stopping bool
+	nextID   uint64
+	tasks    map[uint64]*task
+	running  sync.WaitGroup
+	hooks    []hook
+}
+
+// task is a running task
+type task struct {
+	name   string
+	cancel context.CancelCauseFunc
+}
+
+// hook releases a resource after the tasks have stopped
+type hook struct {
+	name string
+	fn   func() error
+}
+
+// Report describes what happened during shutdown
+type Report struct {
+	// Finished is the number of tasks running at shutdown that finished before the deadline
+	Finished int
+	// Cancelled names the tasks cancelled at the deadline
+	Cancelled []string
+	// Abandoned names the cancelled tasks that hadn't returned after the grace period
+	Abandoned []string
+	// Errors are the errors of the shutdown hooks
+	Errors []error
+}
+
+// NewCoordinator creates a coordinator with no running tasks
+func NewCoordinator() *Coordinator {
+	return &Coordinator{
+		cancelGrace: DefaultCancelGrace,
+		tasks:       make(map[uint64]*task),
+	}
+}
+
+// SetCancelGrace sets how long tasks cancelled at the deadline are given to return
+// before shutdown carries on without them
+func (c *Coordinator) SetCancelGrace(grace time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancelGrace = grace
+}
+
+// Begin starts a task, returning a context that is cancelled with ErrDeadline if the
+// task is still running at the shutdown deadline, and a function to call when the
+// task is done. It returns ErrShuttingDown once shutdown has started.
+func (c *Coordinator) Begin(ctx context.Context, name string) (context.Context, func(), error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopping {
+		return ctx, func() {}, ErrShuttingDown
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	c.nextID++
+	id := c.nextID
+	c.tasks[id] = &task{name: name, cancel: cancel}
+	c.running.Add(1)
+
+	var once sync.Once
+	done := func() {
+		once.Do(func() {
+			c.mu.Lock()
+			delete(c.tasks, id)
+			c.mu.Unlock()
+			cancel(nil)
+			c.running.Done()
+		})
+	}
+	return ctx, done, nil
+}
+
+// OnShutdown registers fn to release a resource once the tasks have stopped. Hooks
+// run in the order they were registered.
+func (c *Coordinator) OnShutdown(name string, fn func() error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks = append(c.hooks, hook{name: name, fn: fn})
+}
+
+// Stopping reports whether shutdown has started
+func (c *Coordinator) Stopping() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopping
+}
+
+// Shutdown stops new tasks, waits for the running ones until the timeout, cancels
+// those still running, and then runs the shutdown hooks
+func (c *Coordinator) Shutdown(timeout time.Duration) *Report {
+	c.mu.Lock()
+	c.stopping = true
+	running := len(c.tasks)
+	grace := c.cancelGrace
+	c.mu.Unlock()
+
+	report := &Report{}
+	drained := make(chan struct{})
+	go func() {
+		c.running.Wait()
+		close(drained)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+		report.Cancelled = c.cancelRunning()
+		select {
+		case <-drained:
+		case <-time.After(grace):
+			report.Abandoned = c.runningNames()
+		}
+	}
+	report.Finished = running - len(report.Cancelled)
+
+	c.mu.Lock()
+	hooks := slices.Clone(c.hooks)
+	c.mu.Unlock()
+	for _, h := range hooks {
+		if err := h.fn(); err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("error closing %s: %w", h.name, err))
+		}
+	}
+	return report
+}
+
+// cancelRunning cancels the running tasks and returns their names
+func (c *Coordinator) cancelRunning() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var names []string
+	for _, t := range c.tasks {
+		t.cancel(ErrDeadline)
+		names = append(names, t.name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// runningNames returns the names of the running tasks
+func (c *Coordinator) runningNames() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var names []string
+	for _, t := range c.tasks {
+		names = append(names, t.name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,179 @@
+package testutil
+
+import (
+	"context"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/types"
+	"errors"
+	"sync"
+)
+
+// ErrNoResponses is returned by a FakeModel that has run out of scripted responses
+var ErrNoResponses = errors.New("fake model has no scripted responses left")
+
+// ResponseFunc computes a fake model's response from the conversation it was given
+type ResponseFunc func(messages []*types.Message) (string, error)
+
+// FakeCall records a single request made to a FakeModel
+type FakeCall struct {
+	Prompt   string
+	Messages []*types.Message
+	Options  *models.GenerateOptions
+}
+
+// FakeModel is an offline, deterministic LLMModel for tests. It returns scripted
+// responses in order, or computes them with a ResponseFunc, and records every call.
+// Text responses finish with models.FinishReasonStop and report estimated token
+// usage. It is safe for concurrent use.
+type FakeModel struct {
+	mu           sync.Mutex
+	responses    []*models.Response
+	respond      ResponseFunc
+	calls        []FakeCall
+	chunkSize    int
+	systemPrompt string
+}
+
+// NewFakeModel creates a fake model that returns the given responses in order
+func NewFakeModel(responses ...string) *FakeModel {
+	m := &FakeModel{chunkSize: 8}
+	m.AddResponses(responses...)
+	return m
+}
+
+// NewFakeModelResponses creates a fake model that returns the given responses, including
+// their finish reasons and metadata, in order
+func NewFakeModelResponses(responses ...*models.Response) *FakeModel {
+	return &FakeModel{responses: responses, chunkSize: 8}
+}
+
+// NewFakeModelFunc creates a fake model that computes every response with respond
+func NewFakeModelFunc(respond ResponseFunc) *FakeModel {
+	return &FakeModel{respond: respond, chunkSize: 8}
+}
+
+// AddResponses appends scripted text responses
+func (m *FakeModel) AddResponses(responses ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, response := range responses {
+		m.responses = append(m.responses, &models.Response{Text: response, FinishReason: models.FinishReasonStop})
+	}
+}
+
+// SetChunkSize sets how many bytes each streamed chunk holds
+func (m *FakeModel) SetChunkSize(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chunkSize = size
+}
+
+// Calls returns the requests made to the model so far
+func (m *FakeModel) Calls() []FakeCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]FakeCall(nil), m.calls...)
+}
+
+// SystemPrompt returns the default system prompt set with SetSystemPrompt
+func (m *FakeModel) SystemPrompt() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.systemPrompt
+}
+
+// Name returns the model name
+func (m *FakeModel) Name() string {
+	return "fake"
+}
+
+// GenerateAsync returns the next response for a single prompt
+func (m *FakeModel) GenerateAsync(ctx context.Context, prompt string, images []map[string]interface{}) (string, error) {
+	messages := []*types.Message{types.NewMessage("user", []types.MessageContent{{Type: "text", Content: prompt}})}
+	response, err := m.next(ctx, FakeCall{Prompt: prompt, Messages: messages})
+	if err != nil {
+		return "", err
+	}
+	return response.Text, nil
+}
+
+// GenerateWithHistoryAsync returns the next response for a conversation
+func (m *FakeModel) GenerateWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *models.GenerateOptions) (*models.Response, error) {
+	return m.next(ctx, FakeCall{Messages: messages, Options: opts})
+}
+
+// StreamWithHistoryAsync returns the next response for a conversation, passing its text to onChunk in pieces
+func (m *FakeModel) StreamWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *models.GenerateOptions, onChunk func(chunk string) error) (*models.Response, error) {
+	response, err := m.next(ctx, FakeCall{Messages: messages, Options: opts})
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	size := m.chunkSize
+	m.mu.Unlock()
+	if size <= 0 {
+		size = len(response.Text)
+	}
+
+	for rest := response.Text; rest != ""; {
+		n := min(size, len(rest))
+		if err := onChunk(rest[:n]); err != nil {
+			return nil, err
+		}
+		rest = rest[n:]
+	}
+	return response, nil
+}
+
+// SetSystemPrompt records the default system prompt
+func (m *FakeModel) SetSystemPrompt(systemPrompt string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.systemPrompt = systemPrompt
+}
+
+// next records call and returns the response to it
+func (m *FakeModel) next(ctx context.Context, call FakeCall) (*models.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	// Copy the history, as callers reuse and extend their slices
+	call.Messages = append([]*types.Message(nil), call.Messages...)
+	m.calls = append(m.calls, call)
+
+	var response *models.Response
+	if m.respond != nil {
+		respond := m.respond
+		m.mu.Unlock()
+		text, err := respond(call.Messages)
+		if err != nil {
+			return nil, err
+		}
+		response = &models.Response{Text: text, FinishReason: models.FinishReasonStop}
+	} else {
+		if len(m.responses) == 0 {
+			m.mu.Unlock()
+			return nil, ErrNoResponses
+		}
+		scripted := *m.responses[0]
+		m.responses = m.responses[1:]
+		m.mu.Unlock()
+		response = &scripted
+	}
+
+	if response.Usage == (models.Usage{}) {
+		response.Usage = estimateUsage(ctx, call.Messages, response.Text)
+	}
+	return response, nil
+}
+
+// estimateUsage estimates the tokens used by a request, like a real model would report them
+func estimateUsage(ctx context.Context, messages []*types.Message, text string) models.Usage {
+	counter := types.NewEstimateTokenCounter()
+	prompt, _ := counter.CountTokens(ctx, messages)
+	output, _ := counter.CountTokens(ctx, []*types.Message{types.NewMessage("AI", []types.MessageContent{{Type: "text", Content: text}})})
+	return models.Usage{PromptTokens: prompt, OutputTokens: output, TotalTokens: prompt + output}
+}
@@ -0,0 +1,106 @@
+package testutil
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// FakeSession is an offline Discord session for tests that keeps the messages posted to
+// each channel in memory. It implements handler.Session and is safe for concurrent use.
+type FakeSession struct {
+	mu       sync.Mutex
+	nextID   int
+	channels map[string][]*discordgo.Message
+	typing   map[string]int
+	edits    int
+	// SendErr, when set, is returned by every ChannelMessageSend call
+	SendErr error
+}
+
+// NewFakeSession creates a fake session with no messages
+func NewFakeSession() *FakeSession {
+	return &FakeSession{
+		channels: make(map[string][]*discordgo.Message),
+		typing:   make(map[string]int),
+	}
+}
+
+// ChannelMessageSend posts a message to the channel
+func (fs *FakeSession) ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.SendErr != nil {
+		return nil, fs.SendErr
+	}
+	fs.nextID++
+	msg := &discordgo.Message{ID: fmt.Sprintf("%d", fs.nextID), ChannelID: channelID, Content: content}
+	fs.channels[channelID] = append(fs.channels[channelID], msg)
+	return msg, nil
+}
+
+// ChannelMessageEdit replaces the content of a posted message
+func (fs *FakeSession) ChannelMessageEdit(channelID, messageID, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, msg := range fs.channels[channelID] {
+		if msg.ID == messageID {
+			fs.edits++
+			msg.Content = content
+			return msg, nil
+		}
+	}
+	return nil, fmt.Errorf("message %s not found in channel %s", messageID, channelID)
+}
+
+// ChannelMessageDelete removes a posted message
+func (fs *FakeSession) ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	messages := fs.channels[channelID]
+	for i, msg := range messages {
+		if msg.ID == messageID {
+			fs.channels[channelID] = append(messages[:i:i], messages[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("message %s not found in channel %s", messageID, channelID)
+}
+
+// ChannelTyping records a typing indicator in the channel
+func (fs *FakeSession) ChannelTyping(channelID string, options ...discordgo.RequestOption) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.typing[channelID]++
+	return nil
+}
+
+// Messages returns the content of the messages in the channel, oldest first
+func (fs *FakeSession) Messages(channelID string) []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	contents := make([]string, 0, len(fs.channels[channelID]))
+	for _, msg := range fs.channels[channelID] {
+		contents = append(contents, msg.Content)
+	}
+	return contents
+}
+
+// Typing returns how many times a typing indicator was started in the channel
+func (fs *FakeSession) Typing(channelID string) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.typing[channelID]
+}
+
+// Edits returns how many times messages were edited
+func (fs *FakeSession) Edits() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.edits
+}
@@ -0,0 +1,55 @@
+package testutil
+
+import (
+	"context"
+	"discord-gemini-bot/src/tools"
+	"sync"
+)
+
+// ToolFunc computes a fake tool's output from its input
+type ToolFunc func(input string) (string, error)
+
+// FakeTool is an offline tool for tests that records its inputs. It is safe for concurrent use.
+type FakeTool struct {
+	*tools.BaseTool
+	mu     sync.Mutex
+	run    ToolFunc
+	inputs []string
+}
+
+// NewFakeTool creates a fake tool that computes its output with run
+func NewFakeTool(name, description string, run ToolFunc) *FakeTool {
+	return &FakeTool{
+		BaseTool: tools.NewBaseTool(name, description),
+		run:      run,
+	}
+}
+
+// NewEchoTool creates a fake tool that returns its input
+func NewEchoTool(name string) *FakeTool {
+	return NewFakeTool(name, "Returns the input unchanged.", func(input string) (string, error) {
+		return input, nil
+	})
+}
+
+// ARun records the input and returns the output of the tool's function
+func (ft *FakeTool) ARun(ctx context.Context, args ...interface{}) (*tools.ToolResult, error) {
+	input, _ := tools.StringArg(args, "input")
+
+	ft.mu.Lock()
+	ft.inputs = append(ft.inputs, input)
+	ft.mu.Unlock()
+
+	output, err := ft.run(input)
+	if err != nil {
+		return nil, err
+	}
+	return &tools.ToolResult{ReturnDisplay: output}, nil
+}
+
+// Inputs returns the inputs the tool was called with, in order
+func (ft *FakeTool) Inputs() []string {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return append([]string(nil), ft.inputs...)
+}
@@ -0,0 +1,98 @@
+package testutil
+
+import (
+	"context"
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/handler"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/tools"
+	"discord-gemini-bot/src/types"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// BotUserID is the user ID of the bot in a Harness
+const BotUserID = "bot"
+
+// Harness drives the message handler with a fake Discord session, without a gateway
+type Harness struct {
+	Session *FakeSession
+	Handler *handler.Handler
+	Agents  *agent.Registry
+
+	mu       sync.Mutex
+	memories map[string]*types.ConversationMemory
+	nextID   int
+}
+
+// NewHarness creates a harness whose channel agents use model and toolList with the given
+// options and a memory window of windowSize messages. Replies are sent without delays.
+func NewHarness(model models.LLMModel, toolList []tools.Tool, opts agent.Options, windowSize int) *Harness {
+	h := &Harness{
+		Session:  NewFakeSession(),
+		memories: make(map[string]*types.ConversationMemory),
+	}
+
+	h.Agents = agent.NewRegistry(func(guildID, channelID string) (*agent.Agent, error) {
+		memory := types.NewConversationMemory(windowSize)
+		h.mu.Lock()
+		h.memories[channelID] = memory
+		h.mu.Unlock()
+		return agent.NewAgentWithOptions(model, memory, toolList, opts), nil
+	}, time.Hour)
+
+	h.Handler = handler.NewHandler(h.Agents, nil)
+	h.Handler.SetChunkDelay(0)
+	h.Handler.SetEditInterval(0)
+	return h
+}
+
+// Memory returns the conversation memory of the channel's agent, or nil if the channel has no agent yet
+func (h *Harness) Memory(channelID string) *types.ConversationMemory {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.memories[channelID]
+}
+
+// Mention handles a message from author that mentions the bot and returns the
+// messages posted to the channel in reply
+func (h *Harness) Mention(channelID, author, content string) []string {
+	return h.Deliver(h.NewMessage(channelID, author, "<@"+BotUserID+"> "+content, true))
+}
+
+// Deliver handles m as if it arrived from the gateway and returns the messages posted to its channel in reply
+func (h *Harness) Deliver(m *discordgo.MessageCreate) []string {
+	before := len(h.Session.Messages(m.ChannelID))
+	h.Handler.HandleMessage(h.Session, BotUserID, m)
+	return h.Session.Messages(m.ChannelID)[before:]
+}
+
+// NewMessage creates a message from author in the channel, mentioning the bot if mention is true
+func (h *Harness) NewMessage(channelID, author, content string, mention bool) *discordgo.MessageCreate {
+	h.mu.Lock()
+	h.nextID++
+	id := fmt.Sprintf("in-%d", h.nextID)
+	h.mu.Unlock()
+
+	msg := &discordgo.Message{
+		ID:        id,
+		ChannelID: channelID,
+		Content:   content,
+		Author:    &discordgo.User{ID: "user-" + author, Username: author},
+	}
+	if mention {
+		msg.Mentions = []*discordgo.User{{ID: BotUserID}}
+	}
+	return &discordgo.MessageCreate{Message: msg}
+}
+
+// Reset clears the conversation memory of the channel's agent
+func (h *Harness) Reset(channelID string) error {
+	return h.Agents.Do(context.Background(), "", channelID, func(a *agent.Agent) error {
+		a.ClearMemory()
+		return nil
+	})
+}
@@ -17,6 +17,7 @@ type GoogleSearchTool struct {
 	apiKey string
 	cseID  string
 	url    string
+	client *http.Client
 }
 
 // GoogleSearchResult represents a single search result
@@ -31,23 +32,38 @@ type GoogleSearchResult struct {
 // NewGoogleSearchTool creates a new Google search tool
 func NewGoogleSearchTool() *GoogleSearchTool {
 	return &GoogleSearchTool{
-		BaseTool: NewBaseTool(
+		BaseTool: NewBaseToolWithParameters(
 			"google_search",
 			"Searches Google for the given query.",
+			SingleStringParameter("query", "The search query"),
 		),
 		apiKey: os.Getenv("GOOGLE_API_KEY"),
 		cseID:  os.Getenv("GOOGLE_CSE_ID"),
 		url:    "https://www.googleapis.com/customsearch/v1",
+		client: &http.Client{},
 	}
 }
 
+// SetCredentials sets the API key and custom search engine ID used for searches,
+// replacing those read from GOOGLE_API_KEY and GOOGLE_CSE_ID
+func (gst *GoogleSearchTool) SetCredentials(apiKey, cseID string) {
+	gst.apiKey = apiKey
+	gst.cseID = cseID
+}
+
+// Close closes the tool's idle connections
+func (gst *GoogleSearchTool) Close() error {
+	gst.client.CloseIdleConnections()
+	return nil
+}
+
 // ARun executes the Google search tool asynchronously
 func (gst *GoogleSearchTool) ARun(ctx context.Context, args ...interface{}) (*ToolResult, error) {
 	if len(args) == 0 {
 		return &ToolResult{ReturnDisplay: "Error: No query provided"}, nil
 	}
 
-	query, ok := args[0].(string)
+	query, ok := StringArg(args, "query")
 	if !ok {
 		return &ToolResult{ReturnDisplay: "Error: Query must be a string"}, nil
 	}
@@ -70,8 +86,7 @@ func (gst *GoogleSearchTool) ARun(ctx context.Context, args ...interface{}) (*To
 		return &ToolResult{ReturnDisplay: fmt.Sprintf("Error creating request: %v", err)}, nil
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := gst.client.Do(req)
 	if err != nil {
 		return &ToolResult{ReturnDisplay: fmt.Sprintf("Error making request: %v", err)}, nil
 	}
@@ -2,6 +2,7 @@ package tools
 
 import (
 	"context"
+	"discord-gemini-bot/src/types"
 	"fmt"
 )
 
@@ -14,13 +15,16 @@ type ToolResult struct {
 type Tool interface {
 	// Name returns the name of the tool
 	Name() string
-	
+
 	// Description returns the description of the tool
 	Description() string
-	
+
+	// Parameters returns the schema of the arguments accepted by the tool
+	Parameters() *types.Schema
+
 	// Run executes the tool synchronously
 	Run(ctx context.Context, args ...interface{}) (*ToolResult, error)
-	
+
 	// ARun executes the tool asynchronously
 	ARun(ctx context.Context, args ...interface{}) (*ToolResult, error)
 }
@@ -29,13 +33,31 @@ type Tool interface {
 type BaseTool struct {
 	name        string
 	description string
+	parameters  *types.Schema
 }
 
-// NewBaseTool creates a new base tool
+// NewBaseTool creates a new base tool that takes a single free-text input
 func NewBaseTool(name, description string) *BaseTool {
+	return NewBaseToolWithParameters(name, description, SingleStringParameter("input", "The input to the tool"))
+}
+
+// NewBaseToolWithParameters creates a new base tool with the given parameter schema
+func NewBaseToolWithParameters(name, description string, parameters *types.Schema) *BaseTool {
 	return &BaseTool{
 		name:        name,
 		description: description,
+		parameters:  parameters,
+	}
+}
+
+// SingleStringParameter returns an object schema with one required string property
+func SingleStringParameter(name, description string) *types.Schema {
+	return &types.Schema{
+		Type: "object",
+		Properties: map[string]*types.Schema{
+			name: {Type: "string", Description: description},
+		},
+		Required: []string{name},
 	}
 }
 
@@ -49,6 +71,11 @@ func (bt *BaseTool) Description() string {
 	return bt.description
 }
 
+// Parameters returns the schema of the arguments accepted by the tool
+func (bt *BaseTool) Parameters() *types.Schema {
+	return bt.parameters
+}
+
 // Run provides a default implementation that returns not implemented
 func (bt *BaseTool) Run(ctx context.Context, args ...interface{}) (*ToolResult, error) {
 	return nil, fmt.Errorf("tool %s does not support sync execution", bt.name)
@@ -58,3 +85,30 @@ func (bt *BaseTool) Run(ctx context.Context, args ...interface{}) (*ToolResult,
 func (bt *BaseTool) ARun(ctx context.Context, args ...interface{}) (*ToolResult, error) {
 	return nil, fmt.Errorf("tool %s does not support async execution", bt.name)
 }
+
+// Declaration returns the function declaration of a tool for native function calling
+func Declaration(tool Tool) *types.FunctionDeclaration {
+	return &types.FunctionDeclaration{
+		Name:        tool.Name(),
+		Description: tool.Description(),
+		Parameters:  tool.Parameters(),
+	}
+}
+
+// StringArg extracts a string argument from tool args. The first argument may be
+// either a plain string (ReAct input) or a map of named arguments (native function call).
+func StringArg(args []interface{}, key string) (string, bool) {
+	if len(args) == 0 {
+		return "", false
+	}
+
+	switch v := args[0].(type) {
+	case string:
+		return v, true
+	case map[string]interface{}:
+		value, ok := v[key].(string)
+		return value, ok
+	default:
+		return "", false
+	}
+}
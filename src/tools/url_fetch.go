@@ -17,9 +17,10 @@ type URLFetchTool struct {
 // NewURLFetchTool creates a new URL fetch tool
 func NewURLFetchTool() *URLFetchTool {
 	return &URLFetchTool{
-		BaseTool: NewBaseTool(
+		BaseTool: NewBaseToolWithParameters(
 			"url_fetch",
 			"Fetches the content of a given URL. Input should be a valid URL string.",
+			SingleStringParameter("url", "The URL to fetch, including the scheme"),
 		),
 		client: &http.Client{
 			Timeout: 30 * time.Second,
@@ -27,13 +28,19 @@ func NewURLFetchTool() *URLFetchTool {
 	}
 }
 
+// Close closes the tool's idle connections
+func (uft *URLFetchTool) Close() error {
+	uft.client.CloseIdleConnections()
+	return nil
+}
+
 // ARun executes the URL fetch tool asynchronously
 func (uft *URLFetchTool) ARun(ctx context.Context, args ...interface{}) (*ToolResult, error) {
 	if len(args) == 0 {
 		return &ToolResult{ReturnDisplay: "Error: No URL provided"}, nil
 	}
 
-	urlStr, ok := args[0].(string)
+	urlStr, ok := StringArg(args, "url")
 	if !ok {
 		return &ToolResult{ReturnDisplay: "Error: URL must be a string"}, nil
 	}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName is the service name of the bot's spans
+const ServiceName = "discord-gemini-bot"
+
+// Span exporters
+const (
+	ExporterNone   = ""
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Span attribute keys
+const (
+	GuildID      = attribute.Key("discord.guild_id")
+	ChannelID    = attribute.Key("discord.channel_id")
+	UserID       = attribute.Key("discord.user_id")
+	RequestID    = attribute.Key("request.id")
+	Command      = attribute.Key("discord.command")
+	Model        = attribute.Key("gen_ai.request.model")
+	Operation    = attribute.Key("gen_ai.operation.name")
+	Attempt      = attribute.Key("gen_ai.request.attempt")
+	FinishReason = attribute.Key("gen_ai.response.finish_reason")
+	InputTokens  = attribute.Key("gen_ai.usage.input_tokens")
+	OutputTokens = attribute.Key("gen_ai.usage.output_tokens")
+	CachedTokens = attribute.Key("gen_ai.usage.cached_tokens")
+	AgentSteps   = attribute.Key("agent.steps")
+	Completed    = attribute.Key("agent.completed")
+	ToolName     = attribute.Key("tool.name")
+	ToolOutcome  = attribute.Key("tool.outcome")
+)
+
+// Options configures where spans are exported
+type Options struct {
+	// Exporter is ExporterNone, ExporterStdout or ExporterOTLP
+	Exporter string
+	// Endpoint is the host:port of the OTLP/HTTP collector, or empty for the exporter's
+	// default or OTEL_EXPORTER_OTLP_ENDPOINT
+	Endpoint string
+	// Insecure sends spans to the collector over plain HTTP
+	Insecure bool
+	// SampleRatio is the fraction of traces recorded, from 0 to 1
+	SampleRatio float64
+	// Writer receives the spans of the stdout exporter, os.Stdout if nil
+	Writer io.Writer
+}
+
+// Setup installs a global tracer provider exporting spans as configured by opts, and
+// returns a function that flushes the remaining spans and stops it. Spans are dropped
+// when no exporter is configured.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		writer := opts.Writer
+		if writer == nil {
+			writer = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(writer))
+	case ExporterOTLP:
+		var httpOpts []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			httpOpts = append(httpOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			httpOpts = append(httpOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, httpOpts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s trace exporter: %w", opts.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span of ctx, if any
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(ServiceName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Fail records err on span and marks it failed
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// End marks span failed if err is not nil and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		Fail(span, err)
+	}
+	span.End()
+}
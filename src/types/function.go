@@ -0,0 +1,85 @@
+package types
+
+import "google.golang.org/genai"
+
+// Schema describes the parameters accepted by a function, in a subset of OpenAPI schema
+type Schema struct {
+	Type        string             `json:"type"` // e.g., "object", "string", "integer"
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+}
+
+// FunctionDeclaration describes a function the model may call
+type FunctionDeclaration struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Parameters  *Schema `json:"parameters,omitempty"`
+}
+
+// FunctionCall is a structured request from the model to call a function
+type FunctionCall struct {
+	ID   string                 `json:"id,omitempty"`
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// FunctionResponse carries the result of a function call back to the model
+type FunctionResponse struct {
+	ID       string                 `json:"id,omitempty"`
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+// ToGenaiSchema converts a Schema to genai.Schema format
+func (s *Schema) ToGenaiSchema() *genai.Schema {
+	if s == nil {
+		return nil
+	}
+
+	schema := &genai.Schema{
+		Type:        genaiType(s.Type),
+		Description: s.Description,
+		Required:    s.Required,
+		Enum:        s.Enum,
+		Items:       s.Items.ToGenaiSchema(),
+	}
+	if len(s.Properties) > 0 {
+		schema.Properties = make(map[string]*genai.Schema, len(s.Properties))
+		for name, prop := range s.Properties {
+			schema.Properties[name] = prop.ToGenaiSchema()
+		}
+	}
+	return schema
+}
+
+// ToGenaiFunctionDeclaration converts a FunctionDeclaration to genai.FunctionDeclaration format
+func (fd *FunctionDeclaration) ToGenaiFunctionDeclaration() *genai.FunctionDeclaration {
+	return &genai.FunctionDeclaration{
+		Name:        fd.Name,
+		Description: fd.Description,
+		Parameters:  fd.Parameters.ToGenaiSchema(),
+	}
+}
+
+// genaiType maps a lowercase JSON schema type name to genai.Type
+func genaiType(t string) genai.Type {
+	switch t {
+	case "object":
+		return genai.TypeObject
+	case "string":
+		return genai.TypeString
+	case "integer":
+		return genai.TypeInteger
+	case "number":
+		return genai.TypeNumber
+	case "boolean":
+		return genai.TypeBoolean
+	case "array":
+		return genai.TypeArray
+	default:
+		return genai.TypeUnspecified
+	}
+}
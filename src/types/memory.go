@@ -1,9 +1,77 @@
 package types
 
-// ConversationMemory manages conversation history for a single channel
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// tokenCountTimeout bounds how long counting the tokens of a new message may take
+	tokenCountTimeout = 10 * time.Second
+	// summarizeTimeout bounds how long folding evicted turns into the summary may take
+	summarizeTimeout = 30 * time.Second
+	// maxPendingSummaryMessages caps evicted messages kept for retry when summarizing fails
+	maxPendingSummaryMessages = 50
+)
+
+// MemorySnapshot is the serialized form of a conversation memory
+type MemorySnapshot struct {
+	Messages []*Message `json:"messages"`
+	Summary  string     `json:"summary,omitempty"`
+}
+
+// Summarizer folds evicted messages into a running summary of the conversation
+type Summarizer interface {
+	Summarize(ctx context.Context, summary string, evicted []*Message) (string, error)
+}
+
+// MemoryStore persists conversation memory so it survives restarts
+type MemoryStore interface {
+	// Load returns the snapshot stored under key, or nil if there is none
+	Load(key string) (*MemorySnapshot, error)
+
+	// Save replaces the snapshot stored under key
+	Save(key string, snapshot *MemorySnapshot) error
+
+	// Delete removes the snapshot stored under key
+	Delete(key string) error
+
+	// Close releases the store's resources
+	Close() error
+}
+
+// ConversationMemory manages conversation history for a single channel. History is
+// bounded by a message window and, optionally, a token budget. Whole turns are
+// evicted, oldest first, so tool calls are never separated from their results.
+// A persistent memory is saved to its store by Flush, not on every change.
+// It is safe for concurrent use.
 type ConversationMemory struct {
+	mu         sync.Mutex
 	windowSize int
 	history    []*Message
+	store      MemoryStore
+	key        string
+	// dirty is set when the memory changed since it was last saved
+	dirty bool
+	// flushMu serializes saves, so an older snapshot never overwrites a newer one
+	flushMu sync.Mutex
+
+	// Token budgeting, enabled by SetTokenBudget
+	tokenBudget  int
+	tokenCounter TokenCounter
+	tokens       []int
+	totalTokens  int
+
+	// Rolling summarization, enabled by SetSummarizer
+	summarizer     Summarizer
+	summary        string
+	pendingSummary []*Message
+	summarizing    bool
+	// generation is incremented by Clear, so a summary started before is discarded
+	generation int
 }
 
 // NewConversationMemory creates a new conversation memory with specified window size
@@ -14,20 +82,328 @@ func NewConversationMemory(windowSize int) *ConversationMemory {
 	}
 }
 
-// AddMessage adds a message to the conversation history
-func (cm *ConversationMemory) AddMessage(message *Message) {
+// NewPersistentConversationMemory creates a conversation memory backed by store,
+// restoring any history previously saved under key
+func NewPersistentConversationMemory(windowSize int, store MemoryStore, key string) (*ConversationMemory, error) {
+	cm := NewConversationMemory(windowSize)
+	cm.store = store
+	cm.key = key
+
+	snapshot, err := store.Load(key)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot != nil {
+		cm.summary = snapshot.Summary
+		for _, message := range snapshot.Messages {
+			cm.append(message)
+		}
+	}
+	return cm, nil
+}
+
+// SetSummarizer enables rolling summarization: evicted turns are folded into a
+// running summary that is prepended to the history
+func (cm *ConversationMemory) SetSummarizer(summarizer Summarizer) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.summarizer = summarizer
+}
+
+// Summary returns the running summary of evicted conversation history
+func (cm *ConversationMemory) Summary() string {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.summary
+}
+
+// SetTokenBudget limits the history to budget tokens as measured by counter.
+// A budget of zero or less disables token budgeting.
+func (cm *ConversationMemory) SetTokenBudget(budget int, counter TokenCounter) {
+	// Count the messages without a cached count before taking the lock, as the
+	// counter may call a remote API
+	counts := make(map[*Message]int)
+	if budget > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), tokenCountTimeout)
+		defer cancel()
+		for _, message := range cm.historyCopy() {
+			if message.Tokens == 0 {
+				counts[message] = countTokens(ctx, counter, message)
+			}
+		}
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.tokenBudget = budget
+	cm.tokenCounter = counter
+
+	cm.tokens = make([]int, 0, len(cm.history))
+	cm.totalTokens = 0
+	if budget <= 0 {
+		return
+	}
+	for _, message := range cm.history {
+		count, counted := counts[message]
+		if !counted {
+			count = cachedTokens(message)
+		}
+		cm.tokens = append(cm.tokens, count)
+		cm.totalTokens += count
+	}
+	cm.evict()
+}
+
+// historyCopy returns a copy of the history without the summary
+func (cm *ConversationMemory) historyCopy() []*Message {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	history := make([]*Message, len(cm.history))
+	copy(history, cm.history)
+	return history
+}
+
+// TotalTokens returns the number of tokens in the history when token budgeting is enabled
+func (cm *ConversationMemory) TotalTokens() int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.totalTokens
+}
+
+// AddMessage adds a message to the conversation history. When token budgeting is
+// enabled, the message is counted with ctx before the memory is locked and the count
+// is cached on the message. Turns evicted to make room are then folded into the
+// summary with ctx, without holding the lock.
+func (cm *ConversationMemory) AddMessage(ctx context.Context, message *Message) {
+	cm.mu.Lock()
+	budget, counter := cm.tokenBudget, cm.tokenCounter
+	cm.mu.Unlock()
+	if budget > 0 && message.Tokens == 0 {
+		countCtx, cancel := context.WithTimeout(ctx, tokenCountTimeout)
+		message.Tokens = countTokens(countCtx, counter, message)
+		cancel()
+	}
+
+	cm.mu.Lock()
+	evicted := cm.append(message)
+	cm.markDirty()
+	cm.mu.Unlock()
+
+	if evicted {
+		cm.summarize(ctx)
+	}
+}
+
+// append adds a message to the history and evicts the oldest turns when over the
+// limits, reporting whether any were evicted
+func (cm *ConversationMemory) append(message *Message) bool {
 	cm.history = append(cm.history, message)
-	if len(cm.history) > cm.windowSize {
-		cm.history = cm.history[1:]
+	if cm.tokenBudget > 0 {
+		count := cachedTokens(message)
+		cm.tokens = append(cm.tokens, count)
+		cm.totalTokens += count
 	}
+	return cm.evict()
 }
 
-// GetHistory retrieves the conversation history as a slice of messages
+// evict drops whole turns from the start of the history until it fits the window
+// and token budget, queueing them for the summary, and reports whether any were
+// dropped. The most recent turn is always kept, even if it alone is too big.
+func (cm *ConversationMemory) evict() bool {
+	evicted := false
+	for cm.overLimit() {
+		end := cm.nextTurnStart(1)
+		if end < 0 {
+			slog.Warn("Current turn exceeds the memory limits and cannot be evicted", "memory", cm.key)
+			break
+		}
+
+		if cm.summarizer != nil {
+			cm.queueSummary(cm.history[:end])
+		}
+		evicted = true
+		cm.history = cm.history[end:]
+		if cm.tokenBudget > 0 {
+			for _, count := range cm.tokens[:end] {
+				cm.totalTokens -= count
+			}
+			cm.tokens = cm.tokens[end:]
+		}
+	}
+	return evicted
+}
+
+// queueSummary adds evicted messages to those waiting to be summarized, dropping the
+// oldest beyond maxPendingSummaryMessages; the caller must hold cm.mu
+func (cm *ConversationMemory) queueSummary(evicted []*Message) {
+	cm.pendingSummary = append(cm.pendingSummary, evicted...)
+	if excess := len(cm.pendingSummary) - maxPendingSummaryMessages; excess > 0 {
+		cm.pendingSummary = cm.pendingSummary[excess:]
+	}
+}
+
+// summarize folds the evicted messages waiting for it into the running summary. The
+// memory is unlocked while the summarizer runs, so readers don't wait for the model.
+// On failure the messages are kept and retried with the next eviction.
+func (cm *ConversationMemory) summarize(ctx context.Context) {
+	cm.mu.Lock()
+	if cm.summarizer == nil || cm.summarizing || len(cm.pendingSummary) == 0 {
+		cm.mu.Unlock()
+		return
+	}
+	summarizer, summary, pending, generation := cm.summarizer, cm.summary, cm.pendingSummary, cm.generation
+	cm.pendingSummary = nil
+	cm.summarizing = true
+	cm.mu.Unlock()
+
+	summarizeCtx, cancel := context.WithTimeout(ctx, summarizeTimeout)
+	updated, err := summarizer.Summarize(summarizeCtx, summary, pending)
+	cancel()
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.summarizing = false
+	if generation != cm.generation {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error summarizing evicted history", "memory", cm.key, "error", err)
+		evictedSince := cm.pendingSummary
+		cm.pendingSummary = nil
+		cm.queueSummary(pending)
+		cm.queueSummary(evictedSince)
+		return
+	}
+
+	cm.summary = updated
+	cm.markDirty()
+}
+
+// overLimit reports whether the history exceeds the window size or token budget
+func (cm *ConversationMemory) overLimit() bool {
+	if cm.windowSize > 0 && len(cm.history) > cm.windowSize {
+		return true
+	}
+	return cm.tokenBudget > 0 && cm.totalTokens > cm.tokenBudget
+}
+
+// nextTurnStart returns the index of the first message at or after from that starts a turn, or -1
+func (cm *ConversationMemory) nextTurnStart(from int) int {
+	for i := from; i < len(cm.history); i++ {
+		if cm.history[i].StartsTurn() {
+			return i
+		}
+	}
+	return -1
+}
+
+// countTokens counts the tokens of a message with counter, falling back to a local
+// estimate on error
+func countTokens(ctx context.Context, counter TokenCounter, message *Message) int {
+	count, err := counter.CountTokens(ctx, []*Message{message})
+	if err != nil {
+		slog.WarnContext(ctx, "Error counting tokens, using estimate", "error", err)
+		count, _ = NewEstimateTokenCounter().CountTokens(ctx, []*Message{message})
+	}
+	return count
+}
+
+// cachedTokens returns the cached token count of a message, or a local estimate if
+// it hasn't been counted
+func cachedTokens(message *Message) int {
+	if message.Tokens > 0 {
+		return message.Tokens
+	}
+	count, _ := NewEstimateTokenCounter().CountTokens(context.Background(), []*Message{message})
+	return count
+}
+
+// GetHistory retrieves a copy of the conversation history, preceded by the
+// running summary of evicted history when there is one
 func (cm *ConversationMemory) GetHistory() []*Message {
-	return cm.history
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	history := make([]*Message, 0, len(cm.history)+1)
+	if cm.summary != "" {
+		history = append(history, &Message{
+			Timestamp: time.Now(),
+			Role:      "summary",
+			Contents:  []MessageContent{{Type: "text", Content: "Summary of the earlier conversation:\n" + cm.summary}},
+		})
+	}
+	return append(history, cm.history...)
 }
 
-// Clear removes all messages from the conversation history
+// Clear removes all messages from the conversation history and deletes them from
+// the store
 func (cm *ConversationMemory) Clear() {
+	// Wait for a save in progress, so it can't restore the deleted snapshot
+	cm.flushMu.Lock()
+	defer cm.flushMu.Unlock()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 	cm.history = make([]*Message, 0, cm.windowSize)
+	cm.tokens = nil
+	cm.totalTokens = 0
+	cm.summary = ""
+	cm.pendingSummary = nil
+	cm.generation++
+	cm.dirty = false
+	if cm.store != nil {
+		if err := cm.store.Delete(cm.key); err != nil {
+			slog.Error("Error clearing persisted memory", "memory", cm.key, "error", err)
+		}
+	}
+}
+
+// Snapshot returns the serializable state of the memory
+func (cm *ConversationMemory) Snapshot() *MemorySnapshot {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.snapshot()
+}
+
+// snapshot returns the serializable state of the memory; the caller must hold cm.mu
+func (cm *ConversationMemory) snapshot() *MemorySnapshot {
+	messages := make([]*Message, len(cm.history))
+	copy(messages, cm.history)
+	return &MemorySnapshot{Messages: messages, Summary: cm.summary}
+}
+
+// Flush saves the memory to its store if it has one and the memory changed since
+// it was last saved. The snapshot is taken while the memory is locked but saved
+// after, so turns adding messages don't wait for the store.
+func (cm *ConversationMemory) Flush() error {
+	if cm.store == nil {
+		return nil
+	}
+	cm.flushMu.Lock()
+	defer cm.flushMu.Unlock()
+
+	cm.mu.Lock()
+	if !cm.dirty {
+		cm.mu.Unlock()
+		return nil
+	}
+	snapshot := cm.snapshot()
+	cm.dirty = false
+	cm.mu.Unlock()
+
+	if err := cm.store.Save(cm.key, snapshot); err != nil {
+		// Try again with the next flush
+		cm.mu.Lock()
+		cm.dirty = true
+		cm.mu.Unlock()
+		return fmt.Errorf("error persisting memory %s: %w", cm.key, err)
+	}
+	return nil
+}
+
+// markDirty records that the memory must be saved by the next flush; the caller
+// must hold cm.mu
+func (cm *ConversationMemory) markDirty() {
+	if cm.store != nil {
+		cm.dirty = true
+	}
 }
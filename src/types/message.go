@@ -1,7 +1,10 @@
 package types
 
 import (
+	"context"
 	"encoding/base64"
+	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -12,15 +15,27 @@ import (
 // Message represents a single message in the conversation
 // MessageContent represents a single content item (text, image, etc.) in a message
 type MessageContent struct {
-	Type    string `json:"type"` // e.g., "text", "image"
-	Content string `json:"content"`
+	Type             string            `json:"type"` // e.g., "text", "image", "file", "function_call", "function_response"
+	Content          string            `json:"content"`
+	FunctionCall     *FunctionCall     `json:"function_call,omitempty"`
+	FunctionResponse *FunctionResponse `json:"function_response,omitempty"`
+	// UploadedAt is when a "file" content was uploaded to the file API
+	UploadedAt time.Time `json:"uploaded_at,omitempty"`
 }
 
+// FileRetention is how long a file uploaded to the Gemini file API can be
+// referenced. The API deletes files after 48 hours; the margin covers the time
+// between the upload and the request.
+const FileRetention = 47 * time.Hour
+
 // Message represents a single message in the conversation, which can have multiple contents
 type Message struct {
 	Timestamp time.Time        `json:"timestamp"`
 	Role      string           `json:"role"`
 	Contents  []MessageContent `json:"contents"`
+	// Tokens caches the message's size as counted for a memory token budget, so it
+	// is only counted once. Zero means not counted yet.
+	Tokens int `json:"tokens,omitempty"`
 }
 
 // NewMessage creates a new message with the current timestamp and contents
@@ -32,6 +47,39 @@ func NewMessage(role string, contents []MessageContent) *Message {
 	}
 }
 
+// StartsTurn reports whether the message opens a new conversation turn. Model
+// responses and tool results belong to the turn of the user message before them.
+func (m *Message) StartsTurn() bool {
+	switch m.Role {
+	case "AI", "assistant", "tool":
+		return false
+	default:
+		return true
+	}
+}
+
+// FunctionCalls returns the function calls requested in the message
+func (m *Message) FunctionCalls() []*FunctionCall {
+	var calls []*FunctionCall
+	for _, c := range m.Contents {
+		if c.Type == "function_call" && c.FunctionCall != nil {
+			calls = append(calls, c.FunctionCall)
+		}
+	}
+	return calls
+}
+
+// Text returns the concatenated text contents of the message
+func (m *Message) Text() string {
+	var sb strings.Builder
+	for _, c := range m.Contents {
+		if c.Type == "text" {
+			sb.WriteString(c.Content)
+		}
+	}
+	return sb.String()
+}
+
 // ToGenaiContent converts a Message to genai.Content format
 func (m *Message) ToGenaiContent() (*genai.Content, error) {
 	var role genai.Role
@@ -62,6 +110,32 @@ func (m *Message) ToGenaiContent() (*genai.Content, error) {
 				return nil, err // return error if image decode fails
 			}
 			parts = append(parts, genai.NewPartFromBytes(imageBytes, mimeType))
+		case "file":
+			// File contents are stored as "mime,uri" after upload to the file API
+			commaIdx := findMimeComma(c.Content)
+			if commaIdx <= 0 {
+				return nil, fmt.Errorf("file content is missing a MIME type")
+			}
+			if m.fileExpired(c) {
+				// The file is gone, e.g. in history restored from a memory store
+				parts = append(parts, &genai.Part{Text: fmt.Sprintf("[Attachment of type %s has expired]", c.Content[:commaIdx])})
+				continue
+			}
+			parts = append(parts, genai.NewPartFromURI(c.Content[commaIdx+1:], c.Content[:commaIdx]))
+		case "function_call":
+			if c.FunctionCall == nil {
+				continue
+			}
+			part := genai.NewPartFromFunctionCall(c.FunctionCall.Name, c.FunctionCall.Args)
+			part.FunctionCall.ID = c.FunctionCall.ID
+			parts = append(parts, part)
+		case "function_response":
+			if c.FunctionResponse == nil {
+				continue
+			}
+			part := genai.NewPartFromFunctionResponse(c.FunctionResponse.Name, c.FunctionResponse.Response)
+			part.FunctionResponse.ID = c.FunctionResponse.ID
+			parts = append(parts, part)
 		default:
 			parts = append(parts, &genai.Part{Text: c.Content})
 		}
@@ -73,6 +147,16 @@ func (m *Message) ToGenaiContent() (*genai.Content, error) {
 	}, nil
 }
 
+// fileExpired reports whether the file referenced by c has been deleted from the
+// file API. Contents without an upload time fall back to the message's timestamp.
+func (m *Message) fileExpired(c MessageContent) bool {
+	uploadedAt := c.UploadedAt
+	if uploadedAt.IsZero() {
+		uploadedAt = m.Timestamp
+	}
+	return !uploadedAt.IsZero() && time.Since(uploadedAt) > FileRetention
+}
+
 // decodeBase64 decodes a base64 string and returns bytes
 func decodeBase64(data string) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(data)
@@ -101,30 +185,37 @@ func MessagesToGenaiContent(messages []*Message) ([]*genai.Content, error) {
 	return contents, nil
 }
 
-// DiscordMessageToMessage converts a Discord message to our Message type
-func DiscordMessageToMessage(s *discordgo.Session, m *discordgo.MessageCreate, supportedImageTypes []string) *Message {
+// AttachmentLoader converts a Discord attachment into message content
+type AttachmentLoader interface {
+	LoadAttachment(ctx context.Context, attachment *discordgo.MessageAttachment) (MessageContent, error)
+}
+
+// DiscordMessageToMessage converts a Discord message to our Message type, loading
+// attachments with loader. Attachments that cannot be loaded are replaced by a note
+// so the model can tell the user what happened.
+func DiscordMessageToMessage(ctx context.Context, botUserID string, m *discordgo.MessageCreate, loader AttachmentLoader) *Message {
 	var contents []MessageContent
 
 	// Remove bot mention from content
 	cleanedContent := m.Content
-	mentionString := "<@" + s.State.User.ID + ">"
+	mentionString := "<@" + botUserID + ">"
 	cleanedContent = strings.ReplaceAll(cleanedContent, mentionString, "")
 	cleanedContent = strings.TrimSpace(cleanedContent)
 	if cleanedContent != "" {
 		contents = append(contents, MessageContent{Type: "text", Content: cleanedContent})
 	}
 
-	// Process images
-	for _, attachment := range m.Attachments {
-		if attachment.ContentType != "" {
-			for _, supportedType := range supportedImageTypes {
-				if attachment.ContentType == supportedType {
-					// Assume image is already base64 encoded elsewhere, or fetch here if needed
-					// For now, just store the URL as content (can be replaced with base64 fetch)
-					contents = append(contents, MessageContent{Type: "image", Content: attachment.URL})
-					break
-				}
+	// Process attachments
+	if loader != nil {
+		for _, attachment := range m.Attachments {
+			content, err := loader.LoadAttachment(ctx, attachment)
+			if err != nil {
+				slog.WarnContext(ctx, "Skipping attachment", "attachment", attachment.Filename, "error", err)
+				note := fmt.Sprintf("[Attachment %s could not be processed: %v]", attachment.Filename, err)
+				contents = append(contents, MessageContent{Type: "text", Content: note})
+				continue
 			}
+			contents = append(contents, content)
 		}
 	}
 
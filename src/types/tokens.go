@@ -0,0 +1,64 @@
+package types
+
+import (
+	"context"
+	"encoding/json"
+	"unicode/utf8"
+)
+
+const (
+	// charsPerToken is the average number of characters per token used for estimates
+	charsPerToken = 4
+	// imageTokens is the fixed token cost Gemini charges for an inline image
+	imageTokens = 258
+	// messageOverheadTokens approximates the per-message role and framing tokens
+	messageOverheadTokens = 4
+)
+
+// TokenCounter counts the tokens messages use in a model's context window
+type TokenCounter interface {
+	CountTokens(ctx context.Context, messages []*Message) (int, error)
+}
+
+// EstimateTokenCounter estimates token usage locally without calling a model
+type EstimateTokenCounter struct{}
+
+// NewEstimateTokenCounter creates a new local token estimator
+func NewEstimateTokenCounter() *EstimateTokenCounter {
+	return &EstimateTokenCounter{}
+}
+
+// CountTokens estimates the number of tokens used by messages
+func (e *EstimateTokenCounter) CountTokens(ctx context.Context, messages []*Message) (int, error) {
+	total := 0
+	for _, msg := range messages {
+		total += messageOverheadTokens
+		for _, c := range msg.Contents {
+			switch c.Type {
+			case "image", "file":
+				total += imageTokens
+			case "function_call":
+				total += estimateJSONTokens(c.FunctionCall)
+			case "function_response":
+				total += estimateJSONTokens(c.FunctionResponse)
+			default:
+				total += estimateTextTokens(c.Content)
+			}
+		}
+	}
+	return total, nil
+}
+
+// estimateTextTokens estimates the tokens in a piece of text
+func estimateTextTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+// estimateJSONTokens estimates the tokens in the JSON encoding of v
+func estimateJSONTokens(v interface{}) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return estimateTextTokens(string(data))
+}
@@ -1,17 +1,206 @@
 package utils
 
-// SplitLongText splits a long string into chunks of maxLength
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// fenceClose closes a code block that is still open at the end of a chunk
+const fenceClose = "\n```"
+
+// tokenRegex matches text that must never be split across messages: user, role and
+// channel mentions, custom emoji, timestamps, slash command mentions and code fence markers
+var tokenRegex = regexp.MustCompile("<(?:@[!&]?\\d+|#\\d+|a?:\\w+:\\d+|t:-?\\d+(?::[tTdDfFR])?|/[\\w -]+:\\d+)>|`{3}[\\w+#.-]*")
+
+// SplitLongText splits a long string into chunks of at most maxLength characters.
+// Chunks end at the last paragraph, line, sentence or word boundary that fits, in
+// that order of preference, and never inside a rune, a Discord mention or emoji,
+// or an emoji sequence. A code block that spans chunks is closed at the end of each
+// chunk and reopened with its language tag at the start of the next one, unless
+// the tag would take up more than half of the chunk.
 func SplitLongText(text string, maxLength int) []string {
 	if maxLength <= 0 {
 		return []string{text}
 	}
+
+	reserve := 0
+	if strings.Contains(text, "```") {
+		reserve = utf8.RuneCountInString(fenceClose)
+	}
+
 	var chunks []string
-	for len(text) > maxLength {
-		chunks = append(chunks, text[:maxLength])
-		text = text[maxLength:]
+	inFence, lang := false, ""
+	for {
+		prefix := ""
+		if inFence {
+			prefix = "```" + lang + "\n"
+			// Drop a language tag too long to leave room for the code
+			if utf8.RuneCountInString(prefix)+reserve > maxLength/2 {
+				prefix = "```\n"
+			}
+		}
+		prefixLength := utf8.RuneCountInString(prefix)
+
+		if prefixLength+utf8.RuneCountInString(text) <= maxLength {
+			if strings.TrimSpace(text) != "" {
+				chunks = append(chunks, prefix+text)
+			}
+			return chunks
+		}
+
+		budget := max(maxLength-prefixLength-reserve, 1)
+		end, next := findCut(text, budget)
+		chunk := strings.TrimRight(text[:end], " \t\r\n")
+		text = text[next:]
+		if chunk == "" {
+			continue
+		}
+
+		inFence, lang = scanFences(chunk, inFence, lang)
+		if inFence {
+			chunk += fenceClose
+		}
+		chunks = append(chunks, prefix+chunk)
+	}
+}
+
+// findCut returns where the chunk taken from the start of text should end, at most
+// budget runes in, and where the remaining text starts
+func findCut(text string, budget int) (int, int) {
+	limit := runeOffset(text, budget)
+	tokens := tokenRegex.FindAllStringIndex(text, -1)
+	allowed := func(pos int) bool {
+		return pos > 0 && !insideToken(tokens, pos) && !insideEmojiSequence(text, pos)
+	}
+
+	// Prefer a natural boundary in the second half of the window over a cramped chunk
+	half := limit / 2
+	if i := lastCut(text[:limit], "\n\n", half, allowed); i >= 0 {
+		return i, skip(text, i, "\r\n")
+	}
+	if i := lastCut(text[:limit], "\n", half, allowed); i >= 0 {
+		return i, skip(text, i, "\r\n")
+	}
+	for j := limit - 1; j >= half && j+1 < len(text); j-- {
+		if strings.IndexByte(".!?", text[j]) >= 0 && (text[j+1] == ' ' || text[j+1] == '\n') && allowed(j+1) {
+			return j + 1, skip(text, j+1, " \t\r\n")
+		}
+	}
+	if i := lastCut(text[:limit], " ", 0, allowed); i >= 0 {
+		return i, skip(text, i, " \t")
+	}
+
+	// No boundary fits, so cut as late as possible without breaking a token or emoji
+	for pos := limit; pos > 0; {
+		if allowed(pos) {
+			return pos, pos
+		}
+		_, size := utf8.DecodeLastRuneInString(text[:pos])
+		pos -= size
+	}
+	return limit, limit
+}
+
+// lastCut returns the last index of sep in window that is at least from and where a cut is allowed, or -1
+func lastCut(window, sep string, from int, allowed func(int) bool) int {
+	for end := len(window); end > 0; {
+		i := strings.LastIndex(window[:end], sep)
+		if i < from {
+			return -1
+		}
+		if allowed(i) {
+			return i
+		}
+		end = i
+	}
+	return -1
+}
+
+// skip returns the index of the first byte at or after pos that is not in chars
+func skip(text string, pos int, chars string) int {
+	for pos < len(text) && strings.IndexByte(chars, text[pos]) >= 0 {
+		pos++
 	}
-	if len(text) > 0 {
-		chunks = append(chunks, text)
+	return pos
+}
+
+// runeOffset returns the byte offset just after the first n runes of text
+func runeOffset(text string, n int) int {
+	for i := range text {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(text)
+}
+
+// insideToken reports whether pos falls strictly inside one of the token spans
+func insideToken(tokens [][]int, pos int) bool {
+	for _, span := range tokens {
+		if span[0] < pos && pos < span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// insideEmojiSequence reports whether cutting at pos would separate the parts of an
+// emoji sequence, such as a joined family emoji, a skin tone or a keycap
+func insideEmojiSequence(text string, pos int) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:pos])
+	after, _ := utf8.DecodeRuneInString(text[pos:])
+	if before == '\u200d' || after == '\u200d' {
+		return true
+	}
+	if unicode.In(after, unicode.Mn, unicode.Me) || (after >= 0x1F3FB && after <= 0x1F3FF) || (after >= 0xE0020 && after <= 0xE007F) {
+		return true
+	}
+	// Flags are pairs of regional indicators, so only cut after an even number of them
+	if !isRegionalIndicator(after) {
+		return false
+	}
+	count := 0
+	for end := pos; end > 0; count++ {
+		r, size := utf8.DecodeLastRuneInString(text[:end])
+		if !isRegionalIndicator(r) {
+			break
+		}
+		end -= size
+	}
+	return count%2 == 1
+}
+
+// isRegionalIndicator reports whether r is a regional indicator symbol
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// scanFences tracks whether a code block is open after chunk, given the state
+// before it, and returns the language tag of the open block
+func scanFences(chunk string, inFence bool, lang string) (bool, string) {
+	for _, line := range strings.Split(chunk, "\n") {
+		line = strings.TrimLeft(line, " \t")
+		if !strings.HasPrefix(line, "```") {
+			continue
+		}
+		if inFence {
+			inFence, lang = false, ""
+			continue
+		}
+
+		rest := line[3:]
+		// A fence opened and closed on the same line is inline code
+		if strings.Contains(rest, "```") {
+			continue
+		}
+		inFence = true
+		lang = ""
+		if fields := strings.Fields(rest); len(fields) > 0 {
+			lang = fields[0]
+		}
 	}
-	return chunks
+	return inFence, lang
 }
@@ -0,0 +1,397 @@
+package tests
+
+import (
+	"context"
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/testutil"
+	"discord-gemini-bot/src/tools"
+	"discord-gemini-bot/src/types"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// scriptedModel returns canned responses in order and records the history it was given
+type scriptedModel struct {
+	responses []string
+	calls     int
+	histories [][]*types.Message
+	prompts   []string
+	options   []*models.GenerateOptions
+}
+
+func (m *scriptedModel) GenerateAsync(ctx context.Context, prompt string, images []map[string]interface{}) (string, error) {
+	m.prompts = append(m.prompts, prompt)
+	return m.next()
+}
+
+func (m *scriptedModel) GenerateWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *models.GenerateOptions) (*models.Response, error) {
+	m.histories = append(m.histories, messages)
+	m.options = append(m.options, opts)
+	text, err := m.next()
+	if err != nil {
+		return nil, err
+	}
+	return &models.Response{Text: text, FinishReason: models.FinishReasonStop}, nil
+}
+
+// StreamWithHistoryAsync streams the next canned response a few characters at a time
+func (m *scriptedModel) StreamWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *models.GenerateOptions, onChunk func(chunk string) error) (*models.Response, error) {
+	response, err := m.GenerateWithHistoryAsync(ctx, messages, opts)
+	if err != nil {
+		return nil, err
+	}
+	for rest := response.Text; rest != ""; {
+		n := min(4, len(rest))
+		if err := onChunk(rest[:n]); err != nil {
+			return nil, err
+		}
+		rest = rest[n:]
+	}
+	return response, nil
+}
+
+func (m *scriptedModel) Name() string { return "scripted" }
+
+func (m *scriptedModel) SetSystemPrompt(systemPrompt string) {
+	panic("agents must not mutate the shared model's system prompt")
+}
+
+func (m *scriptedModel) next() (string, error) {
+	if m.calls >= len(m.responses) {
+		return "", fmt.Errorf("no scripted response left")
+	}
+	m.calls++
+	return m.responses[m.calls-1], nil
+}
+
+// scriptedFunctionModel returns canned messages in order for native function calling
+type scriptedFunctionModel struct {
+	scriptedModel
+	messages  []*types.Message
+	functions []*types.FunctionDeclaration
+}
+
+func (m *scriptedFunctionModel) SupportsFunctionCalling() bool {
+	return true
+}
+
+func (m *scriptedFunctionModel) GenerateWithFunctionsAsync(ctx context.Context, messages []*types.Message, functions []*types.FunctionDeclaration, opts *models.GenerateOptions) (*models.Response, error) {
+	m.histories = append(m.histories, messages)
+	m.options = append(m.options, opts)
+	m.functions = functions
+	if m.calls >= len(m.messages) {
+		return nil, fmt.Errorf("no scripted message left")
+	}
+	m.calls++
+	message := m.messages[m.calls-1]
+	return &models.Response{Text: message.Text(), Message: message, FinishReason: models.FinishReasonStop}, nil
+}
+
+// echoTool returns its input prefixed with the tool name
+type echoTool struct {
+	*tools.BaseTool
+	inputs []string
+}
+
+func newEchoTool(name string) *echoTool {
+	return &echoTool{BaseTool: tools.NewBaseTool(name, "Echoes the input.")}
+}
+
+func (et *echoTool) ARun(ctx context.Context, args ...interface{}) (*tools.ToolResult, error) {
+	input, _ := tools.StringArg(args, "input")
+	et.inputs = append(et.inputs, input)
+	return &tools.ToolResult{ReturnDisplay: et.Name() + ":" + input}, nil
+}
+
+func TestAgentMultiStepLoop(t *testing.T) {
+	search := newEchoTool("google_search")
+	fetch := newEchoTool("url_fetch")
+	model := &scriptedModel{responses: []string{
+		"Thought: Do I need to use a tool? Yes\nAction: google_search\nAction Input: go release notes",
+		"Thought: Do I need to use a tool? Yes\nAction: url_fetch\nAction Input: https://go.dev/doc",
+		"Thought: Do I need to use a tool? No\nFinal Answer: Go 1.23 is out.",
+	}}
+
+	memory := types.NewConversationMemory(20)
+	ag := agent.NewAgent(model, memory, []tools.Tool{search, fetch})
+	ag.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "search then open"}}))
+
+	result, err := ag.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	if !result.Completed {
+		t.Error("Expected the run to complete")
+	}
+	if len(result.Steps) != 3 {
+		t.Fatalf("Expected 3 steps, got %d", len(result.Steps))
+	}
+	if result.Steps[0].Action != "google_search" || result.Steps[0].ActionInput != "go release notes" {
+		t.Errorf("Unexpected first step: %+v", result.Steps[0])
+	}
+	if result.Steps[1].Observation != "Tool url_fetch used. Observation: url_fetch:https://go.dev/doc" {
+		t.Errorf("Unexpected second observation: %q", result.Steps[1].Observation)
+	}
+	if result.Response != "Go 1.23 is out." {
+		t.Errorf("Unexpected response: %q", result.Response)
+	}
+	if len(search.inputs) != 1 || len(fetch.inputs) != 1 {
+		t.Errorf("Expected each tool to run once, got %v and %v", search.inputs, fetch.inputs)
+	}
+
+	// The last model call must see both observations
+	last := model.histories[len(model.histories)-1]
+	if len(last) != 5 {
+		t.Errorf("Expected 5 messages in the final history, got %d", len(last))
+	}
+}
+
+func TestAgentReportsFinishReasonAndUsage(t *testing.T) {
+	blockedRating := models.SafetyRating{Category: "HARM_CATEGORY_HARASSMENT", Probability: "HIGH", Blocked: true}
+	tests := []struct {
+		name      string
+		responses []*models.Response
+		want      string
+		// wantMemory is the number of messages in memory after the run, including the question
+		wantMemory int
+	}{
+		{
+			name: "usage adds up over steps",
+			responses: []*models.Response{
+				{Text: "Action: echo\nAction Input: hi", FinishReason: models.FinishReasonStop, Usage: models.Usage{PromptTokens: 10, OutputTokens: 5, TotalTokens: 15}},
+				{Text: "Final Answer: done", FinishReason: models.FinishReasonStop, Usage: models.Usage{PromptTokens: 20, OutputTokens: 2, CachedTokens: 8, TotalTokens: 22}},
+			},
+			want:       "done",
+			wantMemory: 4,
+		},
+		{
+			name:       "response blocked",
+			responses:  []*models.Response{{FinishReason: models.FinishReasonSafety, SafetyRatings: []models.SafetyRating{blockedRating}}},
+			want:       agent.BlockedReply,
+			wantMemory: 1,
+		},
+		{
+			name:       "prompt blocked",
+			responses:  []*models.Response{{FinishReason: models.FinishReasonPromptBlocked, BlockReason: "OTHER"}},
+			want:       agent.BlockedReply,
+			wantMemory: 1,
+		},
+		{
+			name:       "truncated",
+			responses:  []*models.Response{{Text: "Final Answer: The first primes are 2, 3, 5, 7, 11, 13", FinishReason: models.FinishReasonMaxTokens}},
+			want:       "The first primes are 2, 3, 5, 7, 11, 13" + agent.TruncatedNotice,
+			wantMemory: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := testutil.NewFakeModelResponses(tt.responses...)
+			memory := types.NewConversationMemory(20)
+			ag := agent.NewAgent(model, memory, []tools.Tool{testutil.NewEchoTool("echo")})
+			ag.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "question"}}))
+
+			result, err := ag.Run(context.Background())
+			if err != nil {
+				t.Fatalf("Run returned error: %v", err)
+			}
+			if result.Response != tt.want {
+				t.Errorf("response = %q, want %q", result.Response, tt.want)
+			}
+
+			last := tt.responses[len(tt.responses)-1]
+			if result.FinishReason != last.FinishReason {
+				t.Errorf("finish reason = %q, want %q", result.FinishReason, last.FinishReason)
+			}
+			var wantUsage models.Usage
+			for _, response := range tt.responses {
+				wantUsage = wantUsage.Add(response.Usage)
+			}
+			if tt.responses[0].Usage != (models.Usage{}) && result.Usage != wantUsage {
+				t.Errorf("usage = %+v, want %+v", result.Usage, wantUsage)
+			}
+			if got := len(memory.GetHistory()); got != tt.wantMemory {
+				t.Errorf("memory holds %d messages, want %d", got, tt.wantMemory)
+			}
+		})
+	}
+}
+
+func TestAgentStopsAtMaxSteps(t *testing.T) {
+	model := &scriptedModel{responses: []string{
+		"Action: google_search\nAction Input: one",
+		"Action: google_search\nAction Input: two",
+		"Action: google_search\nAction Input: three",
+	}}
+
+	opts := agent.DefaultOptions()
+	opts.MaxSteps = 2
+	ag := agent.NewAgentWithOptions(model, types.NewConversationMemory(20), []tools.Tool{newEchoTool("google_search")}, opts)
+	ag.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "loop forever"}}))
+
+	result, err := ag.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if result.Completed {
+		t.Error("Expected the run to be incomplete")
+	}
+	if model.calls != 2 {
+		t.Errorf("Expected 2 model calls, got %d", model.calls)
+	}
+	if strings.Contains(result.Response, "Action:") {
+		t.Errorf("Response leaked a raw action: %q", result.Response)
+	}
+}
+
+func TestAgentUnknownToolIsReported(t *testing.T) {
+	model := &scriptedModel{responses: []string{
+		"Action: missing_tool\nAction Input: x",
+		"Final Answer: done",
+	}}
+
+	ag := agent.NewAgent(model, types.NewConversationMemory(20), nil)
+	ag.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "hi"}}))
+
+	result, err := ag.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if !strings.Contains(result.Steps[0].Observation, "does not exist") {
+		t.Errorf("Expected unknown tool observation, got %q", result.Steps[0].Observation)
+	}
+}
+
+// nilTool returns neither a result nor an error
+type nilTool struct {
+	*tools.BaseTool
+}
+
+func (nt *nilTool) ARun(ctx context.Context, args ...interface{}) (*tools.ToolResult, error) {
+	return nil, nil
+}
+
+func TestAgentToolWithoutResultIsReported(t *testing.T) {
+	broken := &nilTool{BaseTool: tools.NewBaseTool("broken", "Returns nothing.")}
+
+	model := &scriptedModel{responses: []string{
+		"Action: broken\nAction Input: x",
+		"Final Answer: done",
+	}}
+	ag := agent.NewAgent(model, types.NewConversationMemory(20), []tools.Tool{broken})
+	ag.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "hi"}}))
+
+	result, err := ag.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if !strings.Contains(result.Steps[0].Observation, "Tool broken failed: tool broken returned no result") {
+		t.Errorf("Expected a failure observation, got %q", result.Steps[0].Observation)
+	}
+
+	functionModel := &scriptedFunctionModel{messages: []*types.Message{
+		types.NewMessage("AI", []types.MessageContent{{
+			Type:         "function_call",
+			FunctionCall: &types.FunctionCall{ID: "call-1", Name: "broken", Args: map[string]interface{}{"input": "x"}},
+		}}),
+		types.NewMessage("AI", []types.MessageContent{{Type: "text", Content: "done"}}),
+	}}
+	opts := agent.DefaultOptions()
+	opts.NativeFunctionCalling = true
+	ag = agent.NewAgentWithOptions(functionModel, types.NewConversationMemory(20), []tools.Tool{broken}, opts)
+	ag.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "hi"}}))
+
+	result, err = ag.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if !strings.HasPrefix(result.Steps[0].Observation, "Error: tool broken returned no result") {
+		t.Errorf("Expected a failure observation, got %q", result.Steps[0].Observation)
+	}
+}
+
+func TestAgentNativeFunctionCalling(t *testing.T) {
+	search := newEchoTool("google_search")
+	model := &scriptedFunctionModel{messages: []*types.Message{
+		types.NewMessage("AI", []types.MessageContent{{
+			Type:         "function_call",
+			FunctionCall: &types.FunctionCall{ID: "call-1", Name: "google_search", Args: map[string]interface{}{"input": "multi\nline query"}},
+		}}),
+		types.NewMessage("AI", []types.MessageContent{{Type: "text", Content: "Here is what I found."}}),
+	}}
+
+	opts := agent.DefaultOptions()
+	opts.NativeFunctionCalling = true
+	memory := types.NewConversationMemory(20)
+	ag := agent.NewAgentWithOptions(model, memory, []tools.Tool{search}, opts)
+	ag.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "look it up"}}))
+
+	result, err := ag.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if result.Response != "Here is what I found." {
+		t.Errorf("Unexpected response: %q", result.Response)
+	}
+	if len(model.functions) != 1 || model.functions[0].Name != "google_search" || model.functions[0].Parameters == nil {
+		t.Errorf("Expected google_search to be declared with parameters, got %+v", model.functions)
+	}
+	if len(search.inputs) != 1 || search.inputs[0] != "multi\nline query" {
+		t.Errorf("Expected multi-line input to reach the tool, got %v", search.inputs)
+	}
+
+	// The function result must be returned as a function response, not an AI message
+	history := memory.GetHistory()
+	toolMsg := history[2]
+	if toolMsg.Role != "tool" || toolMsg.Contents[0].FunctionResponse == nil {
+		t.Fatalf("Expected a function response message, got %+v", toolMsg)
+	}
+	if toolMsg.Contents[0].FunctionResponse.ID != "call-1" {
+		t.Errorf("Function response ID mismatch: %q", toolMsg.Contents[0].FunctionResponse.ID)
+	}
+
+	content, err := toolMsg.ToGenaiContent()
+	if err != nil {
+		t.Fatalf("ToGenaiContent failed: %v", err)
+	}
+	if content.Parts[0].FunctionResponse == nil || content.Parts[0].FunctionResponse.Name != "google_search" {
+		t.Errorf("Expected a genai function response part, got %+v", content.Parts[0])
+	}
+}
+
+func TestAgentsSharingModelKeepOwnSystemPrompts(t *testing.T) {
+	model := &scriptedModel{responses: []string{"Final Answer: arr", "Final Answer: good day"}}
+	temperature := float32(0.2)
+
+	pirateOpts := agent.DefaultOptions()
+	pirateOpts.Persona = "You are a pirate.\n"
+	pirateOpts.Generation.Temperature = &temperature
+	pirate := agent.NewAgentWithOptions(model, types.NewConversationMemory(20), nil, pirateOpts)
+
+	butlerOpts := agent.DefaultOptions()
+	butlerOpts.Persona = "You are a butler.\n"
+	butler := agent.NewAgentWithOptions(model, types.NewConversationMemory(20), nil, butlerOpts)
+
+	for _, ag := range []*agent.Agent{pirate, butler} {
+		ag.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "hello"}}))
+		if _, err := ag.GetResponse(context.Background()); err != nil {
+			t.Fatalf("GetResponse failed: %v", err)
+		}
+	}
+
+	if !strings.HasPrefix(model.options[0].SystemPrompt, "You are a pirate.") || model.options[0].Temperature == nil || *model.options[0].Temperature != 0.2 {
+		t.Errorf("Pirate request used the wrong options: %+v", model.options[0])
+	}
+	if !strings.HasPrefix(model.options[1].SystemPrompt, "You are a butler.") || model.options[1].Temperature != nil {
+		t.Errorf("Butler request used the wrong options: %+v", model.options[1])
+	}
+	if !strings.HasPrefix(pirate.SystemPrompt(), "You are a pirate.\n\n") || strings.HasPrefix(pirate.SystemPrompt(), "You are a pirate.\n\n\n") {
+		t.Errorf("Persona must be followed by one blank line, got %q", pirate.SystemPrompt())
+	}
+	if !strings.Contains(pirate.SystemPrompt(), "Final Answer:") {
+		t.Errorf("Persona must be combined with the tools prompt, got %q", pirate.SystemPrompt())
+	}
+}
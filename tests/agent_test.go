@@ -20,15 +20,15 @@ func TestAgentResponse(t *testing.T) {
 		}
 	})
 
+	apiKey := os.Getenv("GEMINI_API_KEY")
+	if apiKey == "" {
+		t.Skip("GEMINI_API_KEY not set, skipping integration test")
+	}
+
 	var geminiModel *models.Gemini
 	var ag *agent.Agent
 
 	t.Run("CreateModelAndAgent", func(t *testing.T) {
-		apiKey := os.Getenv("GEMINI_API_KEY")
-		if apiKey == "" {
-			t.Fatal("GEMINI_API_KEY environment variable is required")
-		}
-
 		var err error
 		geminiModel, err = models.NewGemini(apiKey, "gemini-2.0-flash-exp")
 		if err != nil {
@@ -48,7 +48,7 @@ func TestAgentResponse(t *testing.T) {
 		defer cancel()
 
 		userMsg := types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "Summarize the plot of Hamlet."}})
-		ag.AddMessage(userMsg)
+		ag.AddMessage(context.Background(), userMsg)
 
 		t.Log("Testing agent response:")
 		response, err := ag.GetResponse(ctx)
@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"discord-gemini-bot/src/attachments"
+	"discord-gemini-bot/src/types"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var testImageTypes = []string{"image/png", "image/jpeg", "image/webp", "image/gif"}
+
+// testPNG returns the bytes of a small PNG image
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// fakeUploader records uploads and returns a fixed URI
+type fakeUploader struct {
+	uploads int
+	mime    string
+}
+
+func (fu *fakeUploader) UploadFile(ctx context.Context, data []byte, mimeType string) (string, error) {
+	fu.uploads++
+	fu.mime = mimeType
+	return "https://files.example/abc", nil
+}
+
+func newAttachmentServer(t *testing.T) *httptest.Server {
+	pngData := testPNG(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(pngData)
+	})
+	mux.HandleFunc("/fake.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("<html><body>not an image</body></html>"))
+	})
+	mux.HandleFunc("/huge.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pngData)
+		w.Write(make([]byte, 4096))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestIngestorInlinesImage(t *testing.T) {
+	server := newAttachmentServer(t)
+	ingestor := attachments.NewIngestor(server.Client(), testImageTypes)
+
+	content, err := ingestor.Load(context.Background(), server.URL+"/image.png")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if content.Type != "image" || !strings.HasPrefix(content.Content, "image/png,") {
+		t.Fatalf("Unexpected content: %s %.30s", content.Type, content.Content)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(content.Content, "image/png,"))
+	if err != nil || !bytes.Equal(decoded, testPNG(t)) {
+		t.Errorf("Inline data does not round-trip: %v", err)
+	}
+
+	// The content must convert to an inline genai part instead of failing to decode
+	genaiContent, err := (&types.Message{Role: "user", Contents: []types.MessageContent{content}}).ToGenaiContent()
+	if err != nil {
+		t.Fatalf("ToGenaiContent failed: %v", err)
+	}
+	if genaiContent.Parts[0].InlineData == nil || genaiContent.Parts[0].InlineData.MIMEType != "image/png" {
+		t.Errorf("Expected an inline PNG part, got %+v", genaiContent.Parts[0])
+	}
+}
+
+func TestIngestorRejectsSpoofedType(t *testing.T) {
+	server := newAttachmentServer(t)
+	ingestor := attachments.NewIngestor(server.Client(), testImageTypes)
+
+	_, err := ingestor.LoadAttachment(context.Background(), &discordgo.MessageAttachment{
+		URL:         server.URL + "/fake.png",
+		ContentType: "image/png",
+	})
+	if !errors.Is(err, attachments.ErrUnsupportedType) {
+		t.Errorf("Expected ErrUnsupportedType, got %v", err)
+	}
+}
+
+func TestIngestorEnforcesSizeCap(t *testing.T) {
+	server := newAttachmentServer(t)
+	ingestor := attachments.NewIngestor(server.Client(), testImageTypes)
+	ingestor.SetMaxBytes(1024)
+
+	if _, err := ingestor.Load(context.Background(), server.URL+"/huge.png"); !errors.Is(err, attachments.ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge from download, got %v", err)
+	}
+
+	// Reported sizes are rejected without downloading
+	_, err := ingestor.LoadAttachment(context.Background(), &discordgo.MessageAttachment{
+		URL:  "http://invalid.invalid/never-fetched",
+		Size: 2048,
+	})
+	if !errors.Is(err, attachments.ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge from metadata, got %v", err)
+	}
+}
+
+func TestIngestorUploadsAboveInlineLimit(t *testing.T) {
+	server := newAttachmentServer(t)
+	ingestor := attachments.NewIngestor(server.Client(), testImageTypes)
+	ingestor.SetInlineLimit(16)
+
+	if _, err := ingestor.Load(context.Background(), server.URL+"/image.png"); !errors.Is(err, attachments.ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge without an uploader, got %v", err)
+	}
+
+	uploader := &fakeUploader{}
+	ingestor.SetUploader(uploader)
+	content, err := ingestor.Load(context.Background(), server.URL+"/image.png")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if content.Type != "file" || content.Content != "image/png,https://files.example/abc" {
+		t.Errorf("Unexpected content: %+v", content)
+	}
+	if uploader.uploads != 1 || uploader.mime != "image/png" {
+		t.Errorf("Unexpected uploads: %+v", uploader)
+	}
+
+	genaiContent, err := (&types.Message{Role: "user", Contents: []types.MessageContent{content}}).ToGenaiContent()
+	if err != nil {
+		t.Fatalf("ToGenaiContent failed: %v", err)
+	}
+	if genaiContent.Parts[0].FileData == nil || genaiContent.Parts[0].FileData.FileURI != "https://files.example/abc" {
+		t.Errorf("Expected a file data part, got %+v", genaiContent.Parts[0])
+	}
+	if content.UploadedAt.IsZero() {
+		t.Error("Expected the upload time to be recorded")
+	}
+}
+
+func TestExpiredFileReference(t *testing.T) {
+	expired := time.Now().Add(-types.FileRetention - time.Minute)
+	for name, msg := range map[string]*types.Message{
+		"upload time":  {Role: "user", Contents: []types.MessageContent{{Type: "file", Content: "image/png,https://files.example/abc", UploadedAt: expired}}},
+		"message time": {Role: "user", Timestamp: expired, Contents: []types.MessageContent{{Type: "file", Content: "image/png,https://files.example/abc"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			genaiContent, err := msg.ToGenaiContent()
+			if err != nil {
+				t.Fatalf("ToGenaiContent failed: %v", err)
+			}
+			part := genaiContent.Parts[0]
+			if part.FileData != nil || !strings.Contains(part.Text, "image/png") || !strings.Contains(part.Text, "expired") {
+				t.Errorf("Expected an expired file to become a text note, got %+v", part)
+			}
+		})
+	}
+}
+
+func TestIngestorHTTPError(t *testing.T) {
+	server := newAttachmentServer(t)
+	ingestor := attachments.NewIngestor(server.Client(), testImageTypes)
+
+	if _, err := ingestor.Load(context.Background(), server.URL+"/missing.png"); err == nil || !strings.Contains(err.Error(), "HTTP 404") {
+		t.Errorf("Expected HTTP 404 error, got %v", err)
+	}
+}
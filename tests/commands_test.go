@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"discord-gemini-bot/src/discordbot"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func commandInteraction(name string) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Type: discordgo.InteractionApplicationCommand,
+		Data: discordgo.ApplicationCommandInteractionData{Name: name},
+	}}
+}
+
+func TestCommandRouterDispatch(t *testing.T) {
+	router := discordbot.NewCommandRouter()
+	var handled []string
+	for _, name := range []string{"ask", "reset", "tools"} {
+		name := name
+		router.Add(&discordbot.Command{
+			Definition: &discordgo.ApplicationCommand{Name: name, Description: name},
+			Handler: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+				handled = append(handled, name)
+			},
+		})
+	}
+
+	router.HandleInteraction(nil, commandInteraction("reset"))
+	router.HandleInteraction(nil, commandInteraction("unknown"))
+	router.HandleInteraction(nil, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{Type: discordgo.InteractionPing}})
+	router.HandleInteraction(nil, commandInteraction("ask"))
+
+	if len(handled) != 2 || handled[0] != "reset" || handled[1] != "ask" {
+		t.Errorf("Unexpected dispatches: %v", handled)
+	}
+
+	definitions := router.Definitions()
+	if len(definitions) != 3 || definitions[0].Name != "ask" || definitions[2].Name != "tools" {
+		t.Errorf("Definitions not in registration order: %v", definitions)
+	}
+}
+
+func TestInteractionUser(t *testing.T) {
+	guildUser := &discordgo.User{ID: "1", Username: "guild"}
+	dmUser := &discordgo.User{ID: "2", Username: "dm"}
+
+	inGuild := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{Member: &discordgo.Member{User: guildUser}}}
+	inDM := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{User: dmUser}}
+
+	if discordbot.InteractionUser(inGuild) != guildUser || discordbot.InteractionUser(inDM) != dmUser {
+		t.Error("InteractionUser returned the wrong user")
+	}
+}
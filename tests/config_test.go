@@ -0,0 +1,278 @@
+package tests
+
+import (
+	"discord-gemini-bot/src/config"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configEnv lists the environment variables read by config.Load
+var configEnv = []string{
+	"DISCORD_BOT_TOKEN", "DISCORD_COMMAND_GUILD_ID", "STREAM_RESPONSES", "TURN_TIMEOUT", "SHUTDOWN_TIMEOUT",
+	"MODEL_PROVIDER", "MODEL_NAME", "MODEL_BASE_URL", "MODEL_API_KEY", "MODEL_FALLBACKS", "MODEL_MAX_ATTEMPTS",
+	"MODEL_TEMPERATURE", "MODEL_TOP_P", "MODEL_TOP_K", "MODEL_MAX_OUTPUT_TOKENS", "MODEL_CANDIDATE_COUNT",
+	"MODEL_STOP_SEQUENCES", "MODEL_SAFETY_SETTINGS", "GEMINI_API_KEY",
+	"NATIVE_FUNCTION_CALLING", "DEBUG_TRANSCRIPT", "GOOGLE_API_KEY", "GOOGLE_CSE_ID",
+	"MEMORY_WINDOW_SIZE", "MEMORY_STORE", "MEMORY_STORE_PATH", "MEMORY_TOKEN_BUDGET", "MEMORY_TOKEN_COUNTER", "MEMORY_SUMMARIZE",
+	"CONFIG_WATCH", "MONITORING_ADDR", "LOG_LEVEL", "LOG_FORMAT", "LOG_REDACT_MESSAGES",
+	"TRACING_EXPORTER", "TRACING_ENDPOINT", "TRACING_INSECURE", "TRACING_SAMPLE_RATIO",
+	"LIMITS_USER_PER_MINUTE", "LIMITS_CHANNEL_PER_MINUTE", "LIMITS_GUILD_PER_MINUTE", "LIMITS_DAILY_TOKENS", "LIMITS_DAILY_COST", "LIMITS_STATE_PATH",
+}
+
+// clearConfigEnv unsets the config environment variables for the duration of the test
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range configEnv {
+		t.Setenv(name, "")
+	}
+}
+
+// writeConfig writes a config file to a temporary directory and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("DISCORD_BOT_TOKEN", "token")
+	t.Setenv("GEMINI_API_KEY", "key")
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	want := config.Default()
+	want.Discord.Token = "token"
+	want.Model.APIKey = "key"
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("config = %+v, want the defaults %+v", cfg, want)
+	}
+	if cfg.Memory.WindowSize != 20 || cfg.Discord.TurnTimeout != time.Minute || len(cfg.Tools.Enabled()) != 2 {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+	if cfg.Limits.User.PerMinute != 0 || cfg.Limits.User.Burst != 0 {
+		t.Errorf("user limit = %+v, want rate limits off by default", cfg.Limits.User)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfig(t, `
+discord:
+  token: file-token
+  turn_timeout: 90s
+model:
+  provider: ollama
+  name: llama3.2
+  fallbacks: [llama3.1]
+  generation:
+    temperature: 0.4
+    safety_settings:
+      - category: harassment
+        threshold: block_none
+tools:
+  google_search:
+    enabled: false
+memory:
+  store: sqlite
+  store_path: ./data/memory.db
+guilds:
+  "100":
+    persona: You are a pirate.
+`)
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.Discord.Token != "file-token" || cfg.Discord.TurnTimeout != 90*time.Second || !cfg.Discord.StreamResponses {
+		t.Errorf("discord = %+v", cfg.Discord)
+	}
+	if cfg.Model.Provider != "ollama" || cfg.Model.Name != "llama3.2" || !reflect.DeepEqual(cfg.Model.Fallbacks, []string{"llama3.1"}) {
+		t.Errorf("model = %+v", cfg.Model)
+	}
+	if *cfg.Model.Generation.Temperature != 0.4 || cfg.Model.Generation.SafetySettings[0].Category != "HARM_CATEGORY_HARASSMENT" {
+		t.Errorf("generation = %+v, want normalized safety settings", cfg.Model.Generation)
+	}
+	if !reflect.DeepEqual(cfg.Tools.Enabled(), []string{config.ToolURLFetch}) {
+		t.Errorf("enabled tools = %v", cfg.Tools.Enabled())
+	}
+	if cfg.Memory.Store != "sqlite" || cfg.Memory.WindowSize != 20 {
+		t.Errorf("memory = %+v, want the file's store and the default window", cfg.Memory)
+	}
+	if cfg.Guilds["100"].Persona != "You are a pirate." {
+		t.Errorf("guilds = %+v", cfg.Guilds)
+	}
+}
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfig(t, `
+discord:
+  token: file-token
+model:
+  name: gemini-2.0-flash
+  api_key: file-key
+memory:
+  window_size: 50
+`)
+	t.Setenv("MODEL_NAME", "gemini-2.0-flash-exp")
+	t.Setenv("GEMINI_API_KEY", "env-key")
+	t.Setenv("MODEL_FALLBACKS", "gemini-2.0-flash, gemini-1.5-flash")
+	t.Setenv("MODEL_TOP_P", "0.5")
+	t.Setenv("MODEL_STOP_SEQUENCES", "END| STOP")
+	t.Setenv("MEMORY_WINDOW_SIZE", "5")
+	t.Setenv("STREAM_RESPONSES", "false")
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.Discord.Token != "file-token" || cfg.Discord.StreamResponses {
+		t.Errorf("discord = %+v", cfg.Discord)
+	}
+	if cfg.Model.Name != "gemini-2.0-flash-exp" || cfg.Model.APIKey != "env-key" {
+		t.Errorf("model = %+v, want the environment's name and key", cfg.Model)
+	}
+	if !reflect.DeepEqual(cfg.Model.Fallbacks, []string{"gemini-2.0-flash", "gemini-1.5-flash"}) {
+		t.Errorf("fallbacks = %q", cfg.Model.Fallbacks)
+	}
+	if *cfg.Model.Generation.TopP != 0.5 || !reflect.DeepEqual(cfg.Model.Generation.StopSequences, []string{"END", " STOP"}) {
+		t.Errorf("generation = %+v", cfg.Model.Generation)
+	}
+	if cfg.Memory.WindowSize != 5 {
+		t.Errorf("window size = %d, want 5", cfg.Memory.WindowSize)
+	}
+
+	// An explicit MODEL_API_KEY wins over GEMINI_API_KEY
+	t.Setenv("MODEL_API_KEY", "model-key")
+	cfg, err = config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Model.APIKey != "model-key" {
+		t.Errorf("api key = %q, want MODEL_API_KEY", cfg.Model.APIKey)
+	}
+}
+
+func TestLoadConfigReportsEveryInvalidValue(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfig(t, `
+model:
+  provider: bard
+  generation:
+    top_p: 2
+memory:
+  store: redis
+  store_path: ./data
+channels:
+  "200":
+    tools: [calculator]
+    generation:
+      safety_settings:
+        - category: spam
+          threshold: off
+`)
+	t.Setenv("MODEL_MAX_ATTEMPTS", "many")
+
+	_, err := config.Load(path)
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("error = %v, want a *config.ValidationError", err)
+	}
+
+	var keys []string
+	for _, fieldErr := range validationErr.Errors {
+		keys = append(keys, fieldErr.Key)
+	}
+	want := []string{
+		"MODEL_MAX_ATTEMPTS (model.max_attempts)",
+		"discord.token",
+		"model.provider",
+		"model.generation",
+		"memory.store",
+		"channels.200.tools[0]",
+		"channels.200.generation",
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("invalid keys = %q, want %q", keys, want)
+	}
+
+	report := err.Error()
+	for _, part := range []string{path, `unknown provider "bard"`, "top_p 2 must be between 0 and 1", `unknown tool "calculator"`, `unknown harm category "spam"`} {
+		if !strings.Contains(report, part) {
+			t.Errorf("report %q doesn't mention %q", report, part)
+		}
+	}
+}
+
+func TestLoadConfigRejectsUnknownKeys(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfig(t, "model:\n  provider: gemini\n  tempreature: 0.5\n")
+
+	_, err := config.Load(path)
+	if err == nil || !strings.Contains(err.Error(), "line 3") || !strings.Contains(err.Error(), "tempreature") {
+		t.Errorf("error = %v, want the unknown key and its line", err)
+	}
+}
+
+func TestConfigResolve(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfig(t, `
+discord:
+  token: token
+model:
+  api_key: key
+agent:
+  persona: You are helpful.
+guilds:
+  "100":
+    persona: You are a pirate.
+    tools: [google_search]
+    generation:
+      temperature: 0.9
+channels:
+  "dev":
+    tools: [google_search, url_fetch]
+    generation:
+      max_output_tokens: 256
+`)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	global := cfg.Resolve("other", "general")
+	if global.Persona != "You are helpful." || len(global.Tools) != 2 || global.Generation.Temperature != nil {
+		t.Errorf("global settings = %+v", global)
+	}
+
+	guild := cfg.Resolve("100", "general")
+	if guild.Persona != "You are a pirate." || !reflect.DeepEqual(guild.Tools, []string{config.ToolGoogleSearch}) || *guild.Generation.Temperature != 0.9 {
+		t.Errorf("guild settings = %+v", guild)
+	}
+
+	channel := cfg.Resolve("100", "dev")
+	if channel.Persona != "You are a pirate." || len(channel.Tools) != 2 || *channel.Generation.Temperature != 0.9 || channel.Generation.MaxOutputTokens != 256 {
+		t.Errorf("channel settings = %+v, want the channel's applied over the guild's", channel)
+	}
+
+	// Overrides can't enable a tool that is disabled globally
+	cfg.Tools.URLFetch.Enabled = false
+	if tools := cfg.Resolve("100", "dev").Tools; !reflect.DeepEqual(tools, []string{config.ToolGoogleSearch}) {
+		t.Errorf("tools = %v, want only the globally enabled ones", tools)
+	}
+}
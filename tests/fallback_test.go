@@ -0,0 +1,255 @@
+package tests
+
+import (
+	"context"
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/testutil"
+	"discord-gemini-bot/src/tools"
+	"discord-gemini-bot/src/types"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// fastRetries retries quickly so tests don't wait on real backoff delays
+var fastRetries = models.RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+	Multiplier:     2,
+}
+
+// failingModel returns a fake model that fails with errs in order before answering
+func failingModel(answer string, errs ...error) *testutil.FakeModel {
+	return testutil.NewFakeModelFunc(func(messages []*types.Message) (string, error) {
+		if len(errs) > 0 {
+			err := errs[0]
+			errs = errs[1:]
+			return "", err
+		}
+		return answer, nil
+	})
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"rate limited", fmt.Errorf("request failed: %w", &models.StatusError{StatusCode: 429}), true},
+		{"unavailable", &models.StatusError{StatusCode: 503}, true},
+		{"bad request", &models.StatusError{StatusCode: 400}, false},
+		{"gemini rate limited", fmt.Errorf("failed: %w", genai.APIError{Code: 429}), true},
+		{"gemini permission denied", genai.APIError{Code: 403}, false},
+		{"empty response", fmt.Errorf("no candidates returned: %w", models.ErrEmptyResponse), true},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"cancelled", context.Canceled, false},
+		{"other", errors.New("invalid prompt"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := models.IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := models.RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	for retry, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 5: time.Second} {
+		if got := policy.Backoff(retry); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", retry, got, want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := policy.Backoff(1); got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("Backoff(1) with jitter = %v, want within 50%% of 100ms", got)
+		}
+	}
+}
+
+func TestFallbackModelRetries(t *testing.T) {
+	primary := failingModel("recovered", &models.StatusError{StatusCode: 429}, models.ErrEmptyResponse)
+	model, err := models.NewFallbackModel(fastRetries, primary)
+	if err != nil {
+		t.Fatalf("NewFallbackModel failed: %v", err)
+	}
+
+	response, err := model.GenerateWithHistoryAsync(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatalf("GenerateWithHistoryAsync failed: %v", err)
+	}
+	if response.Text != "recovered" || len(primary.Calls()) != 3 {
+		t.Errorf("response = %q after %d calls, want recovered after 3", response.Text, len(primary.Calls()))
+	}
+}
+
+func TestFallbackModelFallsBack(t *testing.T) {
+	tests := []struct {
+		name         string
+		primaryErrs  []error
+		primaryCalls int
+	}{
+		{"retries exhausted", []error{&models.StatusError{StatusCode: 503}, &models.StatusError{StatusCode: 503}, &models.StatusError{StatusCode: 503}}, 3},
+		{"not retryable", []error{&models.StatusError{StatusCode: 404, Message: "model not found"}}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := failingModel("primary", tt.primaryErrs...)
+			fallback := testutil.NewFakeModel("fallback")
+			model, err := models.NewFallbackModel(fastRetries, primary, fallback)
+			if err != nil {
+				t.Fatalf("NewFallbackModel failed: %v", err)
+			}
+
+			response, err := model.GenerateAsync(context.Background(), "hi", nil)
+			if err != nil {
+				t.Fatalf("GenerateAsync failed: %v", err)
+			}
+			if response != "fallback" {
+				t.Errorf("response = %q, want the fallback model's", response)
+			}
+			if len(primary.Calls()) != tt.primaryCalls {
+				t.Errorf("primary called %d times, want %d", len(primary.Calls()), tt.primaryCalls)
+			}
+		})
+	}
+}
+
+func TestFallbackModelAllFail(t *testing.T) {
+	lastErr := &models.StatusError{StatusCode: 400, Message: "bad request"}
+	model, err := models.NewFallbackModel(fastRetries, failingModel("", models.ErrEmptyResponse, models.ErrEmptyResponse, models.ErrEmptyResponse), failingModel("", lastErr))
+	if err != nil {
+		t.Fatalf("NewFallbackModel failed: %v", err)
+	}
+
+	_, err = model.GenerateAsync(context.Background(), "hi", nil)
+	var statusErr *models.StatusError
+	if !errors.As(err, &statusErr) || statusErr != lastErr {
+		t.Errorf("error = %v, want it to wrap the last model's error", err)
+	}
+
+	if _, err := models.NewFallbackModel(fastRetries); err == nil {
+		t.Error("expected an error without models")
+	}
+}
+
+func TestFallbackModelStream(t *testing.T) {
+	primary := failingModel("primary", &models.StatusError{StatusCode: 500}, &models.StatusError{StatusCode: 500}, &models.StatusError{StatusCode: 500})
+	fallback := testutil.NewFakeModel("Final Answer: from the fallback")
+	model, err := models.NewFallbackModel(fastRetries, primary, fallback)
+	if err != nil {
+		t.Fatalf("NewFallbackModel failed: %v", err)
+	}
+
+	var streamed string
+	response, err := model.StreamWithHistoryAsync(context.Background(), nil, nil, func(chunk string) error {
+		streamed += chunk
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamWithHistoryAsync failed: %v", err)
+	}
+	if response.Text != "Final Answer: from the fallback" || streamed != response.Text {
+		t.Errorf("response = %q, streamed = %q", response.Text, streamed)
+	}
+
+	// A failure after chunks were delivered isn't retried, as the caller has seen them
+	stop := errors.New("stop")
+	retried := testutil.NewFakeModel("first", "second")
+	model, err = models.NewFallbackModel(fastRetries, retried)
+	if err != nil {
+		t.Fatalf("NewFallbackModel failed: %v", err)
+	}
+	_, err = model.StreamWithHistoryAsync(context.Background(), nil, nil, func(chunk string) error {
+		return fmt.Errorf("chunk rejected: %w, %w", stop, models.ErrEmptyResponse)
+	})
+	if !errors.Is(err, stop) || len(retried.Calls()) != 1 {
+		t.Errorf("error = %v after %d calls, want the first failure without retrying", err, len(retried.Calls()))
+	}
+}
+
+func TestFallbackModelCancelledDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	primary := testutil.NewFakeModelFunc(func(messages []*types.Message) (string, error) {
+		cancel()
+		return "", &models.StatusError{StatusCode: 429}
+	})
+	model, err := models.NewFallbackModel(models.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour}, primary, testutil.NewFakeModel("fallback"))
+	if err != nil {
+		t.Fatalf("NewFallbackModel failed: %v", err)
+	}
+
+	if _, err := model.GenerateAsync(ctx, "hi", nil); err == nil {
+		t.Error("expected an error once the context is cancelled")
+	}
+	if len(primary.Calls()) != 1 {
+		t.Errorf("primary called %d times, want 1", len(primary.Calls()))
+	}
+}
+
+func TestFallbackModelSetSystemPrompt(t *testing.T) {
+	primary, fallback := testutil.NewFakeModel(), testutil.NewFakeModel()
+	model, err := models.NewFallbackModel(fastRetries, primary, fallback)
+	if err != nil {
+		t.Fatalf("NewFallbackModel failed: %v", err)
+	}
+
+	model.SetSystemPrompt("be brief")
+	if primary.SystemPrompt() != "be brief" || fallback.SystemPrompt() != "be brief" {
+		t.Errorf("system prompts = %q, %q", primary.SystemPrompt(), fallback.SystemPrompt())
+	}
+	if model.Name() != "fake (fallback: fake)" {
+		t.Errorf("Name() = %q", model.Name())
+	}
+}
+
+func TestFallbackModelFunctionCallingSupport(t *testing.T) {
+	server := newOpenAIServer(t, func(w http.ResponseWriter, body map[string]interface{}) {
+		completion(w, "Final Answer: answered without functions")
+	})
+	openai, err := models.NewOpenAI(server.URL+"/v1/", "secret", "local-model")
+	if err != nil {
+		t.Fatalf("NewOpenAI failed: %v", err)
+	}
+	model, err := models.NewFallbackModel(fastRetries, openai, testutil.NewFakeModel())
+	if err != nil {
+		t.Fatalf("NewFallbackModel failed: %v", err)
+	}
+	if models.SupportsFunctionCalling(model) {
+		t.Fatal("Expected a chain without function calling models not to support function calling")
+	}
+
+	// Native function calling must fall back to ReAct prompting instead of failing every turn
+	opts := agent.DefaultOptions()
+	opts.NativeFunctionCalling = true
+	ag := agent.NewAgentWithOptions(model, types.NewConversationMemory(20), []tools.Tool{newEchoTool("google_search")}, opts)
+	ag.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "hi"}}))
+	result, err := ag.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if result.Response != "answered without functions" {
+		t.Errorf("Unexpected response: %q", result.Response)
+	}
+
+	withFunctions, err := models.NewFallbackModel(fastRetries, openai, &scriptedFunctionModel{})
+	if err != nil {
+		t.Fatalf("NewFallbackModel failed: %v", err)
+	}
+	if !models.SupportsFunctionCalling(withFunctions) {
+		t.Error("Expected a chain with a function calling model to support function calling")
+	}
+}
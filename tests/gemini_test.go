@@ -20,7 +20,7 @@ func TestGeminiSimpleGeneration(t *testing.T) {
 	// Get API key from environment
 	apiKey := os.Getenv("GEMINI_API_KEY")
 	if apiKey == "" {
-		t.Fatal("GEMINI_API_KEY environment variable is required")
+		t.Skip("GEMINI_API_KEY not set, skipping integration test")
 	}
 
 	// Create Gemini model instance
@@ -0,0 +1,260 @@
+package tests
+
+import (
+	"context"
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/config"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/testutil"
+	"discord-gemini-bot/src/types"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func float32Ptr(v float32) *float32 { return &v }
+
+func int32Ptr(v int32) *int32 { return &v }
+
+func TestParseSafetySettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []models.SafetySetting
+		wantErr string
+	}{
+		{"empty", "", nil, ""},
+		{
+			"short names",
+			"harassment=block_only_high, dangerous_content=BLOCK_LOW_AND_ABOVE",
+			[]models.SafetySetting{
+				{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_ONLY_HIGH"},
+				{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_LOW_AND_ABOVE"},
+			},
+			"",
+		},
+		{
+			"full names",
+			"HARM_CATEGORY_HATE_SPEECH=OFF",
+			[]models.SafetySetting{{Category: "HARM_CATEGORY_HATE_SPEECH", Threshold: "OFF"}},
+			"",
+		},
+		{"unknown category", "spam=block_none", nil, "unknown harm category"},
+		{"unknown threshold", "harassment=sometimes", nil, "unknown block threshold"},
+		{"missing threshold", "harassment", nil, "expected category=threshold"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := models.ParseSafetySettings(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSafetySettings failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("settings = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateOptionsMerge(t *testing.T) {
+	base := models.GenerateOptions{
+		Temperature:     float32Ptr(1),
+		MaxOutputTokens: 8192,
+		StopSequences:   []string{"Observation:"},
+		SafetySettings: []models.SafetySetting{
+			{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_LOW_AND_ABOVE"},
+			{Category: "HARM_CATEGORY_HATE_SPEECH", Threshold: "BLOCK_LOW_AND_ABOVE"},
+		},
+	}
+	merged := base.Merge(&models.GenerateOptions{
+		Temperature: float32Ptr(0.2),
+		TopK:        int32Ptr(40),
+		SafetySettings: []models.SafetySetting{
+			{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_NONE"},
+		},
+	})
+
+	if *merged.Temperature != 0.2 || *merged.TopK != 40 || merged.TopP != nil {
+		t.Errorf("temperature = %v, top_k = %v, top_p = %v", *merged.Temperature, *merged.TopK, merged.TopP)
+	}
+	if merged.MaxOutputTokens != 8192 || !reflect.DeepEqual(merged.StopSequences, base.StopSequences) {
+		t.Errorf("unset fields must keep the base values, got %+v", merged)
+	}
+	want := []models.SafetySetting{
+		{Category: "HARM_CATEGORY_HATE_SPEECH", Threshold: "BLOCK_LOW_AND_ABOVE"},
+		{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_NONE"},
+	}
+	if !reflect.DeepEqual(merged.SafetySettings, want) {
+		t.Errorf("safety settings = %+v, want %+v", merged.SafetySettings, want)
+	}
+	if *base.Temperature != 1 || len(base.SafetySettings) != 2 {
+		t.Error("Merge must not modify the base options")
+	}
+}
+
+func TestGenerationOverrides(t *testing.T) {
+	clearConfigEnv(t)
+	cfg, err := config.Load(writeConfig(t, baseConfig+`
+  generation:
+    max_output_tokens: 1024
+guilds:
+  strict:
+    generation:
+      temperature: 0.7
+      safety_settings:
+        - category: harassment
+          threshold: block_low_and_above
+channels:
+  dev:
+    generation:
+      temperature: 0.1
+      top_p: 0.9
+      stop_sequences: [END]
+`))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	guild := cfg.Resolve("strict", "general").Generation
+	if *guild.Temperature != 0.7 || guild.MaxOutputTokens != 1024 {
+		t.Errorf("guild settings = %+v", guild)
+	}
+	if len(guild.SafetySettings) != 1 || guild.SafetySettings[0].Category != "HARM_CATEGORY_HARASSMENT" {
+		t.Errorf("safety settings = %+v, want the normalized guild setting", guild.SafetySettings)
+	}
+
+	channel := cfg.Resolve("strict", "dev").Generation
+	if *channel.Temperature != 0.1 || *channel.TopP != 0.9 || len(channel.SafetySettings) != 1 {
+		t.Errorf("channel settings = %+v, want the channel's applied over the guild's", channel)
+	}
+
+	if other := cfg.Resolve("other", "general").Generation; !reflect.DeepEqual(other, cfg.Model.Generation) {
+		t.Errorf("settings without overrides = %+v, want the model's", other)
+	}
+}
+
+func TestGenerationOverridesValidation(t *testing.T) {
+	clearConfigEnv(t)
+	_, err := config.Load(writeConfig(t, baseConfig+"channels:\n  \"123\":\n    generation:\n      top_p: 1.5\n"))
+	if err == nil || !strings.Contains(err.Error(), "channels.123") || !strings.Contains(err.Error(), "top_p") {
+		t.Errorf("error = %v, want it to name the channel and setting", err)
+	}
+}
+
+func TestOpenAISendsGenerationSettings(t *testing.T) {
+	server := newOpenAIServer(t, func(w http.ResponseWriter, body map[string]interface{}) {
+		completion(w, "ok")
+	})
+
+	model, err := models.NewOpenAI(server.URL+"/v1", "", "local-model")
+	if err != nil {
+		t.Fatalf("NewOpenAI failed: %v", err)
+	}
+	model.SetGenerateOptions(models.GenerateOptions{TopP: float32Ptr(0.5), StopSequences: []string{"END"}})
+
+	if _, err := model.GenerateWithHistoryAsync(context.Background(), nil, &models.GenerateOptions{TopK: int32Ptr(20), Temperature: float32Ptr(0)}); err != nil {
+		t.Fatalf("GenerateWithHistoryAsync failed: %v", err)
+	}
+
+	request := server.requests[0]
+	if request["top_p"] != 0.5 || request["top_k"] != float64(20) || request["temperature"] != float64(0) || request["max_tokens"] != float64(8192) {
+		t.Errorf("request = %v", request)
+	}
+	if stop := request["stop"].([]interface{}); len(stop) != 1 || stop[0] != "END" {
+		t.Errorf("stop = %v", stop)
+	}
+	if _, found := request["n"]; found {
+		t.Error("n must be omitted unless a candidate count is set")
+	}
+}
+
+func TestOllamaSendsGenerationSettings(t *testing.T) {
+	var request map[string]interface{}
+	server := newOllamaServer(t, func(w http.ResponseWriter, body map[string]interface{}) {
+		request = body
+		fmt.Fprint(w, `{"message":{"role":"assistant","content":"ok"},"done":true}`)
+	})
+
+	model, err := models.NewOllama(server.URL, "llama3.2")
+	if err != nil {
+		t.Fatalf("NewOllama failed: %v", err)
+	}
+	model.SetGenerateOptions(models.GenerateOptions{Temperature: float32Ptr(0.3), TopK: int32Ptr(10)})
+
+	if _, err := model.GenerateAsync(context.Background(), "hi", nil); err != nil {
+		t.Fatalf("GenerateAsync failed: %v", err)
+	}
+
+	options := request["options"].(map[string]interface{})
+	if options["temperature"] != 0.3 || options["top_k"] != float64(10) || options["num_predict"] != float64(8192) {
+		t.Errorf("options = %v", options)
+	}
+}
+
+func TestNewModelRejectsInvalidGeneration(t *testing.T) {
+	_, err := models.NewModel(models.Config{
+		Provider:   models.ProviderOllama,
+		Name:       "llama3.2",
+		Generation: models.GenerateOptions{SafetySettings: []models.SafetySetting{{Category: "spam", Threshold: "off"}}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "spam") {
+		t.Errorf("error = %v, want the unknown category named", err)
+	}
+}
+
+func TestChannelAgentsUseGuildAndChannelSettings(t *testing.T) {
+	clearConfigEnv(t)
+	cfg, err := config.Load(writeConfig(t, baseConfig+`
+guilds:
+  community:
+    generation:
+      temperature: 0.9
+channels:
+  dev:
+    generation:
+      temperature: 0.1
+`))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	model := testutil.NewFakeModelFunc(func(messages []*types.Message) (string, error) {
+		return "Final Answer: ok", nil
+	})
+	registry := agent.NewRegistry(func(guildID, channelID string) (*agent.Agent, error) {
+		opts := agent.DefaultOptions()
+		opts.Generation = cfg.Resolve(guildID, channelID).Generation
+		return agent.NewAgentWithOptions(model, types.NewConversationMemory(10), nil, opts), nil
+	}, 0)
+
+	for _, channelID := range []string{"general", "dev"} {
+		err := registry.Do(context.Background(), "community", channelID, func(a *agent.Agent) error {
+			a.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "hi"}}))
+			_, err := a.GetResponse(context.Background())
+			return err
+		})
+		if err != nil {
+			t.Fatalf("turn in %s failed: %v", channelID, err)
+		}
+	}
+
+	calls := model.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("got %d model calls, want 2", len(calls))
+	}
+	if got := *calls[0].Options.Temperature; got != 0.9 {
+		t.Errorf("general channel temperature = %v, want the guild's 0.9", got)
+	}
+	if got := *calls[1].Options.Temperature; got != 0.1 {
+		t.Errorf("dev channel temperature = %v, want the channel's 0.1", got)
+	}
+}
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/discordbot"
+	"discord-gemini-bot/src/handler"
+	"discord-gemini-bot/src/testutil"
+	"discord-gemini-bot/src/tools"
+	"discord-gemini-bot/src/types"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// lastUserText returns the text of the last user message in messages
+func lastUserText(messages []*types.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "alice" || messages[i].Role == "bob" {
+			return messages[i].Text()
+		}
+	}
+	return ""
+}
+
+func TestHandlerRepliesToMention(t *testing.T) {
+	model := testutil.NewFakeModel("Thought: easy\nFinal Answer: Hi Alice!")
+	h := testutil.NewHarness(model, nil, agent.DefaultOptions(), 20)
+
+	replies := h.Mention("c1", "alice", "hello")
+	if len(replies) != 1 || replies[0] != "Hi Alice!" {
+		t.Fatalf("replies = %q, want [Hi Alice!]", replies)
+	}
+	if h.Session.Typing("c1") != 1 {
+		t.Errorf("typing indicator started %d times, want 1", h.Session.Typing("c1"))
+	}
+
+	calls := model.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("model called %d times, want 1", len(calls))
+	}
+	if got := lastUserText(calls[0].Messages); got != "hello" {
+		t.Errorf("model saw %q, want the message without the mention", got)
+	}
+}
+
+func TestHandlerIgnoresMessages(t *testing.T) {
+	model := testutil.NewFakeModel()
+	h := testutil.NewHarness(model, nil, agent.DefaultOptions(), 20)
+
+	if replies := h.Deliver(h.NewMessage("c1", "alice", "just chatting", false)); len(replies) != 0 {
+		t.Errorf("replied to a message without a mention: %q", replies)
+	}
+
+	own := h.NewMessage("c1", "bot", "<@bot> talking to myself", true)
+	own.Author.ID = testutil.BotUserID
+	if replies := h.Deliver(own); len(replies) != 0 {
+		t.Errorf("replied to its own message: %q", replies)
+	}
+
+	if len(model.Calls()) != 0 {
+		t.Errorf("model called %d times, want 0", len(model.Calls()))
+	}
+}
+
+func TestHandlerDispatchesTools(t *testing.T) {
+	model := testutil.NewFakeModel(
+		"Thought: I should look it up\nAction: lookup\nAction Input: capital of France",
+		"Thought: found it\nFinal Answer: Paris",
+	)
+	lookup := testutil.NewFakeTool("lookup", "Looks things up.", func(input string) (string, error) {
+		return "The capital of France is Paris.", nil
+	})
+	failing := testutil.NewFakeTool("failing", "Always fails.", func(input string) (string, error) {
+		return "", fmt.Errorf("unavailable")
+	})
+	h := testutil.NewHarness(model, []tools.Tool{lookup, failing}, agent.DefaultOptions(), 20)
+
+	replies := h.Mention("c1", "alice", "what is the capital of France?")
+	if len(replies) != 1 || replies[0] != "Paris" {
+		t.Fatalf("replies = %q, want [Paris]", replies)
+	}
+	if inputs := lookup.Inputs(); len(inputs) != 1 || inputs[0] != "capital of France" {
+		t.Errorf("lookup inputs = %q", inputs)
+	}
+	if len(failing.Inputs()) != 0 {
+		t.Errorf("failing tool was called")
+	}
+
+	// The second model call sees the tool's observation
+	calls := model.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("model called %d times, want 2", len(calls))
+	}
+	history := calls[1].Messages
+	if observation := history[len(history)-1].Text(); !strings.Contains(observation, "The capital of France is Paris.") {
+		t.Errorf("last message before the answer = %q, want the observation", observation)
+	}
+}
+
+func TestHandlerSplitsLongReplies(t *testing.T) {
+	var answer strings.Builder
+	for i := 0; i < 60; i++ {
+		fmt.Fprintf(&answer, "This is sentence number %d of a very long answer that goes on and on. ", i)
+	}
+	long := strings.TrimSpace(answer.String())
+
+	model := testutil.NewFakeModel("Final Answer: " + long)
+	h := testutil.NewHarness(model, nil, agent.DefaultOptions(), 20)
+
+	replies := h.Mention("c1", "alice", "tell me everything")
+	if len(replies) < 3 {
+		t.Fatalf("expected the reply to be split into at least 3 messages, got %d", len(replies))
+	}
+	for i, reply := range replies {
+		if n := utf8.RuneCountInString(reply); n > discordbot.MaxMessageLength {
+			t.Errorf("message %d has %d characters", i, n)
+		}
+		if !strings.HasSuffix(reply, ".") {
+			t.Errorf("message %d does not end at a sentence boundary: %q", i, reply[len(reply)-20:])
+		}
+	}
+	if got := strings.Join(replies, " "); got != long {
+		t.Error("split messages do not add up to the answer")
+	}
+}
+
+func TestHandlerStreamsReply(t *testing.T) {
+	model := testutil.NewFakeModel(
+		"Thought: check\nAction: echo\nAction Input: ping",
+		"Thought: done\nFinal Answer: The tool said ping, so pong!",
+	)
+	model.SetChunkSize(3)
+	h := testutil.NewHarness(model, []tools.Tool{testutil.NewEchoTool("echo")}, agent.DefaultOptions(), 20)
+	h.Handler.SetStreaming(true)
+
+	replies := h.Mention("c1", "alice", "ping?")
+	if len(replies) != 1 || replies[0] != "The tool said ping, so pong!" {
+		t.Fatalf("replies = %q", replies)
+	}
+	if h.Session.Edits() < 2 {
+		t.Errorf("expected the placeholder to be edited progressively, got %d edits", h.Session.Edits())
+	}
+}
+
+func TestHandlerReportsModelErrors(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			h := testutil.NewHarness(testutil.NewFakeModel(), nil, agent.DefaultOptions(), 20)
+			h.Handler.SetStreaming(stream)
+
+			replies := h.Mention("c1", "alice", "hello")
+			if len(replies) != 1 || replies[0] != handler.ErrorReply {
+				t.Errorf("replies = %q, want the error reply", replies)
+			}
+		})
+	}
+}
+
+func TestHandlerEvictsOldMemory(t *testing.T) {
+	model := testutil.NewFakeModelFunc(func(messages []*types.Message) (string, error) {
+		return "Final Answer: noted " + lastUserText(messages), nil
+	})
+	h := testutil.NewHarness(model, nil, agent.DefaultOptions(), 4)
+
+	for i := 1; i <= 4; i++ {
+		h.Mention("c1", "alice", fmt.Sprintf("fact %d", i))
+	}
+
+	history := h.Memory("c1").GetHistory()
+	if len(history) != 4 {
+		t.Fatalf("memory holds %d messages, want 4", len(history))
+	}
+	if first := history[0].Text(); first != "fact 3" {
+		t.Errorf("oldest remembered message = %q, want fact 3", first)
+	}
+
+	calls := model.Calls()
+	for _, msg := range calls[len(calls)-1].Messages {
+		if text := msg.Text(); text == "fact 1" || text == "fact 2" {
+			t.Errorf("evicted message %q was sent to the model", text)
+		}
+	}
+
+	if err := h.Reset("c1"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if n := len(h.Memory("c1").GetHistory()); n != 0 {
+		t.Errorf("memory holds %d messages after reset, want 0", n)
+	}
+}
+
+func TestHandlerKeepsChannelsApart(t *testing.T) {
+	model := testutil.NewFakeModelFunc(func(messages []*types.Message) (string, error) {
+		return fmt.Sprintf("Final Answer: %d messages", len(messages)), nil
+	})
+	h := testutil.NewHarness(model, nil, agent.DefaultOptions(), 20)
+
+	h.Mention("c1", "alice", "one")
+	h.Mention("c1", "alice", "two")
+	replies := h.Mention("c2", "bob", "three")
+
+	if len(replies) != 1 || replies[0] != "1 messages" {
+		t.Errorf("replies in c2 = %q, want [1 messages]", replies)
+	}
+	if got := h.Session.Messages("c1"); len(got) != 2 || got[1] != "3 messages" {
+		t.Errorf("replies in c1 = %q", got)
+	}
+}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"context"
+	"discord-gemini-bot/src/memory"
+	"discord-gemini-bot/src/types"
+	"path/filepath"
+	"testing"
+)
+
+// openStores returns one of each memory store backed by a temporary directory
+func openStores(t *testing.T) map[string]func() types.MemoryStore {
+	dir := t.TempDir()
+	return map[string]func() types.MemoryStore{
+		"json": func() types.MemoryStore {
+			store, err := memory.NewJSONFileStore(filepath.Join(dir, "json"))
+			if err != nil {
+				t.Fatalf("Failed to open JSON store: %v", err)
+			}
+			return store
+		},
+		"sqlite": func() types.MemoryStore {
+			store, err := memory.NewSQLiteStore(filepath.Join(dir, "memory.db"))
+			if err != nil {
+				t.Fatalf("Failed to open SQLite store: %v", err)
+			}
+			return store
+		},
+	}
+}
+
+func TestMemoryStoreSurvivesRestart(t *testing.T) {
+	for name, open := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store := open()
+			mem, err := types.NewPersistentConversationMemory(3, store, "123456")
+			if err != nil {
+				t.Fatalf("Failed to create memory: %v", err)
+			}
+			for _, text := range []string{"one", "two", "three", "four"} {
+				mem.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: text}}))
+			}
+			// Messages are saved by flushing, not on every change
+			if snapshot, err := store.Load("123456"); err != nil || snapshot != nil {
+				t.Fatalf("Expected nothing saved before a flush, got %+v, %v", snapshot, err)
+			}
+			if err := mem.Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			// Reopen the store as a restarted process would
+			store = open()
+			defer store.Close()
+			restored, err := types.NewPersistentConversationMemory(3, store, "123456")
+			if err != nil {
+				t.Fatalf("Failed to restore memory: %v", err)
+			}
+			history := restored.GetHistory()
+			if len(history) != 3 || history[0].Contents[0].Content != "two" || history[2].Contents[0].Content != "four" {
+				t.Fatalf("Unexpected restored history: %+v", history)
+			}
+			if history[0].Timestamp.IsZero() || history[0].Role != "user" {
+				t.Errorf("Message fields were not restored: %+v", history[0])
+			}
+
+			restored.Clear()
+			snapshot, err := store.Load("123456")
+			if err != nil || snapshot != nil {
+				t.Errorf("Expected cleared memory to be deleted, got %+v, %v", snapshot, err)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreIsolatesKeysAndMissingKeys(t *testing.T) {
+	for name, open := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store := open()
+			defer store.Close()
+
+			snapshot, err := store.Load("missing")
+			if err != nil || snapshot != nil {
+				t.Fatalf("Expected nil snapshot for missing key, got %+v, %v", snapshot, err)
+			}
+
+			msg := types.NewMessage("AI", []types.MessageContent{{
+				Type:         "function_call",
+				FunctionCall: &types.FunctionCall{Name: "google_search", Args: map[string]interface{}{"query": "go"}},
+			}})
+			if err := store.Save("a", &types.MemorySnapshot{Messages: []*types.Message{msg}}); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+			if err := store.Save("b", &types.MemorySnapshot{}); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+
+			loaded, err := store.Load("a")
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			if len(loaded.Messages) != 1 || loaded.Messages[0].Contents[0].FunctionCall.Args["query"] != "go" {
+				t.Errorf("Function call did not round-trip: %+v", loaded.Messages)
+			}
+		})
+	}
+}
+
+func TestJSONFileStoreRejectsUnsafeKeys(t *testing.T) {
+	store, err := memory.NewJSONFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open JSON store: %v", err)
+	}
+	if err := store.Save("../escape", &types.MemorySnapshot{}); err == nil {
+		t.Error("Expected an error for a path traversal key")
+	}
+}
@@ -0,0 +1,326 @@
+package tests
+
+import (
+	"context"
+	"discord-gemini-bot/src/memory"
+	"discord-gemini-bot/src/ratelimit"
+	"discord-gemini-bot/src/testutil"
+	"discord-gemini-bot/src/types"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lengthTokenCounter counts one token per byte of text so budgets are easy to reason about
+type lengthTokenCounter struct{}
+
+func (lengthTokenCounter) CountTokens(ctx context.Context, messages []*types.Message) (int, error) {
+	total := 0
+	for _, msg := range messages {
+		for _, c := range msg.Contents {
+			total += len(c.Content)
+		}
+	}
+	return total, nil
+}
+
+func textMessage(role, text string) *types.Message {
+	return types.NewMessage(role, []types.MessageContent{{Type: "text", Content: text}})
+}
+
+func historyTexts(history []*types.Message) string {
+	var texts []string
+	for _, msg := range history {
+		texts = append(texts, msg.Contents[0].Content)
+	}
+	return strings.Join(texts, ",")
+}
+
+func TestMemoryTokenBudgetEvictsWholeTurns(t *testing.T) {
+	mem := types.NewConversationMemory(0)
+	mem.SetTokenBudget(12, lengthTokenCounter{})
+
+	// Turn one: a user question, a tool call and its observation
+	mem.AddMessage(context.Background(), textMessage("alice", "q1"))
+	mem.AddMessage(context.Background(), textMessage("AI", "act1"))
+	mem.AddMessage(context.Background(), textMessage("tool", "obs1"))
+	if got := historyTexts(mem.GetHistory()); got != "q1,act1,obs1" || mem.TotalTokens() != 10 {
+		t.Fatalf("Unexpected history %q with %d tokens", got, mem.TotalTokens())
+	}
+
+	// Turn two pushes the total over budget, so all of turn one goes at once
+	mem.AddMessage(context.Background(), textMessage("bob", "q2"))
+	mem.AddMessage(context.Background(), textMessage("AI", "a2"))
+	if got := historyTexts(mem.GetHistory()); got != "q2,a2" || mem.TotalTokens() != 4 {
+		t.Fatalf("Unexpected history %q with %d tokens", got, mem.TotalTokens())
+	}
+}
+
+func TestMemoryKeepsOversizedCurrentTurn(t *testing.T) {
+	mem := types.NewConversationMemory(0)
+	mem.SetTokenBudget(5, lengthTokenCounter{})
+
+	mem.AddMessage(context.Background(), textMessage("alice", "a very long pasted log"))
+	mem.AddMessage(context.Background(), textMessage("AI", "answer"))
+	if got := historyTexts(mem.GetHistory()); got != "a very long pasted log,answer" {
+		t.Fatalf("Current turn must not be split, got %q", got)
+	}
+
+	mem.AddMessage(context.Background(), textMessage("bob", "hi"))
+	if got := historyTexts(mem.GetHistory()); got != "hi" {
+		t.Fatalf("Expected the oversized turn to be evicted, got %q", got)
+	}
+}
+
+func TestMemoryWindowEvictsWholeTurns(t *testing.T) {
+	mem := types.NewConversationMemory(3)
+	mem.AddMessage(context.Background(), textMessage("alice", "q1"))
+	mem.AddMessage(context.Background(), textMessage("AI", "act1"))
+	mem.AddMessage(context.Background(), textMessage("tool", "obs1"))
+	mem.AddMessage(context.Background(), textMessage("bob", "q2"))
+	if got := historyTexts(mem.GetHistory()); got != "q2" {
+		t.Fatalf("Expected the first turn to be evicted whole, got %q", got)
+	}
+}
+
+// countingTokenCounter counts like lengthTokenCounter, recording its calls and the
+// context values it was called with. While gate is set, calls wait for it to close.
+type countingTokenCounter struct {
+	mu      sync.Mutex
+	calls   int
+	callers []interface{}
+	gate    chan struct{}
+	started chan struct{}
+}
+
+// turnKey marks the context of a turn
+type turnKey struct{}
+
+func (c *countingTokenCounter) CountTokens(ctx context.Context, messages []*types.Message) (int, error) {
+	c.mu.Lock()
+	c.calls++
+	c.callers = append(c.callers, ctx.Value(turnKey{}))
+	gate, started := c.gate, c.started
+	c.mu.Unlock()
+	if gate != nil {
+		close(started)
+		<-gate
+	}
+	return lengthTokenCounter{}.CountTokens(ctx, messages)
+}
+
+func TestMemoryCountsTokensOnceOutsideTheLock(t *testing.T) {
+	store, err := memory.NewJSONFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open JSON store: %v", err)
+	}
+	counter := &countingTokenCounter{}
+	mem, err := types.NewPersistentConversationMemory(0, store, "general")
+	if err != nil {
+		t.Fatalf("Failed to create memory: %v", err)
+	}
+	mem.SetTokenBudget(100, counter)
+
+	ctx := context.WithValue(context.Background(), turnKey{}, "turn")
+	mem.AddMessage(ctx, textMessage("alice", "q1"))
+	mem.AddMessage(ctx, textMessage("AI", "a1"))
+	if counter.calls != 2 || counter.callers[0] != "turn" || mem.TotalTokens() != 4 {
+		t.Fatalf("calls = %d with %v, total = %d; want 2 calls with the caller's context", counter.calls, counter.callers, mem.TotalTokens())
+	}
+
+	// Reading the memory doesn't wait for a slow count
+	counter.gate, counter.started = make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		mem.AddMessage(ctx, textMessage("bob", "q2"))
+		close(done)
+	}()
+	<-counter.started
+	if got := historyTexts(mem.GetHistory()); got != "q1,a1" {
+		t.Errorf("history while counting = %q", got)
+	}
+	close(counter.gate)
+	<-done
+	counter.gate = nil
+	if err := mem.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// A restored memory reuses the cached counts
+	restored, err := types.NewPersistentConversationMemory(0, store, "general")
+	if err != nil {
+		t.Fatalf("Failed to restore memory: %v", err)
+	}
+	restored.SetTokenBudget(100, counter)
+	if counter.calls != 3 || restored.TotalTokens() != 6 {
+		t.Errorf("calls = %d, total = %d; want the cached counts to be reused", counter.calls, restored.TotalTokens())
+	}
+}
+
+func TestEstimateTokenCounter(t *testing.T) {
+	counter := types.NewEstimateTokenCounter()
+	short, _ := counter.CountTokens(context.Background(), []*types.Message{textMessage("alice", "hi")})
+	long, _ := counter.CountTokens(context.Background(), []*types.Message{textMessage("alice", strings.Repeat("word ", 400))})
+	if short <= 0 || long <= short {
+		t.Errorf("Expected longer text to use more tokens, got %d and %d", short, long)
+	}
+	if long < 400 || long > 600 {
+		t.Errorf("Estimate for 2000 characters out of range: %d", long)
+	}
+}
+
+// recordingSummarizer appends evicted texts to the summary and can be told to fail
+type recordingSummarizer struct {
+	fail  bool
+	calls int
+}
+
+func (rs *recordingSummarizer) Summarize(ctx context.Context, summary string, evicted []*types.Message) (string, error) {
+	rs.calls++
+	if rs.fail {
+		return "", context.DeadlineExceeded
+	}
+	if summary != "" {
+		summary += ";"
+	}
+	return summary + historyTexts(evicted), nil
+}
+
+func TestMemorySummarizesEvictedTurns(t *testing.T) {
+	summarizer := &recordingSummarizer{}
+	mem := types.NewConversationMemory(2)
+	mem.SetSummarizer(summarizer)
+
+	mem.AddMessage(context.Background(), textMessage("alice", "my name is alice"))
+	mem.AddMessage(context.Background(), textMessage("AI", "hi alice"))
+	mem.AddMessage(context.Background(), textMessage("bob", "q2"))
+	if mem.Summary() != "my name is alice,hi alice" {
+		t.Fatalf("Unexpected summary %q", mem.Summary())
+	}
+
+	history := mem.GetHistory()
+	if len(history) != 2 || history[0].Role != "summary" || !strings.Contains(history[0].Contents[0].Content, "my name is alice") {
+		t.Fatalf("Expected the summary to be prepended, got %q", historyTexts(history))
+	}
+
+	// Failed summaries keep the evicted messages and retry on the next eviction
+	summarizer.fail = true
+	mem.AddMessage(context.Background(), textMessage("carol", "q3"))
+	mem.AddMessage(context.Background(), textMessage("dave", "q4"))
+	if mem.Summary() != "my name is alice,hi alice" {
+		t.Fatalf("Summary changed after failures: %q", mem.Summary())
+	}
+	summarizer.fail = false
+	mem.AddMessage(context.Background(), textMessage("erin", "q5"))
+	if mem.Summary() != "my name is alice,hi alice;q2,q3" {
+		t.Fatalf("Expected pending messages to be summarized, got %q", mem.Summary())
+	}
+
+	if snapshot := mem.Snapshot(); snapshot.Summary != mem.Summary() {
+		t.Errorf("Summary missing from snapshot: %+v", snapshot)
+	}
+
+	mem.Clear()
+	if mem.Summary() != "" || len(mem.GetHistory()) != 0 {
+		t.Error("Clear must drop the summary")
+	}
+}
+
+// slowSummarizer blocks until released, recording the context value of the turn
+type slowSummarizer struct {
+	started chan struct{}
+	release chan struct{}
+	turn    interface{}
+}
+
+func (ss *slowSummarizer) Summarize(ctx context.Context, summary string, evicted []*types.Message) (string, error) {
+	ss.turn = ctx.Value(turnKey{})
+	close(ss.started)
+	<-ss.release
+	return historyTexts(evicted), nil
+}
+
+func TestMemorySummarizesWithoutBlockingReaders(t *testing.T) {
+	summarizer := &slowSummarizer{started: make(chan struct{}), release: make(chan struct{})}
+	mem := types.NewConversationMemory(2)
+	mem.SetSummarizer(summarizer)
+
+	ctx := context.WithValue(context.Background(), turnKey{}, "turn")
+	mem.AddMessage(ctx, textMessage("alice", "q1"))
+	mem.AddMessage(ctx, textMessage("AI", "a1"))
+	done := make(chan struct{})
+	go func() {
+		mem.AddMessage(ctx, textMessage("bob", "q2"))
+		close(done)
+	}()
+
+	<-summarizer.started
+	read := make(chan string)
+	go func() { read <- historyTexts(mem.GetHistory()) }()
+	select {
+	case got := <-read:
+		if got != "q2" {
+			t.Errorf("history while summarizing = %q, want q2", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetHistory blocked while summarizing")
+	}
+
+	close(summarizer.release)
+	<-done
+	if mem.Summary() != "q1,a1" || summarizer.turn != "turn" {
+		t.Errorf("summary = %q made with %v, want it made with the turn's context", mem.Summary(), summarizer.turn)
+	}
+}
+
+func TestLLMSummarizerCountsUsage(t *testing.T) {
+	limiter, _ := newTestLimiter(t, ratelimit.Limits{}, "")
+	summarizer := memory.NewLLMSummarizer(testutil.NewFakeModel("Alice likes Go."))
+	summarizer.SetLimiter(limiter)
+
+	if _, err := summarizer.Summarize(callerContext("guild", "general", "alice"), "", []*types.Message{textMessage("alice", "I like Go")}); err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	if usage := limiter.Usage(ratelimit.ScopeUser, "alice"); usage.Tokens == 0 {
+		t.Error("expected the summary's tokens to be counted against alice")
+	}
+}
+
+func TestLLMSummarizerPrompt(t *testing.T) {
+	model := &scriptedModel{responses: []string{"  Alice likes Go.  "}}
+	summarizer := memory.NewLLMSummarizer(model)
+
+	summary, err := summarizer.Summarize(context.Background(), "", []*types.Message{textMessage("alice", "I like Go")})
+	if err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	if summary != "Alice likes Go." {
+		t.Errorf("Unexpected summary %q", summary)
+	}
+	prompt := model.histories[0][0].Text()
+	if !strings.Contains(prompt, "alice: I like Go") {
+		t.Errorf("Prompt is missing the transcript:\n%s", prompt)
+	}
+	if model.options[0] == nil || model.options[0].SystemPrompt == "" {
+		t.Error("Summarizer must use its own system prompt")
+	}
+}
+
+func TestLLMSummarizerSkipsEmptyFunctionPayloads(t *testing.T) {
+	model := &scriptedModel{responses: []string{"Alice searched."}}
+	summarizer := memory.NewLLMSummarizer(model)
+
+	evicted := []*types.Message{
+		textMessage("alice", "look it up"),
+		types.NewMessage("AI", []types.MessageContent{{Type: "function_call"}}),
+		types.NewMessage("tool", []types.MessageContent{{Type: "function_response"}}),
+	}
+	if _, err := summarizer.Summarize(context.Background(), "", evicted); err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	prompt := model.histories[0][0].Text()
+	if !strings.Contains(prompt, "alice: look it up") || strings.Contains(prompt, "AI:") || strings.Contains(prompt, "tool:") {
+		t.Errorf("Unexpected transcript:\n%s", prompt)
+	}
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/discordbot"
+	"discord-gemini-bot/src/metrics"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/testutil"
+	"discord-gemini-bot/src/tools"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// get requests path from handler and returns the status code and body
+func get(t *testing.T, handler http.Handler, path string) (int, string) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	body, err := io.ReadAll(recorder.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Code, string(body)
+}
+
+func TestMonitoringEndpoints(t *testing.T) {
+	var connected atomic.Bool
+	handler := metrics.NewHandler(func() error {
+		if !connected.Load() {
+			return errors.New("not connected to the Discord gateway")
+		}
+		return nil
+	})
+
+	if code, _ := get(t, handler, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz status = %d, want 200", code)
+	}
+	if code, body := get(t, handler, "/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "not connected") {
+		t.Errorf("/readyz = %d %q before connecting, want 503 and the reason", code, body)
+	}
+	connected.Store(true)
+	if code, _ := get(t, handler, "/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz status = %d once connected, want 200", code)
+	}
+
+	metrics.ObserveToolInvocation("scrape_test", metrics.OutcomeOK)
+	code, body := get(t, handler, "/metrics")
+	if code != http.StatusOK || !strings.Contains(body, `discord_bot_tool_invocations_total{outcome="ok",tool="scrape_test"} 1`) || !strings.Contains(body, "go_goroutines") {
+		t.Errorf("/metrics = %d %q, want the bot's and the runtime's metrics", code, body)
+	}
+}
+
+func TestBotNotReadyBeforeConnecting(t *testing.T) {
+	bot, err := discordbot.NewBot("token", func(s *discordgo.Session, m *discordgo.MessageCreate) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.Ready(); err == nil {
+		t.Error("expected the bot not to be ready before it connects")
+	}
+}
+
+func TestMetricsRecordTurns(t *testing.T) {
+	fake := testutil.NewFakeModel(
+		"Thought: Do I need to use a tool? Yes\nAction: echo\nAction Input: hello",
+		"Thought: Do I need to use a tool? Yes\nAction: calculator\nAction Input: 1+1",
+		"Thought: Do I need to use a tool? No\nFinal Answer: hello",
+	)
+	model, err := models.NewFallbackModel(fastRetries, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := testutil.NewHarness(model, []tools.Tool{testutil.NewEchoTool("echo")}, agent.DefaultOptions(), 20)
+
+	operation := "generate with history"
+	requests := promtestutil.ToFloat64(metrics.ModelRequests.WithLabelValues("fake", operation))
+	outputTokens := promtestutil.ToFloat64(metrics.ModelTokens.WithLabelValues("fake", "output"))
+	echoed := promtestutil.ToFloat64(metrics.ToolInvocations.WithLabelValues("echo", metrics.OutcomeOK))
+	notFound := promtestutil.ToFloat64(metrics.ToolInvocations.WithLabelValues("calculator", metrics.OutcomeNotFound))
+
+	if replies := h.Mention("metrics-channel", "alice", "say hello"); len(replies) == 0 {
+		t.Fatal("expected a reply")
+	}
+
+	if got := promtestutil.ToFloat64(metrics.MessagesHandled.WithLabelValues("dm", "metrics-channel")); got != 1 {
+		t.Errorf("messages handled = %v, want 1", got)
+	}
+	if got := promtestutil.ToFloat64(metrics.ModelRequests.WithLabelValues("fake", operation)) - requests; got != 3 {
+		t.Errorf("model requests = %v, want one per step", got)
+	}
+	if got := promtestutil.ToFloat64(metrics.ModelTokens.WithLabelValues("fake", "output")) - outputTokens; got <= 0 {
+		t.Errorf("output tokens increased by %v, want the usage of the responses", got)
+	}
+	if got := promtestutil.ToFloat64(metrics.ToolInvocations.WithLabelValues("echo", metrics.OutcomeOK)) - echoed; got != 1 {
+		t.Errorf("echo invocations = %v, want 1", got)
+	}
+	if got := promtestutil.ToFloat64(metrics.ToolInvocations.WithLabelValues("calculator", metrics.OutcomeNotFound)) - notFound; got != 1 {
+		t.Errorf("unknown tool invocations = %v, want 1", got)
+	}
+	if got := promtestutil.CollectAndCount(metrics.TurnDuration); got == 0 {
+		t.Error("expected the turn's latency to be observed")
+	}
+}
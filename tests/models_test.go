@@ -1,4 +1,4 @@
-package main
+package tests
 
 import (
 	"context"
@@ -56,20 +56,15 @@ func TestGeminiModel(t *testing.T) {
 		// Note: We can't directly test the private field, but we can test that no error occurs
 	})
 
-	// Test model info
-	t.Run("GetModelInfo", func(t *testing.T) {
+	// Test model name
+	t.Run("Name", func(t *testing.T) {
 		model, err := models.NewGemini(apiKey, "gemini-2.0-flash-exp")
 		if err != nil {
 			t.Fatalf("Failed to create Gemini model: %v", err)
 		}
 		defer model.Close()
 
-		info := model.GetModelInfo()
-		if info == nil {
-			t.Fatal("Model info is nil")
-		}
-
-		if name, exists := info["name"]; !exists || name != "gemini-2.0-flash-exp" {
+		if name := model.Name(); name != "gemini-2.0-flash-exp" {
 			t.Errorf("Expected model name to be 'gemini-2.0-flash-exp', got %v", name)
 		}
 	})
@@ -118,21 +113,21 @@ func TestGeminiIntegration(t *testing.T) {
 		defer cancel()
 
 		messages := []*types.Message{
-			types.NewMessage("user", "text", "My name is Alice."),
-			types.NewMessage("assistant", "text", "Hello Alice! Nice to meet you."),
-			types.NewMessage("user", "text", "What is my name?"),
+			types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "My name is Alice."}}),
+			types.NewMessage("assistant", []types.MessageContent{{Type: "text", Content: "Hello Alice! Nice to meet you."}}),
+			types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "What is my name?"}}),
 		}
 
-		response, err := model.GenerateWithHistoryAsync(ctx, messages)
+		response, err := model.GenerateWithHistoryAsync(ctx, messages, nil)
 		if err != nil {
 			t.Fatalf("Failed to generate response with history: %v", err)
 		}
 
-		if response == "" {
+		if response.Text == "" {
 			t.Fatal("Empty response from model")
 		}
 
-		t.Logf("Model response with history: %s", response)
+		t.Logf("Model response with history: %s", response.Text)
 	})
 
 	// Test with system prompt
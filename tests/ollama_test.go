@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"context"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newOllamaServer starts a stand-in for an Ollama server that answers /api/chat with handle
+func newOllamaServer(t *testing.T, handle func(w http.ResponseWriter, body map[string]interface{})) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/chat" {
+			http.NotFound(w, r)
+			return
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		handle(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOllamaGenerateWithHistory(t *testing.T) {
+	var request map[string]interface{}
+	server := newOllamaServer(t, func(w http.ResponseWriter, body map[string]interface{}) {
+		request = body
+		fmt.Fprint(w, `{"model":"llava","message":{"role":"assistant","content":"A cat."},"done":true}`)
+	})
+
+	model, err := models.NewOllama(server.URL, "llava")
+	if err != nil {
+		t.Fatalf("NewOllama failed: %v", err)
+	}
+
+	maxTokens := int32(128)
+	messages := []*types.Message{
+		types.NewMessage("alice", []types.MessageContent{
+			{Type: "text", Content: "What is this?"},
+			{Type: "image", Content: "image/png,aGVsbG8="},
+		}),
+		types.NewMessage("AI", []types.MessageContent{{Type: "text", Content: "Thought: look"}}),
+		types.NewMessage("AI", []types.MessageContent{{Type: "text", Content: "Final Answer: a cat"}}),
+		types.NewMessage("alice", []types.MessageContent{{Type: "text", Content: "Are you sure?"}}),
+	}
+	response, err := model.GenerateWithHistoryAsync(context.Background(), messages, &models.GenerateOptions{
+		SystemPrompt:    "agent prompt",
+		MaxOutputTokens: maxTokens,
+	})
+	if err != nil {
+		t.Fatalf("GenerateWithHistoryAsync failed: %v", err)
+	}
+	if response.Text != "A cat." {
+		t.Errorf("response = %q", response.Text)
+	}
+
+	if request["model"] != "llava" || request["stream"] != false {
+		t.Errorf("model = %v, stream = %v", request["model"], request["stream"])
+	}
+	if options := request["options"].(map[string]interface{}); options["num_predict"] != float64(128) {
+		t.Errorf("options = %v", options)
+	}
+
+	wire := request["messages"].([]interface{})
+	if len(wire) != 4 {
+		t.Fatalf("got %d wire messages, want system, user, merged assistant and user: %v", len(wire), wire)
+	}
+	roles := make([]string, 0, len(wire))
+	for _, m := range wire {
+		roles = append(roles, m.(map[string]interface{})["role"].(string))
+	}
+	if strings.Join(roles, ",") != "system,user,assistant,user" {
+		t.Errorf("roles = %v", roles)
+	}
+
+	user := wire[1].(map[string]interface{})
+	if user["content"] != "What is this?" {
+		t.Errorf("user content = %v", user["content"])
+	}
+	if images := user["images"].([]interface{}); len(images) != 1 || images[0] != "aGVsbG8=" {
+		t.Errorf("images = %v, want the raw base64 data", images)
+	}
+	if assistant := wire[2].(map[string]interface{}); assistant["content"] != "Thought: look\n\nFinal Answer: a cat" {
+		t.Errorf("assistant content = %q", assistant["content"])
+	}
+}
+
+func TestOllamaStream(t *testing.T) {
+	server := newOllamaServer(t, func(w http.ResponseWriter, body map[string]interface{}) {
+		if body["stream"] != true {
+			t.Errorf("stream = %v, want true", body["stream"])
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, chunk := range []string{"Final ", "Answer: ", "local"} {
+			fmt.Fprintf(w, "{\"message\":{\"role\":\"assistant\",\"content\":%q},\"done\":false}\n", chunk)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "{\"model\":\"llama3.2\",\"message\":{\"role\":\"assistant\",\"content\":\"\"},\"done\":true,\"done_reason\":\"length\",\"prompt_eval_count\":9,\"eval_count\":3}\n")
+	})
+
+	model, err := models.NewOllama(server.URL, "llama3.2")
+	if err != nil {
+		t.Fatalf("NewOllama failed: %v", err)
+	}
+
+	var chunks []string
+	messages := []*types.Message{types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "hi"}})}
+	response, err := model.StreamWithHistoryAsync(context.Background(), messages, nil, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamWithHistoryAsync failed: %v", err)
+	}
+	if response.Text != "Final Answer: local" || len(chunks) != 3 {
+		t.Errorf("response = %q, chunks = %q", response.Text, chunks)
+	}
+	if !response.Truncated() || response.ModelVersion != "llama3.2" {
+		t.Errorf("finish reason = %q, model version = %q", response.FinishReason, response.ModelVersion)
+	}
+	if want := (models.Usage{PromptTokens: 9, OutputTokens: 3, TotalTokens: 12}); response.Usage != want {
+		t.Errorf("usage = %+v, want %+v", response.Usage, want)
+	}
+}
+
+func TestOllamaErrors(t *testing.T) {
+	server := newOllamaServer(t, func(w http.ResponseWriter, body map[string]interface{}) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"model \"missing\" not found, try pulling it first"}`)
+	})
+
+	model, err := models.NewOllama(server.URL, "missing")
+	if err != nil {
+		t.Fatalf("NewOllama failed: %v", err)
+	}
+
+	_, err = model.GenerateAsync(context.Background(), "hi", nil)
+	if err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "try pulling it first") {
+		t.Errorf("error = %v, want the status and server message", err)
+	}
+
+	if _, err := models.NewOllama("", ""); err == nil {
+		t.Error("expected an error without a model name")
+	}
+}
+
+func TestNewModel(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   models.Config
+		wantName string
+		wantErr  bool
+	}{
+		{"ollama", models.Config{Provider: "ollama", Name: "llama3.2"}, "llama3.2", false},
+		{"ollama provider is case insensitive", models.Config{Provider: "Ollama", Name: "llama3.2"}, "llama3.2", false},
+		{"openai", models.Config{Provider: "openai", Name: "gpt-4o-mini", BaseURL: "http://localhost:8000/v1"}, "gpt-4o-mini", false},
+		{"openai without base URL", models.Config{Provider: "openai", Name: "gpt-4o-mini"}, "", true},
+		{"gemini without API key", models.Config{}, "", true},
+		{"unknown provider", models.Config{Provider: "bard", Name: "x"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := models.NewModel(tt.config)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got model %s", model.Name())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewModel failed: %v", err)
+			}
+			if model.Name() != tt.wantName {
+				t.Errorf("Name() = %q, want %q", model.Name(), tt.wantName)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package tests
+
+import (
+	"context"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// openAIServer is a stand-in for an OpenAI-compatible server that records the requests it receives
+type openAIServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []map[string]interface{}
+	headers  []http.Header
+}
+
+// newOpenAIServer starts a server that answers every chat completion request with handle
+func newOpenAIServer(t *testing.T, handle func(w http.ResponseWriter, body map[string]interface{})) *openAIServer {
+	s := &openAIServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]interface{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, body)
+		s.headers = append(s.headers, r.Header.Clone())
+		s.mu.Unlock()
+		handle(w, body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// completion writes a non-streamed chat completion with the given content
+func completion(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":"1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}]}`, content)
+}
+
+func TestOpenAIGenerateWithHistory(t *testing.T) {
+	server := newOpenAIServer(t, func(w http.ResponseWriter, body map[string]interface{}) {
+		completion(w, "Hello Alice!")
+	})
+
+	model, err := models.NewOpenAI(server.URL+"/v1/", "secret", "local-model")
+	if err != nil {
+		t.Fatalf("NewOpenAI failed: %v", err)
+	}
+	model.SetSystemPrompt("default prompt")
+
+	temperature := float32(0.2)
+	messages := []*types.Message{
+		types.NewMessage("summary", []types.MessageContent{{Type: "text", Content: "Earlier: Alice likes cats."}}),
+		types.NewMessage("alice", []types.MessageContent{
+			{Type: "text", Content: "What is in this picture?"},
+			{Type: "image", Content: "image/png,aGVsbG8="},
+		}),
+		types.NewMessage("AI", []types.MessageContent{{Type: "text", Content: "Thought: look\nAction: search\nAction Input: cat"}}),
+		types.NewMessage("AI", []types.MessageContent{{Type: "text", Content: "Tool search used. Observation: a cat"}}),
+	}
+	response, err := model.GenerateWithHistoryAsync(context.Background(), messages, &models.GenerateOptions{
+		SystemPrompt:    "agent prompt",
+		Temperature:     &temperature,
+		MaxOutputTokens: 256,
+	})
+	if err != nil {
+		t.Fatalf("GenerateWithHistoryAsync failed: %v", err)
+	}
+	if response.Text != "Hello Alice!" {
+		t.Errorf("response = %q", response.Text)
+	}
+
+	if auth := server.headers[0].Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+
+	body := server.requests[0]
+	if body["model"] != "local-model" {
+		t.Errorf("model = %v", body["model"])
+	}
+	if body["temperature"] != 0.2 {
+		t.Errorf("temperature = %v", body["temperature"])
+	}
+	if body["max_tokens"] != float64(256) {
+		t.Errorf("max_tokens = %v", body["max_tokens"])
+	}
+	if _, streamed := body["stream"]; streamed {
+		t.Errorf("non-streamed request sets stream")
+	}
+
+	wire := body["messages"].([]interface{})
+	if len(wire) != 3 {
+		t.Fatalf("got %d wire messages, want system, user and a merged assistant message: %v", len(wire), wire)
+	}
+
+	system := wire[0].(map[string]interface{})
+	if system["role"] != "system" || system["content"] != "agent prompt" {
+		t.Errorf("system message = %v", system)
+	}
+
+	// The summary and the user's message merge into one multimodal user message
+	user := wire[1].(map[string]interface{})
+	if user["role"] != "user" {
+		t.Errorf("user role = %v", user["role"])
+	}
+	parts := user["content"].([]interface{})
+	if len(parts) != 3 {
+		t.Fatalf("user message has %d parts, want 3", len(parts))
+	}
+	image := parts[2].(map[string]interface{})
+	if image["type"] != "image_url" || image["image_url"].(map[string]interface{})["url"] != "data:image/png;base64,aGVsbG8=" {
+		t.Errorf("image part = %v", image)
+	}
+
+	assistant := wire[2].(map[string]interface{})
+	if assistant["role"] != "assistant" {
+		t.Errorf("assistant role = %v", assistant["role"])
+	}
+	if content, ok := assistant["content"].(string); !ok || !strings.Contains(content, "Action: search") || !strings.Contains(content, "Observation: a cat") {
+		t.Errorf("text-only assistant content = %v, want a merged string", assistant["content"])
+	}
+}
+
+func TestOpenAIGenerateAsync(t *testing.T) {
+	server := newOpenAIServer(t, func(w http.ResponseWriter, body map[string]interface{}) {
+		completion(w, "4")
+	})
+
+	model, err := models.NewOpenAI(server.URL+"/v1", "", "local-model")
+	if err != nil {
+		t.Fatalf("NewOpenAI failed: %v", err)
+	}
+
+	response, err := model.GenerateAsync(context.Background(), "What is 2+2?", []map[string]interface{}{
+		{"data": "aGVsbG8=", "mime_type": "image/jpeg"},
+	})
+	if err != nil {
+		t.Fatalf("GenerateAsync failed: %v", err)
+	}
+	if response != "4" {
+		t.Errorf("response = %q", response)
+	}
+
+	if auth := server.headers[0].Get("Authorization"); auth != "" {
+		t.Errorf("Authorization sent without an API key: %q", auth)
+	}
+	wire := server.requests[0]["messages"].([]interface{})
+	if len(wire) != 1 {
+		t.Fatalf("got %d wire messages, want 1 without a system prompt", len(wire))
+	}
+	parts := wire[0].(map[string]interface{})["content"].([]interface{})
+	if url := parts[1].(map[string]interface{})["image_url"].(map[string]interface{})["url"]; url != "data:image/jpeg;base64,aGVsbG8=" {
+		t.Errorf("image url = %v", url)
+	}
+}
+
+func TestOpenAIStream(t *testing.T) {
+	server := newOpenAIServer(t, func(w http.ResponseWriter, body map[string]interface{}) {
+		if body["stream"] != true {
+			t.Errorf("stream = %v, want true", body["stream"])
+		}
+		if options, _ := body["stream_options"].(map[string]interface{}); options["include_usage"] != true {
+			t.Errorf("stream_options = %v, want the usage included", body["stream_options"])
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{"Final", " Answer:", " streamed"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", chunk)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "data: {\"model\":\"local-model-q4\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"length\"}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":3,\"total_tokens\":15}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+
+	model, err := models.NewOpenAI(server.URL+"/v1", "", "local-model")
+	if err != nil {
+		t.Fatalf("NewOpenAI failed: %v", err)
+	}
+
+	var chunks []string
+	messages := []*types.Message{types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "hi"}})}
+	response, err := model.StreamWithHistoryAsync(context.Background(), messages, nil, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamWithHistoryAsync failed: %v", err)
+	}
+	if response.Text != "Final Answer: streamed" {
+		t.Errorf("response = %q", response.Text)
+	}
+	if len(chunks) != 3 {
+		t.Errorf("chunks = %q, want 3", chunks)
+	}
+	if !response.Truncated() || response.ModelVersion != "local-model-q4" {
+		t.Errorf("finish reason = %q, model version = %q", response.FinishReason, response.ModelVersion)
+	}
+	if want := (models.Usage{PromptTokens: 12, OutputTokens: 3, TotalTokens: 15}); response.Usage != want {
+		t.Errorf("usage = %+v, want %+v", response.Usage, want)
+	}
+}
+
+func TestOpenAIResponseMetadata(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantText     string
+		wantReason   models.FinishReason
+		wantUsage    models.Usage
+		wantBlocked  bool
+		wantEmptyErr bool
+	}{
+		{
+			name:       "usage with cached tokens",
+			body:       `{"model":"gpt-4o-mini-2024-07-18","choices":[{"message":{"content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":1,"total_tokens":21,"prompt_tokens_details":{"cached_tokens":16}}}`,
+			wantText:   "hi",
+			wantReason: models.FinishReasonStop,
+			wantUsage:  models.Usage{PromptTokens: 20, OutputTokens: 1, CachedTokens: 16, TotalTokens: 21},
+		},
+		{
+			name:        "content filter",
+			body:        `{"choices":[{"message":{"content":""},"finish_reason":"content_filter"}]}`,
+			wantReason:  models.FinishReasonSafety,
+			wantBlocked: true,
+		},
+		{
+			name:         "empty content",
+			body:         `{"choices":[{"message":{"content":""},"finish_reason":"stop"}]}`,
+			wantEmptyErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newOpenAIServer(t, func(w http.ResponseWriter, body map[string]interface{}) {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, tt.body)
+			})
+			model, err := models.NewOpenAI(server.URL+"/v1", "", "local-model")
+			if err != nil {
+				t.Fatalf("NewOpenAI failed: %v", err)
+			}
+
+			response, err := model.GenerateWithHistoryAsync(context.Background(), nil, nil)
+			if tt.wantEmptyErr {
+				if !errors.Is(err, models.ErrEmptyResponse) {
+					t.Errorf("error = %v, want ErrEmptyResponse", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateWithHistoryAsync failed: %v", err)
+			}
+			if response.Text != tt.wantText || response.FinishReason != tt.wantReason || response.Blocked() != tt.wantBlocked {
+				t.Errorf("response = %q, finish reason %q, blocked %v", response.Text, response.FinishReason, response.Blocked())
+			}
+			if response.Usage != tt.wantUsage {
+				t.Errorf("usage = %+v, want %+v", response.Usage, tt.wantUsage)
+			}
+		})
+	}
+}
+
+func TestOpenAIErrors(t *testing.T) {
+	server := newOpenAIServer(t, func(w http.ResponseWriter, body map[string]interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"message":"rate limit exceeded","type":"requests"}}`)
+	})
+
+	model, err := models.NewOpenAI(server.URL+"/v1", "", "local-model")
+	if err != nil {
+		t.Fatalf("NewOpenAI failed: %v", err)
+	}
+
+	_, err = model.GenerateAsync(context.Background(), "hi", nil)
+	if err == nil || !strings.Contains(err.Error(), "429") || !strings.Contains(err.Error(), "rate limit exceeded") {
+		t.Errorf("error = %v, want the status and server message", err)
+	}
+
+	if _, err := models.NewOpenAI("", "", "local-model"); err == nil {
+		t.Error("expected an error without a base URL")
+	}
+	if _, err := models.NewOpenAI(server.URL, "", ""); err == nil {
+		t.Error("expected an error without a model name")
+	}
+}
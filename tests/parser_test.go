@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"context"
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/types"
+	"strings"
+	"testing"
+)
+
+func TestParseResponse(t *testing.T) {
+	cases := []struct {
+		name        string
+		input       string
+		answer      string
+		hasFinal    bool
+		action      string
+		actionInput string
+		thoughts    int
+	}{
+		{
+			name:     "WellFormedFinalAnswer",
+			input:    "Thought: Do I need to use a tool? No\nFinal Answer: Hello there! 👋",
+			answer:   "Hello there! 👋",
+			hasFinal: true,
+			thoughts: 1,
+		},
+		{
+			name:     "MultiLineFinalAnswer",
+			input:    "Thought: Do I need to use a tool? No\nFinal Answer: Line one\n\nLine two\nAction: not really an action",
+			answer:   "Line one\n\nLine two\nAction: not really an action",
+			hasFinal: true,
+			thoughts: 1,
+		},
+		{
+			name:        "Action",
+			input:       "Thought: Do I need to use a tool? Yes\nAction: google_search\nAction Input: weather in Paris",
+			action:      "google_search",
+			actionInput: "weather in Paris",
+			thoughts:    1,
+		},
+		{
+			name:        "MultiLineActionInputWithHallucinatedObservation",
+			input:       "Thought: Yes\nAction: url_fetch\nAction Input: https://example.com\nsecond line\nObservation: made up",
+			action:      "url_fetch",
+			actionInput: "https://example.com\nsecond line",
+			thoughts:    1,
+		},
+		{
+			name:   "NoMarkers",
+			input:  "Just a plain reply.",
+			answer: "Just a plain reply.",
+		},
+		{
+			name:     "ThoughtWithoutFinalAnswerMarker",
+			input:    "Thought: Do I need to use a tool? No\nSure, here you go!",
+			answer:   "Sure, here you go!",
+			thoughts: 1,
+		},
+		{
+			name:     "OnlyThought",
+			input:    "Thought: The answer is 4",
+			answer:   "The answer is 4",
+			thoughts: 1,
+		},
+		{
+			name:     "WrappedInFence",
+			input:    "```\nThought: Do I need to use a tool? No\nFinal Answer: Fenced answer\n```",
+			answer:   "Fenced answer",
+			hasFinal: true,
+			thoughts: 1,
+		},
+		{
+			name:   "PlainCodeBlockIsKept",
+			input:  "```go\nfmt.Println(\"hi\")\n```",
+			answer: "```go\nfmt.Println(\"hi\")\n```",
+		},
+		{
+			name:     "MarkdownEmphasisOnMarkers",
+			input:    "**Thought:** No tool needed\n**Final Answer:** Bold answer",
+			answer:   "Bold answer",
+			hasFinal: true,
+			thoughts: 1,
+		},
+		{
+			name:     "LowercaseMarkers",
+			input:    "thought: hmm\nfinal answer: lower",
+			answer:   "lower",
+			hasFinal: true,
+			thoughts: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed := agent.ParseResponse(tc.input)
+			if parsed.FinalAnswer != tc.answer {
+				t.Errorf("FinalAnswer = %q, want %q", parsed.FinalAnswer, tc.answer)
+			}
+			if parsed.HasFinalAnswer != tc.hasFinal {
+				t.Errorf("HasFinalAnswer = %v, want %v", parsed.HasFinalAnswer, tc.hasFinal)
+			}
+			if parsed.Action != tc.action {
+				t.Errorf("Action = %q, want %q", parsed.Action, tc.action)
+			}
+			if parsed.ActionInput != tc.actionInput {
+				t.Errorf("ActionInput = %q, want %q", parsed.ActionInput, tc.actionInput)
+			}
+			if len(parsed.Thoughts) != tc.thoughts {
+				t.Errorf("got %d thoughts, want %d", len(parsed.Thoughts), tc.thoughts)
+			}
+			if parsed.Raw != tc.input {
+				t.Errorf("Raw was modified")
+			}
+		})
+	}
+}
+
+func TestAgentDebugTranscript(t *testing.T) {
+	model := &scriptedModel{responses: []string{
+		"Thought: Yes\nAction: google_search\nAction Input: cats",
+		"Thought: No\nFinal Answer: Cats are great.",
+	}}
+
+	opts := agent.DefaultOptions()
+	opts.Debug = true
+	ag := agent.NewAgentWithOptions(model, types.NewConversationMemory(20), nil, opts)
+	ag.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "cats?"}}))
+
+	response, err := ag.GetResponse(context.Background())
+	if err != nil {
+		t.Fatalf("GetResponse returned error: %v", err)
+	}
+	for _, want := range []string{"Thought: Yes", "Action: google_search", "Observation:", "Final Answer: Cats are great."} {
+		if !strings.Contains(response, want) {
+			t.Errorf("Transcript missing %q:\n%s", want, response)
+		}
+	}
+}
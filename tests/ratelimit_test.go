@@ -0,0 +1,266 @@
+package tests
+
+import (
+	"context"
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/config"
+	"discord-gemini-bot/src/handler"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/ratelimit"
+	"discord-gemini-bot/src/testutil"
+	"discord-gemini-bot/src/tools"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable clock for limiters
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+// newTestLimiter creates a limiter on a fake clock set to noon UTC
+func newTestLimiter(t *testing.T, limits ratelimit.Limits, path string) (*ratelimit.Limiter, *fakeClock) {
+	t.Helper()
+	limiter, err := ratelimit.NewLimiter(limits, path)
+	if err != nil {
+		t.Fatalf("NewLimiter failed: %v", err)
+	}
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	limiter.SetClock(clock.Now)
+	return limiter, clock
+}
+
+// callerContext returns a context for a request from user in a guild's channel
+func callerContext(guildID, channelID, userID string) context.Context {
+	return ratelimit.WithCaller(context.Background(), ratelimit.Caller{GuildID: guildID, ChannelID: channelID, UserID: userID})
+}
+
+// limitError returns err as a *ratelimit.LimitError, failing the test if it isn't one
+func limitError(t *testing.T, err error) *ratelimit.LimitError {
+	t.Helper()
+	var limitErr *ratelimit.LimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("error = %v, want a limit error", err)
+	}
+	return limitErr
+}
+
+func TestRateLimitTokenBuckets(t *testing.T) {
+	limiter, clock := newTestLimiter(t, ratelimit.Limits{
+		User:    ratelimit.Limit{PerMinute: 2, Burst: 2},
+		Channel: ratelimit.Limit{PerMinute: 60, Burst: 3},
+	}, "")
+	alice := callerContext("guild", "general", "alice")
+
+	for i := 0; i < 2; i++ {
+		if err := limiter.Allow(alice); err != nil {
+			t.Fatalf("message %d refused: %v", i+1, err)
+		}
+	}
+	limitErr := limitError(t, limiter.Allow(alice))
+	if limitErr.Scope != ratelimit.ScopeUser || limitErr.Kind != ratelimit.KindRate || limitErr.RetryAfter != 30*time.Second {
+		t.Errorf("error = %+v, want the user rate limit with a 30s wait", limitErr)
+	}
+	if reply := ratelimit.Reply(limitErr); !strings.Contains(reply, "30 seconds") {
+		t.Errorf("reply = %q, want the wait", reply)
+	}
+
+	// Another user may still post, until the channel's burst is used up
+	if err := limiter.Allow(callerContext("guild", "general", "bob")); err != nil {
+		t.Errorf("bob refused: %v", err)
+	}
+	if limitErr := limitError(t, limiter.Allow(callerContext("guild", "general", "carol"))); limitErr.Scope != ratelimit.ScopeChannel {
+		t.Errorf("error = %+v, want the channel rate limit", limitErr)
+	}
+	if err := limiter.Allow(callerContext("guild", "random", "carol")); err != nil {
+		t.Errorf("carol refused in another channel: %v", err)
+	}
+
+	clock.now = clock.now.Add(30 * time.Second)
+	if err := limiter.Allow(alice); err != nil {
+		t.Errorf("alice refused after the bucket refilled: %v", err)
+	}
+	if err := limiter.Allow(context.Background()); err != nil {
+		t.Errorf("request without a caller refused: %v", err)
+	}
+}
+
+func TestRateLimitDailyBudgets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	limits := ratelimit.Limits{
+		User:    ratelimit.Limit{DailyTokens: 1000},
+		Guild:   ratelimit.Limit{DailyCost: 0.01},
+		Pricing: ratelimit.Pricing{InputPerMillion: 1, OutputPerMillion: 4},
+	}
+	limiter, clock := newTestLimiter(t, limits, path)
+	alice := callerContext("guild", "general", "alice")
+
+	limiter.Record(alice, models.Usage{PromptTokens: 900, OutputTokens: 100, TotalTokens: 1000})
+	if limitErr := limitError(t, limiter.Allow(alice)); limitErr.Scope != ratelimit.ScopeUser || limitErr.Kind != ratelimit.KindDailyTokens {
+		t.Errorf("error = %+v, want alice's daily token budget", limitErr)
+	}
+	if err := limiter.Allow(callerContext("guild", "general", "bob")); err != nil {
+		t.Errorf("bob refused: %v", err)
+	}
+
+	// 9000 prompt and 1000 output tokens cost $0.009 + $0.004
+	limiter.Record(callerContext("guild", "general", "bob"), models.Usage{PromptTokens: 9000, OutputTokens: 1000})
+	limitErr := limitError(t, limiter.Allow(callerContext("guild", "random", "carol")))
+	if limitErr.Scope != ratelimit.ScopeGuild || limitErr.Kind != ratelimit.KindDailyCost || !strings.Contains(limitErr.Reply(), "This server") {
+		t.Errorf("error = %+v, want the guild's daily cost budget", limitErr)
+	}
+	if err := limiter.Allow(callerContext("", "dm", "carol")); err != nil {
+		t.Errorf("direct message refused by a guild budget: %v", err)
+	}
+	if usage := limiter.Usage(ratelimit.ScopeGlobal, ""); usage.Tokens != 11000 {
+		t.Errorf("global usage = %+v, want 11000 tokens", usage)
+	}
+
+	// The counters are saved by Flush, not by every request, and survive a restart,
+	// then reset the next day
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("counters saved before a flush: %v", err)
+	}
+	if err := limiter.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	restarted, restartedClock := newTestLimiter(t, limits, path)
+	if err := restarted.Allow(alice); err == nil {
+		t.Error("expected the budget to survive a restart")
+	}
+	restartedClock.now = clock.now.Add(12 * time.Hour)
+	if err := restarted.Allow(alice); err != nil {
+		t.Errorf("alice refused the next day: %v", err)
+	}
+}
+
+func TestRateLimitToolQuota(t *testing.T) {
+	limiter, _ := newTestLimiter(t, ratelimit.Limits{
+		Tools: map[string]ratelimit.ToolQuota{"search": {Daily: 3, PerUserDaily: 2}},
+	}, "")
+	echo := testutil.NewEchoTool("search")
+	search := ratelimit.LimitTool(echo, limiter)
+	unlimited := ratelimit.LimitTool(testutil.NewEchoTool("fetch"), limiter)
+
+	run := func(tool tools.Tool, user string) string {
+		result, err := tool.ARun(callerContext("guild", "general", user), "query")
+		if err != nil {
+			t.Fatalf("ARun failed: %v", err)
+		}
+		return result.ReturnDisplay
+	}
+
+	for i := 0; i < 2; i++ {
+		if got := run(search, "alice"); got != "query" {
+			t.Fatalf("search %d = %q, want the tool's output", i+1, got)
+		}
+	}
+	if got := run(search, "alice"); !strings.HasPrefix(got, "Error: the daily quota of search is used up") {
+		t.Errorf("alice's third search = %q, want the quota error", got)
+	}
+	if got := run(search, "bob"); got != "query" {
+		t.Errorf("bob's search = %q, want the tool's output", got)
+	}
+	if got := run(search, "carol"); !strings.HasPrefix(got, "Error:") {
+		t.Errorf("search beyond the daily quota = %q, want the quota error", got)
+	}
+	if len(echo.Inputs()) != 3 {
+		t.Errorf("search ran %d times, want 3", len(echo.Inputs()))
+	}
+	for i := 0; i < 5; i++ {
+		if got := run(unlimited, "alice"); got != "query" {
+			t.Errorf("tool without a quota = %q", got)
+		}
+	}
+}
+
+func TestRateLimitedMessagesGetAFriendlyReply(t *testing.T) {
+	model := testutil.NewFakeModel(
+		"Thought: Do I need to use a tool? No\nFinal Answer: first",
+		"Thought: Do I need to use a tool? No\nFinal Answer: second",
+	)
+	h := testutil.NewHarness(model, nil, agent.DefaultOptions(), 20)
+	limiter, _ := newTestLimiter(t, ratelimit.Limits{User: ratelimit.Limit{PerMinute: 1, DailyTokens: 1_000_000}}, "")
+	h.Handler.SetLimiter(limiter)
+
+	if got := h.Mention("general", "alice", "hi"); len(got) != 1 || got[0] != "first" {
+		t.Fatalf("replies = %q, want the answer", got)
+	}
+	got := h.Mention("general", "alice", "hi again")
+	if len(got) != 1 || !strings.Contains(got[0], "Please wait 60 seconds") {
+		t.Errorf("replies = %q, want the rate limit message", got)
+	}
+	if len(model.Calls()) != 1 {
+		t.Errorf("model called %d times, want the limited message not to reach it", len(model.Calls()))
+	}
+	if usage := limiter.Usage(ratelimit.ScopeUser, "user-alice"); usage.Tokens == 0 {
+		t.Error("expected the turn's tokens to be counted against alice")
+	}
+}
+
+func TestFailedTurnsAreCharged(t *testing.T) {
+	model := testutil.NewFakeModelResponses(&models.Response{
+		Text:         "Thought: Do I need to use a tool? Yes\nAction: search\nAction Input: cats",
+		FinishReason: models.FinishReasonStop,
+		Usage:        models.Usage{PromptTokens: 100, OutputTokens: 20, TotalTokens: 120},
+	})
+	h := testutil.NewHarness(model, []tools.Tool{testutil.NewEchoTool("search")}, agent.DefaultOptions(), 20)
+	limiter, _ := newTestLimiter(t, ratelimit.Limits{}, "")
+	h.Handler.SetLimiter(limiter)
+
+	// The second step fails, as the model has no response left
+	if got := h.Mention("general", "alice", "cats?"); len(got) != 1 || got[0] != handler.ErrorReply {
+		t.Fatalf("replies = %q, want the error reply", got)
+	}
+	if len(model.Calls()) != 2 {
+		t.Fatalf("model called %d times, want 2", len(model.Calls()))
+	}
+	if usage := limiter.Usage(ratelimit.ScopeUser, "user-alice"); usage.Tokens != 120 {
+		t.Errorf("usage = %+v, want the first step's 120 tokens counted", usage)
+	}
+}
+
+func TestLoadConfigLimits(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfig(t, baseConfig+`limits:
+  user:
+    per_minute: 2
+    burst: 4
+  guild:
+    daily_cost: 5
+  pricing:
+    input_per_million: 0.1
+    output_per_million: 0.4
+  tools:
+    google_search:
+      daily: 100
+  state_path: data/limits.json
+`)
+	t.Setenv("LIMITS_DAILY_TOKENS", "500000")
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	limits := cfg.Limits
+	if limits.User.PerMinute != 2 || limits.User.Burst != 4 || limits.Guild.DailyCost != 5 || limits.Global.DailyTokens != 500000 {
+		t.Errorf("limits = %+v", limits)
+	}
+	if limits.Tools[config.ToolGoogleSearch].Daily != 100 || limits.StatePath != "data/limits.json" {
+		t.Errorf("tools = %+v, state path = %q", limits.Tools, limits.StatePath)
+	}
+
+	path = writeConfig(t, baseConfig+"limits:\n  channel:\n    per_minute: -1\n  global:\n    daily_cost: 1\n  tools:\n    web_search:\n      daily: 1\n")
+	_, err = config.Load(path)
+	for _, want := range []string{"limits.channel.per_minute: must not be negative", "limits.global.daily_cost: requires limits.pricing", `limits.tools.web_search: unknown tool "web_search"`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want %q", err, want)
+		}
+	}
+}
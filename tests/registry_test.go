@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"context"
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/tools"
+	"discord-gemini-bot/src/types"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrentModel always answers and is safe for concurrent use
+type concurrentModel struct {
+	calls atomic.Int64
+}
+
+func (m *concurrentModel) GenerateAsync(ctx context.Context, prompt string, images []map[string]interface{}) (string, error) {
+	m.calls.Add(1)
+	return "Final Answer: ok", nil
+}
+
+func (m *concurrentModel) GenerateWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *models.GenerateOptions) (*models.Response, error) {
+	m.calls.Add(1)
+	time.Sleep(time.Millisecond)
+	return &models.Response{Text: fmt.Sprintf("Final Answer: seen %d messages", len(messages))}, nil
+}
+
+func (m *concurrentModel) StreamWithHistoryAsync(ctx context.Context, messages []*types.Message, opts *models.GenerateOptions, onChunk func(chunk string) error) (*models.Response, error) {
+	response, err := m.GenerateWithHistoryAsync(ctx, messages, opts)
+	if err != nil {
+		return nil, err
+	}
+	return response, onChunk(response.Text)
+}
+
+func (m *concurrentModel) Name() string { return "concurrent" }
+
+func (m *concurrentModel) SetSystemPrompt(systemPrompt string) {}
+
+func TestRegistryConcurrentChannels(t *testing.T) {
+	model := &concurrentModel{}
+	var created atomic.Int64
+	registry := agent.NewRegistry(func(guildID, channelID string) (*agent.Agent, error) {
+		created.Add(1)
+		return agent.NewAgent(model, types.NewConversationMemory(1000), []tools.Tool{}), nil
+	}, time.Hour)
+
+	const channels = 8
+	const messagesPerChannel = 25
+	var inFlight [channels]atomic.Int64
+
+	var wg sync.WaitGroup
+	for c := 0; c < channels; c++ {
+		for i := 0; i < messagesPerChannel; i++ {
+			wg.Add(1)
+			go func(c, i int) {
+				defer wg.Done()
+				err := registry.Do(context.Background(), "", fmt.Sprintf("channel-%d", c), func(a *agent.Agent) error {
+					if n := inFlight[c].Add(1); n != 1 {
+						t.Errorf("Channel %d has %d turns in flight", c, n)
+					}
+					defer inFlight[c].Add(-1)
+
+					a.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: fmt.Sprint(i)}}))
+					_, err := a.GetResponse(context.Background())
+					return err
+				})
+				if err != nil {
+					t.Errorf("Do failed: %v", err)
+				}
+			}(c, i)
+		}
+	}
+	wg.Wait()
+
+	if created.Load() != channels || registry.Len() != channels {
+		t.Errorf("Expected %d agents, created %d, registered %d", channels, created.Load(), registry.Len())
+	}
+	if model.calls.Load() != channels*messagesPerChannel {
+		t.Errorf("Expected %d model calls, got %d", channels*messagesPerChannel, model.calls.Load())
+	}
+}
+
+func TestRegistryHistoryIsNotCorrupted(t *testing.T) {
+	memories := make(map[string]*types.ConversationMemory)
+	var mu sync.Mutex
+	registry := agent.NewRegistry(func(guildID, channelID string) (*agent.Agent, error) {
+		mem := types.NewConversationMemory(1000)
+		mu.Lock()
+		memories[channelID] = mem
+		mu.Unlock()
+		return agent.NewAgent(&concurrentModel{}, mem, nil), nil
+	}, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			registry.Do(context.Background(), "", "shared", func(a *agent.Agent) error {
+				a.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "hi"}}))
+				_, err := a.GetResponse(context.Background())
+				return err
+			})
+		}()
+	}
+	wg.Wait()
+
+	history := memories["shared"].GetHistory()
+	if len(history) != 100 {
+		t.Fatalf("Expected 100 messages, got %d", len(history))
+	}
+	for i := 0; i < len(history); i += 2 {
+		if history[i].Role != "user" || history[i+1].Role != "AI" {
+			t.Fatalf("Turns interleaved at %d: %s then %s", i, history[i].Role, history[i+1].Role)
+		}
+	}
+}
+
+func TestRegistryEvictsIdleAgents(t *testing.T) {
+	var created atomic.Int64
+	var mu sync.Mutex
+	channelTools := make(map[string][]*blockingTool)
+	registry := agent.NewRegistry(func(guildID, channelID string) (*agent.Agent, error) {
+		created.Add(1)
+		tool := newBlockingTool()
+		mu.Lock()
+		channelTools[channelID] = append(channelTools[channelID], tool)
+		mu.Unlock()
+		return agent.NewAgent(&concurrentModel{}, types.NewConversationMemory(10), []tools.Tool{tool}), nil
+	}, 20*time.Millisecond)
+
+	noop := func(a *agent.Agent) error { return nil }
+	registry.Do(context.Background(), "", "idle", noop)
+
+	// An agent in use is never evicted, however long it runs
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go registry.Do(context.Background(), "", "busy", func(a *agent.Agent) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	time.Sleep(40 * time.Millisecond)
+	if evicted := registry.EvictIdle(); evicted != 1 {
+		t.Errorf("Expected 1 idle agent evicted, got %d", evicted)
+	}
+	if registry.Len() != 1 {
+		t.Errorf("Expected the busy agent to remain, got %d agents", registry.Len())
+	}
+	mu.Lock()
+	if !channelTools["idle"][0].closed || channelTools["busy"][0].closed {
+		t.Error("Expected only the evicted agent's tools to be closed")
+	}
+	mu.Unlock()
+	close(release)
+
+	registry.Do(context.Background(), "", "idle", noop)
+	if created.Load() != 3 {
+		t.Errorf("Expected the evicted agent to be recreated, created %d", created.Load())
+	}
+}
+
+func TestRegistryWaitRespectsContext(t *testing.T) {
+	registry := agent.NewRegistry(func(guildID, channelID string) (*agent.Agent, error) {
+		return agent.NewAgent(&concurrentModel{}, types.NewConversationMemory(10), nil), nil
+	}, 0)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go registry.Do(context.Background(), "", "c", func(a *agent.Agent) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := registry.Do(ctx, "", "c", func(a *agent.Agent) error { return nil }); err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded while waiting, got %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package tests
+
+import (
+	"context"
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/config"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/testutil"
+	"discord-gemini-bot/src/tools"
+	"discord-gemini-bot/src/types"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// baseConfig is a valid config file for reload tests
+const baseConfig = `
+discord:
+  token: token
+model:
+  api_key: key
+`
+
+// loadWatcher writes a config file and returns a watcher of the configuration loaded from it
+func loadWatcher(t *testing.T, content string) (*config.Watcher, string) {
+	t.Helper()
+	clearConfigEnv(t)
+	path := writeConfig(t, content)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return config.NewWatcher(path, cfg), path
+}
+
+func TestWatcherReload(t *testing.T) {
+	watcher, path := loadWatcher(t, baseConfig+"agent:\n  persona: Be brief.\n")
+
+	var reloaded []*config.Config
+	watcher.OnReload(func(cfg *config.Config) { reloaded = append(reloaded, cfg) })
+
+	if err := os.WriteFile(path, []byte(baseConfig+"agent:\n  persona: Be verbose.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if got := watcher.Config().Agent.Persona; got != "Be verbose." {
+		t.Errorf("persona = %q after reload", got)
+	}
+	if len(reloaded) != 1 || reloaded[0] != watcher.Config() {
+		t.Errorf("OnReload called with %v, want the new configuration once", reloaded)
+	}
+
+	// A malformed or invalid file is rejected and the last good configuration kept
+	for _, content := range []string{"agent: [unclosed", baseConfig + "agent:\n  max_steps: 0\n"} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := watcher.Reload(); err == nil {
+			t.Errorf("expected reloading %q to fail", content)
+		}
+		if got := watcher.Config().Agent.Persona; got != "Be verbose." {
+			t.Errorf("persona = %q after a rejected reload, want the last good one", got)
+		}
+	}
+	if len(reloaded) != 1 {
+		t.Errorf("OnReload called %d times, want only for the successful reload", len(reloaded))
+	}
+}
+
+func TestWatcherWatchesPromptFiles(t *testing.T) {
+	persona := filepath.Join(t.TempDir(), "persona.md")
+	if err := os.WriteFile(persona, []byte("You are a pirate.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	watcher, path := loadWatcher(t, baseConfig+"agent:\n  persona_file: "+persona+"\n")
+
+	if got := watcher.Config().Agent.Persona; got != "You are a pirate.\n" {
+		t.Fatalf("persona = %q, want the file's contents", got)
+	}
+	if files := watcher.Config().Files(); !reflect.DeepEqual(files, []string{path, persona}) {
+		t.Errorf("files = %q, want the config and persona files", files)
+	}
+
+	reloads := make(chan *config.Config, 10)
+	watcher.OnReload(func(cfg *config.Config) { reloads <- cfg })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Watch(ctx, 5*time.Millisecond)
+
+	if err := os.WriteFile(persona, []byte("You are a very polite butler.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cfg := <-reloads:
+		if cfg.Agent.Persona != "You are a very polite butler.\n" {
+			t.Errorf("persona = %q after the file changed", cfg.Agent.Persona)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the persona file change to be reloaded")
+	}
+}
+
+func TestLoadConfigPersonaFileErrors(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfig(t, baseConfig+`
+agent:
+  persona: inline
+  persona_file: persona.md
+guilds:
+  "100":
+    persona_file: /does/not/exist.md
+`)
+
+	_, err := config.Load(path)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, part := range []string{"agent.persona_file: persona and persona_file can't both be set", "guilds.100.persona_file:"} {
+		if !strings.Contains(err.Error(), part) {
+			t.Errorf("error %q doesn't mention %q", err, part)
+		}
+	}
+}
+
+func TestReloadRestoresGenerationDefaults(t *testing.T) {
+	watcher, path := loadWatcher(t, baseConfig+`
+  generation:
+    temperature: 0.3
+    top_p: 0.5
+channels:
+  dev:
+    generation:
+      top_k: 5
+`)
+	server := newOpenAIServer(t, func(w http.ResponseWriter, body map[string]interface{}) {
+		completion(w, "ok")
+	})
+	openAI, err := models.NewOpenAI(server.URL+"/v1", "", "local-model")
+	if err != nil {
+		t.Fatalf("NewOpenAI failed: %v", err)
+	}
+	model, err := models.NewFallbackModel(models.DefaultRetryPolicy(), openAI)
+	if err != nil {
+		t.Fatalf("NewFallbackModel failed: %v", err)
+	}
+	model.SetGenerateOptions(watcher.Config().Model.Generation)
+	watcher.OnReload(func(cfg *config.Config) { model.SetGenerateOptions(cfg.Model.Generation) })
+
+	generate := func() map[string]interface{} {
+		t.Helper()
+		opts := watcher.Config().Resolve("guild", "dev").Generation
+		if _, err := model.GenerateWithHistoryAsync(context.Background(), nil, &opts); err != nil {
+			t.Fatalf("GenerateWithHistoryAsync failed: %v", err)
+		}
+		return server.requests[len(server.requests)-1]
+	}
+
+	if request := generate(); request["temperature"] != 0.3 || request["top_p"] != 0.5 || request["top_k"] != float64(5) {
+		t.Fatalf("request = %v, want the configured settings", request)
+	}
+
+	// Removing settings from the file restores the model's defaults
+	if err := os.WriteFile(path, []byte(baseConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	request := generate()
+	if request["temperature"] != float64(1) {
+		t.Errorf("temperature = %v, want the default 1", request["temperature"])
+	}
+	for _, key := range []string{"top_p", "top_k"} {
+		if value, found := request[key]; found {
+			t.Errorf("%s = %v after it was removed, want it unset", key, value)
+		}
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	previous, next := config.Default(), config.Default()
+	next.Model.Name = "gemini-2.0-flash"
+	next.Memory.WindowSize = 50
+	next.Agent.Persona = "Be brief."
+	next.Discord.TurnTimeout = time.Minute * 2
+
+	if keys := config.RestartRequired(previous, next); !reflect.DeepEqual(keys, []string{"model.name", "memory"}) {
+		t.Errorf("RestartRequired = %q", keys)
+	}
+}
+
+func TestRegistryReconfigureKeepsMemory(t *testing.T) {
+	model := testutil.NewFakeModel("Final Answer: one", "Final Answer: two", "Final Answer: three")
+	persona := "You are a pirate."
+	settings := func() agent.Options {
+		opts := agent.DefaultOptions()
+		opts.Persona = persona
+		return opts
+	}
+
+	registry := agent.NewRegistry(func(guildID, channelID string) (*agent.Agent, error) {
+		return agent.NewAgentWithOptions(model, types.NewConversationMemory(20), nil, settings()), nil
+	}, 0)
+	var reconfigured []string
+	registry.SetConfigurer(func(guildID, channelID string, a *agent.Agent) {
+		reconfigured = append(reconfigured, guildID+"/"+channelID)
+		a.Reconfigure(nil, settings())
+	})
+
+	turn := func() {
+		t.Helper()
+		err := registry.Do(context.Background(), "guild", "channel", func(a *agent.Agent) error {
+			a.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "hi"}}))
+			_, err := a.GetResponse(context.Background())
+			return err
+		})
+		if err != nil {
+			t.Fatalf("turn failed: %v", err)
+		}
+	}
+
+	turn()
+	persona = "You are a butler."
+	turn()
+	registry.Reconfigure()
+	turn()
+
+	calls := model.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("got %d model calls, want 3", len(calls))
+	}
+	if !strings.HasPrefix(calls[1].Options.SystemPrompt, "You are a pirate.\n\n") {
+		t.Errorf("second turn system prompt = %q, want the old persona until Reconfigure", calls[1].Options.SystemPrompt)
+	}
+	if !strings.HasPrefix(calls[2].Options.SystemPrompt, "You are a butler.\n\n") {
+		t.Errorf("third turn system prompt = %q, want the new persona", calls[2].Options.SystemPrompt)
+	}
+	if len(calls[2].Messages) != 5 {
+		t.Errorf("third turn sent %d messages, want the history kept across the reconfiguration", len(calls[2].Messages))
+	}
+	if !reflect.DeepEqual(reconfigured, []string{"guild/channel"}) {
+		t.Errorf("configurer called for %q, want once for the existing agent", reconfigured)
+	}
+}
+
+func TestReconfigureClosesReplacedTools(t *testing.T) {
+	kept, replaced := newBlockingTool(), newBlockingTool()
+	kept.BaseTool = tools.NewBaseTool("kept", "Stays configured.")
+	a := agent.NewAgent(testutil.NewFakeModel(), types.NewConversationMemory(20), []tools.Tool{kept, replaced})
+
+	replacement := newBlockingTool()
+	a.Reconfigure([]tools.Tool{kept, replacement}, agent.DefaultOptions())
+	if !replaced.closed {
+		t.Error("expected the replaced tool to be closed")
+	}
+	if kept.closed || replacement.closed {
+		t.Error("expected the configured tools to stay open")
+	}
+}
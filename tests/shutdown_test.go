@@ -0,0 +1,235 @@
+package tests
+
+import (
+	"context"
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/handler"
+	"discord-gemini-bot/src/memory"
+	"discord-gemini-bot/src/shutdown"
+	"discord-gemini-bot/src/testutil"
+	"discord-gemini-bot/src/tools"
+	"discord-gemini-bot/src/types"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingTool waits for release or for its context to be done, and records being closed
+type blockingTool struct {
+	*tools.BaseTool
+	started chan struct{}
+	release chan struct{}
+	closed  bool
+}
+
+func newBlockingTool() *blockingTool {
+	return &blockingTool{
+		BaseTool: tools.NewBaseTool("wait", "Waits for a while."),
+		started:  make(chan struct{}, 1),
+		release:  make(chan struct{}),
+	}
+}
+
+func (bt *blockingTool) ARun(ctx context.Context, args ...interface{}) (*tools.ToolResult, error) {
+	bt.started <- struct{}{}
+	select {
+	case <-bt.release:
+		return &tools.ToolResult{ReturnDisplay: "done"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (bt *blockingTool) Close() error {
+	bt.closed = true
+	return nil
+}
+
+// shutdownHarness returns a harness whose turns call the blocking tool once before answering
+func shutdownHarness(tool *blockingTool) *testutil.Harness {
+	model := testutil.NewFakeModel(
+		"Thought: Do I need to use a tool? Yes\nAction: wait\nAction Input: now",
+		"Thought: Do I need to use a tool? No\nFinal Answer: finished in time",
+	)
+	return testutil.NewHarness(model, []tools.Tool{tool}, agent.DefaultOptions(), 20)
+}
+
+func TestShutdownWaitsForRunningTurns(t *testing.T) {
+	coordinator := shutdown.NewCoordinator()
+	tool := newBlockingTool()
+	h := shutdownHarness(tool)
+	h.Handler.SetCoordinator(coordinator)
+
+	replies := make(chan []string)
+	go func() { replies <- h.Mention("busy", "alice", "take your time") }()
+	<-tool.started
+
+	reports := make(chan *shutdown.Report)
+	go func() { reports <- coordinator.Shutdown(time.Minute) }()
+	for !coordinator.Stopping() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// New messages are declined while the running turn finishes
+	if got := h.Mention("other", "bob", "hello?"); len(got) != 1 || got[0] != handler.ShutdownReply {
+		t.Errorf("replies during shutdown = %q, want the shutdown reply", got)
+	}
+
+	close(tool.release)
+	if got := <-replies; len(got) != 1 || got[0] != "finished in time" {
+		t.Errorf("replies = %q, want the running turn's answer", got)
+	}
+	report := <-reports
+	if report.Finished != 1 || len(report.Cancelled) != 0 || len(report.Abandoned) != 0 {
+		t.Errorf("report = %+v, want one finished turn", report)
+	}
+}
+
+func TestShutdownCancelsTurnsAtTheDeadline(t *testing.T) {
+	coordinator := shutdown.NewCoordinator()
+	tool := newBlockingTool()
+	h := shutdownHarness(tool)
+	h.Handler.SetCoordinator(coordinator)
+
+	replies := make(chan []string)
+	go func() { replies <- h.Mention("slow", "alice", "take forever") }()
+	<-tool.started
+
+	report := coordinator.Shutdown(10 * time.Millisecond)
+	if report.Finished != 0 || !slices.Equal(report.Cancelled, []string{"message in channel slow"}) || len(report.Abandoned) != 0 {
+		t.Errorf("report = %+v, want the slow turn cancelled", report)
+	}
+	if got := <-replies; len(got) != 1 || got[0] != handler.ShutdownReply {
+		t.Errorf("replies = %q, want the shutdown reply", got)
+	}
+}
+
+func TestShutdownCoordinator(t *testing.T) {
+	coordinator := shutdown.NewCoordinator()
+	coordinator.SetCancelGrace(10 * time.Millisecond)
+
+	ctx, done, err := coordinator.Begin(context.Background(), "stuck")
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	var order []string
+	coordinator.OnShutdown("first", func() error {
+		order = append(order, "first")
+		return nil
+	})
+	coordinator.OnShutdown("second", func() error {
+		order = append(order, "second")
+		return errors.New("boom")
+	})
+
+	// The task ignores its cancellation until after the grace period
+	report := coordinator.Shutdown(10 * time.Millisecond)
+	if !errors.Is(context.Cause(ctx), shutdown.ErrDeadline) {
+		t.Errorf("cause = %v, want the shutdown deadline", context.Cause(ctx))
+	}
+	if !slices.Equal(report.Cancelled, []string{"stuck"}) || !slices.Equal(report.Abandoned, []string{"stuck"}) {
+		t.Errorf("report = %+v, want the stuck task cancelled and abandoned", report)
+	}
+	if !slices.Equal(order, []string{"first", "second"}) {
+		t.Errorf("hooks ran in order %v", order)
+	}
+	if len(report.Errors) != 1 || !strings.Contains(report.Errors[0].Error(), "error closing second: boom") {
+		t.Errorf("errors = %v, want the second hook's error", report.Errors)
+	}
+	done()
+	done()
+
+	if _, _, err := coordinator.Begin(context.Background(), "late"); !errors.Is(err, shutdown.ErrShuttingDown) {
+		t.Errorf("Begin after shutdown = %v, want ErrShuttingDown", err)
+	}
+}
+
+func TestRegistryCloseFlushesMemoryAndClosesTools(t *testing.T) {
+	store, err := memory.NewJSONFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := newBlockingTool()
+	registry := agent.NewRegistry(func(guildID, channelID string) (*agent.Agent, error) {
+		channelMemory, err := types.NewPersistentConversationMemory(20, store, channelID)
+		if err != nil {
+			return nil, err
+		}
+		return agent.NewAgent(testutil.NewFakeModel(), channelMemory, []tools.Tool{tool}), nil
+	}, time.Hour)
+
+	err = registry.Do(context.Background(), "", "saved", func(a *agent.Agent) error {
+		a.AddMessage(context.Background(), types.NewMessage("alice", []types.MessageContent{{Type: "text", Content: "remember me"}}))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	if err := store.Delete("saved"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	snapshot, err := store.Load("saved")
+	if err != nil || snapshot == nil || len(snapshot.Messages) != 1 {
+		t.Errorf("stored memory = %+v, %v, want the flushed message", snapshot, err)
+	}
+	if !tool.closed {
+		t.Error("expected the agent's tools to be closed")
+	}
+	if registry.Len() != 0 {
+		t.Errorf("Len = %d, want the agents removed", registry.Len())
+	}
+
+	err = registry.Do(context.Background(), "", "saved", func(a *agent.Agent) error { return nil })
+	if !errors.Is(err, agent.ErrRegistryClosed) {
+		t.Errorf("Do after Close = %v, want ErrRegistryClosed", err)
+	}
+}
+
+func TestRegistryFlushSkipsBusyAgents(t *testing.T) {
+	store, err := memory.NewJSONFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := agent.NewRegistry(func(guildID, channelID string) (*agent.Agent, error) {
+		channelMemory, err := types.NewPersistentConversationMemory(20, store, channelID)
+		if err != nil {
+			return nil, err
+		}
+		return agent.NewAgent(testutil.NewFakeModel(), channelMemory, nil), nil
+	}, time.Hour)
+	defer registry.Close(context.Background())
+
+	for _, channelID := range []string{"idle", "busy"} {
+		err := registry.Do(context.Background(), "", channelID, func(a *agent.Agent) error {
+			a.AddMessage(context.Background(), types.NewMessage("alice", []types.MessageContent{{Type: "text", Content: "remember me"}}))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Do failed: %v", err)
+		}
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	go registry.Do(context.Background(), "", "busy", func(a *agent.Agent) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	if err := registry.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if snapshot, err := store.Load("idle"); err != nil || snapshot == nil || len(snapshot.Messages) != 1 {
+		t.Errorf("idle memory = %+v, %v, want the flushed message", snapshot, err)
+	}
+	if snapshot, err := store.Load("busy"); err != nil || snapshot != nil {
+		t.Errorf("busy memory = %+v, %v, want it left for a later flush", snapshot, err)
+	}
+	close(release)
+}
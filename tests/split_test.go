@@ -0,0 +1,233 @@
+package tests
+
+import (
+	"discord-gemini-bot/src/utils"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitLongText(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		maxLength int
+		want      []string
+	}{
+		{
+			name:      "empty",
+			text:      "",
+			maxLength: 10,
+			want:      nil,
+		},
+		{
+			name:      "fits",
+			text:      "Hello there",
+			maxLength: 20,
+			want:      []string{"Hello there"},
+		},
+		{
+			name:      "no limit",
+			text:      "Hello there",
+			maxLength: 0,
+			want:      []string{"Hello there"},
+		},
+		{
+			name:      "paragraphs",
+			text:      "First paragraph here.\n\nSecond paragraph here.",
+			maxLength: 30,
+			want:      []string{"First paragraph here.", "Second paragraph here."},
+		},
+		{
+			name:      "lines",
+			text:      "line one is here\nline two is here",
+			maxLength: 20,
+			want:      []string{"line one is here", "line two is here"},
+		},
+		{
+			name:      "sentences",
+			text:      "This is one. This is two. This is three.",
+			maxLength: 30,
+			want:      []string{"This is one. This is two.", "This is three."},
+		},
+		{
+			name:      "words",
+			text:      "alpha beta gamma delta epsilon",
+			maxLength: 12,
+			want:      []string{"alpha beta", "gamma delta", "epsilon"},
+		},
+		{
+			name:      "hard cut without spaces",
+			text:      "abcdefghijkl",
+			maxLength: 5,
+			want:      []string{"abcde", "fghij", "kl"},
+		},
+		{
+			name:      "multi-byte runes",
+			text:      "héllöwörldé",
+			maxLength: 4,
+			want:      []string{"héll", "öwör", "ldé"},
+		},
+		{
+			name:      "counts runes not bytes",
+			text:      "日本語のテキスト",
+			maxLength: 8,
+			want:      []string{"日本語のテキスト"},
+		},
+		{
+			name:      "code fence reopened with language",
+			text:      "```go\nfmt.Println(1)\nfmt.Println(2)\n```",
+			maxLength: 30,
+			want:      []string{"```go\nfmt.Println(1)\n```", "```go\nfmt.Println(2)\n```"},
+		},
+		{
+			name:      "code fence without language",
+			text:      "Code:\n```\naaaa bbbb\ncccc dddd\n```\nDone.",
+			maxLength: 24,
+			want:      []string{"Code:\n```\naaaa bbbb\n```", "```\ncccc dddd\n```\nDone."},
+		},
+		{
+			name:      "inline triple backticks",
+			text:      "Use ```x``` here. Then more words follow.",
+			maxLength: 24,
+			want:      []string{"Use ```x``` here.", "Then more words follow."},
+		},
+		{
+			name:      "user mention",
+			text:      "hello <@123456789012345678>",
+			maxLength: 22,
+			want:      []string{"hello", "<@123456789012345678>"},
+		},
+		{
+			name:      "mention longer than the limit",
+			text:      "abc<@123456>def",
+			maxLength: 8,
+			want:      []string{"abc", "<@123456", ">def"},
+		},
+		{
+			name:      "custom emoji",
+			text:      "xx<:party:1234567>yy",
+			maxLength: 10,
+			want:      []string{"xx", "<:party:12", "34567>yy"},
+		},
+		{
+			name:      "animated emoji at the limit",
+			text:      "ab<a:wave:12>cd",
+			maxLength: 13,
+			want:      []string{"ab<a:wave:12>", "cd"},
+		},
+		{
+			name:      "channel and role mentions",
+			text:      "see<#42>and<@&77>",
+			maxLength: 13,
+			want:      []string{"see<#42>and", "<@&77>"},
+		},
+		{
+			name:      "emoji zero width joiner sequence",
+			text:      "ab👨‍👩‍👧cd",
+			maxLength: 5,
+			want:      []string{"ab", "👨‍👩‍👧", "cd"},
+		},
+		{
+			name:      "emoji skin tone",
+			text:      "abc👍🏽d",
+			maxLength: 4,
+			want:      []string{"abc", "👍🏽d"},
+		},
+		{
+			name:      "flags",
+			text:      "a🇺🇸🇬🇧",
+			maxLength: 4,
+			want:      []string{"a🇺🇸", "🇬🇧"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := utils.SplitLongText(tt.text, tt.maxLength)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitLongText(%q, %d) = %q, want %q", tt.text, tt.maxLength, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitLongTextInvariants(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 40; i++ {
+		sb.WriteString("Some prose with a mention <@1234567890> and an emoji <:smile:987654321>. ")
+		sb.WriteString("Ünïcödé wörds 日本語 👍🏽 here!\n\n")
+		if i%5 == 0 {
+			sb.WriteString("```python\nfor i in range(10):\n    print(i)  # a fairly long comment line\n```\n\n")
+		}
+	}
+	text := sb.String()
+	// A long fence info string must not push reopened code blocks past the limit
+	longLang := strings.ReplaceAll(text, "```python", "```"+strings.Repeat("x", 100))
+
+	for _, maxLength := range []int{60, 120, 500, 2000} {
+		for i, chunk := range utils.SplitLongText(longLang, maxLength) {
+			if n := utf8.RuneCountInString(chunk); n > maxLength {
+				t.Errorf("max %d with a long language tag: chunk %d has %d characters", maxLength, i, n)
+			}
+			if fences := countFenceLines(chunk); fences%2 != 0 {
+				t.Errorf("max %d with a long language tag: chunk %d has unbalanced code fences:\n%s", maxLength, i, chunk)
+			}
+		}
+
+		chunks := utils.SplitLongText(text, maxLength)
+		for i, chunk := range chunks {
+			if !utf8.ValidString(chunk) {
+				t.Fatalf("max %d: chunk %d is not valid UTF-8", maxLength, i)
+			}
+			if n := utf8.RuneCountInString(chunk); n > maxLength {
+				t.Errorf("max %d: chunk %d has %d characters", maxLength, i, n)
+			}
+			if strings.TrimSpace(chunk) == "" {
+				t.Errorf("max %d: chunk %d is empty", maxLength, i)
+			}
+			if fences := countFenceLines(chunk); fences%2 != 0 {
+				t.Errorf("max %d: chunk %d has unbalanced code fences:\n%s", maxLength, i, chunk)
+			}
+			if strings.Count(chunk, "<@") != strings.Count(chunk, "<@1234567890>") {
+				t.Errorf("max %d: chunk %d cuts a mention:\n%s", maxLength, i, chunk)
+			}
+			if strings.Count(chunk, "<:") != strings.Count(chunk, "<:smile:987654321>") {
+				t.Errorf("max %d: chunk %d cuts an emoji:\n%s", maxLength, i, chunk)
+			}
+		}
+
+		// Every word survives the split, and reopened code blocks keep their language
+		if got, want := wordsOutsideFences(strings.Join(chunks, "\n")), wordsOutsideFences(text); got != want {
+			t.Errorf("max %d: content changed by splitting", maxLength)
+		}
+		for i, chunk := range chunks {
+			if strings.HasPrefix(chunk, "```") && !strings.HasPrefix(chunk, "```python\n") {
+				t.Errorf("max %d: chunk %d reopens a code block without its language:\n%s", maxLength, i, chunk)
+			}
+		}
+	}
+}
+
+// wordsOutsideFences returns the words of text, ignoring code fence lines
+func wordsOutsideFences(text string) string {
+	var words []string
+	for _, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "```") {
+			words = append(words, strings.Fields(line)...)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// countFenceLines counts the lines that open or close a code block
+func countFenceLines(text string) int {
+	count := 0
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			count++
+		}
+	}
+	return count
+}
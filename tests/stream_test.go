@@ -0,0 +1,232 @@
+package tests
+
+import (
+	"context"
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/discordbot"
+	"discord-gemini-bot/src/tools"
+	"discord-gemini-bot/src/types"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// recordingSender records the messages a streaming message sends, edits and deletes
+type recordingSender struct {
+	mu       sync.Mutex
+	nextID   int
+	messages map[string]string
+	order    []string
+	edits    int
+	deletes  int
+}
+
+func newRecordingSender() *recordingSender {
+	return &recordingSender{messages: make(map[string]string)}
+}
+
+func (rs *recordingSender) ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.nextID++
+	id := fmt.Sprintf("m%d", rs.nextID)
+	rs.messages[id] = content
+	rs.order = append(rs.order, id)
+	return &discordgo.Message{ID: id, ChannelID: channelID, Content: content}, nil
+}
+
+func (rs *recordingSender) ChannelMessageEdit(channelID, messageID, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if _, ok := rs.messages[messageID]; !ok {
+		return nil, fmt.Errorf("unknown message %s", messageID)
+	}
+	rs.edits++
+	rs.messages[messageID] = content
+	return &discordgo.Message{ID: messageID, ChannelID: channelID, Content: content}, nil
+}
+
+func (rs *recordingSender) ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.deletes++
+	delete(rs.messages, messageID)
+	for i, id := range rs.order {
+		if id == messageID {
+			rs.order = append(rs.order[:i], rs.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// visible returns the content of the messages still in the channel, in order
+func (rs *recordingSender) visible() []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	contents := make([]string, 0, len(rs.order))
+	for _, id := range rs.order {
+		contents = append(contents, rs.messages[id])
+	}
+	return contents
+}
+
+func TestStreamingAnswer(t *testing.T) {
+	tests := []struct {
+		name    string
+		partial string
+		want    string
+	}{
+		{"empty", "", ""},
+		{"partial thought marker", "Thou", ""},
+		{"partial emphasized marker", "**Thought**", ""},
+		{"thought", "Thought: I should search", ""},
+		{"action", "Thought: search\nAction: google_search\nAction Input: go", ""},
+		{"final answer marker only", "Thought: done\nFinal Answer:", ""},
+		{"partial final answer", "Thought: done\nFinal Answer: Hello the", "Hello the"},
+		{"plain text", "Hello there, how can I help?", "Hello there, how can I help?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := agent.StreamingAnswer(tt.partial); got != tt.want {
+				t.Errorf("StreamingAnswer(%q) = %q, want %q", tt.partial, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgentStreamOnlyShowsFinalAnswer(t *testing.T) {
+	model := &scriptedModel{responses: []string{
+		"Thought: I need to look this up\nAction: echo\nAction Input: weather",
+		"Thought: I know the answer now\nFinal Answer: It is sunny today.",
+	}}
+	ag := agent.NewAgent(model, types.NewConversationMemory(20), []tools.Tool{newEchoTool("echo")})
+	ag.AddMessage(context.Background(), types.NewMessage("user", []types.MessageContent{{Type: "text", Content: "weather?"}}))
+
+	var updates []string
+	response, err := ag.GetResponseStream(context.Background(), func(answer string) {
+		updates = append(updates, answer)
+	})
+	if err != nil {
+		t.Fatalf("GetResponseStream failed: %v", err)
+	}
+
+	if response != "It is sunny today." {
+		t.Errorf("response = %q", response)
+	}
+	if len(updates) < 2 {
+		t.Fatalf("expected the answer to arrive in several updates, got %q", updates)
+	}
+	for _, update := range updates {
+		if strings.Contains(update, "Thought") || strings.Contains(update, "Action") {
+			t.Errorf("update leaked scaffolding: %q", update)
+		}
+		if !strings.HasPrefix("It is sunny today.", update) {
+			t.Errorf("update %q is not a prefix of the answer", update)
+		}
+	}
+	if updates[len(updates)-1] != response {
+		t.Errorf("last update = %q, want %q", updates[len(updates)-1], response)
+	}
+}
+
+func TestStreamingMessageEditsPlaceholder(t *testing.T) {
+	sender := newRecordingSender()
+	reply, err := discordbot.NewStreamingMessage(sender, "c1", "Thinking...", 0)
+	if err != nil {
+		t.Fatalf("NewStreamingMessage failed: %v", err)
+	}
+
+	if got := sender.visible(); len(got) != 1 || got[0] != "Thinking..." {
+		t.Fatalf("placeholder not posted: %q", got)
+	}
+
+	reply.Update("Hel")
+	reply.Update("Hello")
+	if got := sender.visible(); len(got) != 1 || got[0] != "Hello" {
+		t.Errorf("visible = %q, want [Hello]", got)
+	}
+
+	// An empty answer keeps the placeholder instead of sending an empty message
+	reply.Update("")
+	if got := sender.visible(); got[0] != "Thinking..." {
+		t.Errorf("visible = %q, want placeholder", got)
+	}
+
+	if err := reply.Finish("Hello world"); err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	if got := sender.visible(); len(got) != 1 || got[0] != "Hello world" {
+		t.Errorf("visible = %q, want [Hello world]", got)
+	}
+}
+
+func TestStreamingMessageRollsOver(t *testing.T) {
+	sender := newRecordingSender()
+	reply, err := discordbot.NewStreamingMessage(sender, "c1", "Thinking...", 0)
+	if err != nil {
+		t.Fatalf("NewStreamingMessage failed: %v", err)
+	}
+
+	long := strings.Repeat("a", discordbot.MaxMessageLength+500)
+	reply.Update(long)
+	got := sender.visible()
+	if len(got) != 2 {
+		t.Fatalf("expected the reply to roll over into 2 messages, got %d", len(got))
+	}
+	if strings.Join(got, "") != long {
+		t.Error("rolled over messages do not add up to the text")
+	}
+
+	// A shorter final text removes the follow-up message
+	if err := reply.Finish("short"); err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	if got := sender.visible(); len(got) != 1 || got[0] != "short" {
+		t.Errorf("visible = %q, want [short]", got)
+	}
+	if sender.deletes != 1 {
+		t.Errorf("deletes = %d, want 1", sender.deletes)
+	}
+}
+
+func TestStreamingMessageThrottlesEdits(t *testing.T) {
+	sender := newRecordingSender()
+	reply, err := discordbot.NewStreamingMessage(sender, "c1", "Thinking...", 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewStreamingMessage failed: %v", err)
+	}
+
+	for i := 1; i <= 20; i++ {
+		reply.Update(strings.Repeat("x", i))
+	}
+	sender.mu.Lock()
+	edits := sender.edits
+	sender.mu.Unlock()
+	if edits != 0 {
+		t.Errorf("edits within the interval = %d, want 0", edits)
+	}
+
+	// The latest text is sent once the interval has passed
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && sender.visible()[0] != strings.Repeat("x", 20) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := sender.visible()[0]; got != strings.Repeat("x", 20) {
+		t.Errorf("visible = %q, want the latest update", got)
+	}
+
+	if err := reply.Finish("done"); err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	reply.Update("late")
+	time.Sleep(100 * time.Millisecond)
+	if got := sender.visible(); got[0] != "done" {
+		t.Errorf("update after Finish changed the message: %q", got)
+	}
+}
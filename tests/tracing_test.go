@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"discord-gemini-bot/src/agent"
+	"discord-gemini-bot/src/models"
+	"discord-gemini-bot/src/testutil"
+	"discord-gemini-bot/src/tools"
+	"discord-gemini-bot/src/tracing"
+	"discord-gemini-bot/src/types"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider recording every span for the duration of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	previous := otel.GetTracerProvider()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+// spanAttribute returns the value of the span's attribute with the key
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingSpansOfAMessage(t *testing.T) {
+	recorder := recordSpans(t)
+
+	// The first request fails with a retryable error
+	responses := []string{
+		"",
+		"Thought: Do I need to use a tool? Yes\nAction: echo\nAction Input: hello",
+		"Thought: Do I need to use a tool? No\nFinal Answer: hello",
+	}
+	fake := testutil.NewFakeModelFunc(func(messages []*types.Message) (string, error) {
+		response := responses[0]
+		responses = responses[1:]
+		if response == "" {
+			return "", &models.StatusError{StatusCode: 503}
+		}
+		return response, nil
+	})
+	model, err := models.NewFallbackModel(fastRetries, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := testutil.NewHarness(model, []tools.Tool{testutil.NewEchoTool("echo")}, agent.DefaultOptions(), 20)
+	h.Mention("trace-channel", "alice", "say hello")
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+
+	if len(spans["Discord.Message"]) != 1 || len(spans["Agent.GetResponse"]) != 1 || len(spans["Model.Generate"]) != 3 || len(spans["Tool.ARun"]) != 1 || len(spans["Discord.SendReply"]) != 1 {
+		t.Fatalf("spans = %v, want a message, agent, reply, tool and three model attempt spans", spans)
+	}
+	root := spans["Discord.Message"][0]
+	agentSpan := spans["Agent.GetResponse"][0]
+	if root.Parent().IsValid() {
+		t.Error("expected the message span to be a root span")
+	}
+	if spanAttribute(root, tracing.ChannelID).AsString() != "trace-channel" || spanAttribute(root, tracing.RequestID).AsString() == "" {
+		t.Errorf("message span attributes = %v", root.Attributes())
+	}
+	if agentSpan.Parent().SpanID() != root.SpanContext().SpanID() || spans["Discord.SendReply"][0].Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Error("expected the agent and reply spans to be children of the message span")
+	}
+	if spanAttribute(agentSpan, tracing.AgentSteps).AsInt64() != 2 || spanAttribute(agentSpan, tracing.OutputTokens).AsInt64() == 0 {
+		t.Errorf("agent span attributes = %v", agentSpan.Attributes())
+	}
+
+	for i, attempt := range spans["Model.Generate"] {
+		if attempt.Parent().SpanID() != agentSpan.SpanContext().SpanID() || spanAttribute(attempt, tracing.Model).AsString() != "fake" {
+			t.Errorf("model span %d = %v, want a child of the agent span", i, attempt.Attributes())
+		}
+	}
+	if failed := spans["Model.Generate"][0]; failed.Status().Code != codes.Error || spanAttribute(failed, tracing.Attempt).AsInt64() != 1 {
+		t.Errorf("first attempt status = %v, want the retried error", failed.Status())
+	}
+	if retried := spans["Model.Generate"][1]; retried.Status().Code == codes.Error || spanAttribute(retried, tracing.InputTokens).AsInt64() == 0 {
+		t.Errorf("second attempt = %v %v, want a success with token counts", retried.Status(), retried.Attributes())
+	}
+
+	tool := spans["Tool.ARun"][0]
+	if tool.Parent().SpanID() != agentSpan.SpanContext().SpanID() || spanAttribute(tool, tracing.ToolName).AsString() != "echo" || spanAttribute(tool, tracing.ToolOutcome).AsString() != "ok" {
+		t.Errorf("tool span = %v, want the echo tool under the agent span", tool.Attributes())
+	}
+}
+
+func TestTracingStdoutExporter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	var buf bytes.Buffer
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{Exporter: tracing.ExporterStdout, SampleRatio: 1, Writer: &buf})
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	_, span := tracing.Start(context.Background(), "Tool.ARun", tracing.ToolName.String("url_fetch"))
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), `"Name":"Tool.ARun"`) || !strings.Contains(buf.String(), "url_fetch") {
+		t.Errorf("stdout exporter wrote %q, want the span", buf.String())
+	}
+
+	if _, err := tracing.Setup(context.Background(), tracing.Options{Exporter: "zipkin"}); err == nil {
+		t.Error("expected an unknown exporter to be rejected")
+	}
+}